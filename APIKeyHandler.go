@@ -0,0 +1,246 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// APIKeysHandler lets the caller manage their own API keys.
+func (s *Server) APIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.listMyAPIKeys(w, r)
+	case http.MethodPost:
+		s.createAPIKey(w, r)
+	case http.MethodDelete:
+		s.revokeMyAPIKey(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) listMyAPIKeys(w http.ResponseWriter, r *http.Request) {
+	claims, ok := GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	keys, err := s.APIKeys.ListByUser(r.Context(), claims.ID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve API keys", http.StatusInternalServerError)
+		return
+	}
+
+	CustomJsonResponse(w, http.StatusOK, keys)
+}
+
+// createAPIKey mints a key limited to scopes the caller's role grants. The
+// key is returned once and only its hash is kept.
+func (s *Server) createAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
+		errs := make(map[string]string)
+
+		for _, err := range err.(validator.ValidationErrors) {
+			field := err.Field()
+			if strings.HasPrefix(field, "Scopes[") {
+				field = "Scopes"
+			}
+			switch err.Tag() {
+			case "required":
+				errs[field] = fmt.Sprintf("%s is required", field)
+			case "min":
+				errs[field] = fmt.Sprintf("%s must list at least %s permission", field, err.Param())
+			case "max":
+				errs[field] = fmt.Sprintf("%s must be at most %s characters long", field, err.Param())
+			}
+		}
+
+		CustomJsonResponse(w, http.StatusBadRequest, errs)
+		return
+	}
+
+	claims, ok := GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Keys act as if they passed two-factor authentication, so roles that
+	// require it can only mint them from such a session.
+	if claims.Role.RequiresMFA() && !claims.MFA {
+		http.Error(w, "Two-factor authentication is required for this role", http.StatusForbidden)
+		return
+	}
+
+	errs := make(map[string]string)
+	for _, scope := range req.Scopes {
+		if !scope.Valid() {
+			errs["Scopes"] = fmt.Sprintf("%q is not a permission", scope)
+			break
+		}
+		if !claims.Role.Can(scope) {
+			errs["Scopes"] = fmt.Sprintf("Your role does not grant %s", scope)
+			break
+		}
+	}
+
+	now := time.Now()
+	expiresAt := now.Add(s.Config.APIKeyMaxTTL)
+	if req.ExpiresAt != nil {
+		switch {
+		case !req.ExpiresAt.After(now):
+			errs["ExpiresAt"] = "ExpiresAt must be in the future"
+		case req.ExpiresAt.After(expiresAt):
+			errs["ExpiresAt"] = fmt.Sprintf("ExpiresAt must be within %s", s.Config.APIKeyMaxTTL)
+		default:
+			expiresAt = *req.ExpiresAt
+		}
+	}
+
+	if len(errs) > 0 {
+		CustomJsonResponse(w, http.StatusBadRequest, errs)
+		return
+	}
+
+	key, prefix, keyHash, err := generateAPIKey()
+	if err != nil {
+		http.Error(w, "Failed to generate API key", http.StatusInternalServerError)
+		return
+	}
+
+	apiKey := APIKey{
+		UserID:    claims.ID,
+		Name:      req.Name,
+		Prefix:    prefix,
+		KeyHash:   keyHash,
+		Scopes:    req.Scopes,
+		ExpiresAt: expiresAt,
+	}
+	if err := s.APIKeys.Create(r.Context(), &apiKey); err != nil {
+		http.Error(w, "Failed to save API key", http.StatusInternalServerError)
+		return
+	}
+
+	CustomJsonResponse(w, http.StatusCreated, CreatedAPIKey{APIKey: apiKey, Key: key})
+}
+
+func (s *Server) revokeMyAPIKey(w http.ResponseWriter, r *http.Request) {
+	claims, ok := GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	key, ok := s.apiKeyFromQuery(w, r)
+	if !ok {
+		return
+	}
+	// Other users' keys are reported as missing rather than forbidden, so
+	// key IDs cannot be probed.
+	if key.UserID != claims.ID {
+		http.Error(w, "API key not found", http.StatusNotFound)
+		return
+	}
+
+	s.revokeAPIKey(w, r, key)
+}
+
+// UserAPIKeysHandler lets admins list and revoke any user's API keys.
+func (s *Server) UserAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.listUserAPIKeys(w, r)
+	case http.MethodDelete:
+		s.revokeUserAPIKey(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) listUserAPIKeys(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.URL.Query().Get("userId"))
+	if err != nil || userID <= 0 {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := s.Users.Get(r.Context(), userID); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to retrieve user", http.StatusInternalServerError)
+		return
+	}
+
+	keys, err := s.APIKeys.ListByUser(r.Context(), userID)
+	if err != nil {
+		http.Error(w, "Failed to retrieve API keys", http.StatusInternalServerError)
+		return
+	}
+
+	CustomJsonResponse(w, http.StatusOK, keys)
+}
+
+func (s *Server) revokeUserAPIKey(w http.ResponseWriter, r *http.Request) {
+	key, ok := s.apiKeyFromQuery(w, r)
+	if !ok {
+		return
+	}
+
+	if claims, ok := GetUserFromContext(r.Context()); ok {
+		log.Printf("user %d revoked API key %d of user %d", claims.ID, key.ID, key.UserID)
+	}
+
+	s.revokeAPIKey(w, r, key)
+}
+
+// apiKeyFromQuery loads the key named by the id query parameter. On failure
+// it writes the error response and returns false.
+func (s *Server) apiKeyFromQuery(w http.ResponseWriter, r *http.Request) (APIKey, bool) {
+	keyID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil || keyID <= 0 {
+		http.Error(w, "Invalid API key ID", http.StatusBadRequest)
+		return APIKey{}, false
+	}
+
+	key, err := s.APIKeys.Get(r.Context(), keyID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "API key not found", http.StatusNotFound)
+			return APIKey{}, false
+		}
+		http.Error(w, "Failed to retrieve API key", http.StatusInternalServerError)
+		return APIKey{}, false
+	}
+
+	return key, true
+}
+
+func (s *Server) revokeAPIKey(w http.ResponseWriter, r *http.Request, key APIKey) {
+	if err := s.APIKeys.Revoke(r.Context(), key.ID); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "API key not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to revoke API key", http.StatusInternalServerError)
+		return
+	}
+
+	CustomJsonResponse(w, http.StatusOK, Success{Completed: true, Message: "API key revoked successfully"})
+}
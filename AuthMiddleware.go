@@ -2,47 +2,203 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"crypto/subtle"
+	"errors"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 )
 
-func AuthMiddleware(next http.Handler) http.Handler {
+func (s *Server) AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var claims *UserClaims
+		var ok bool
+
+		apiKey := r.Header.Get("X-API-Key")
 		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
+		tokenString, isBearer := strings.CutPrefix(authHeader, "Bearer ")
+		sessionCookie, _ := r.Cookie(sessionCookieName)
+
+		switch {
+		case apiKey != "":
+			claims, ok = s.apiKeyClaims(w, r, apiKey)
+		case isBearer && isAPIKey(tokenString):
+			claims, ok = s.apiKeyClaims(w, r, tokenString)
+		case isBearer:
+			claims, ok = s.accessTokenClaims(w, r, tokenString)
+		case sessionCookie != nil:
+			claims, ok = s.sessionClaims(w, r, sessionCookie.Value)
+		default:
 			http.Error(w, "Missing or invalid token", http.StatusUnauthorized)
 			return
 		}
-
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-
-		token, err := jwt.ParseWithClaims(tokenString, &UserClaims{}, func(token *jwt.Token) (interface{}, error) {
-			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, fmt.Errorf("unexpected signing method")
-			}
-			return []byte(JWT_SECRET), nil
-		})
-		if err != nil || !token.Valid {
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
+		if !ok {
 			return
 		}
 
-		claims, ok := token.Claims.(*UserClaims)
-		if !ok {
-			http.Error(w, "Invalid token", http.StatusUnauthorized)
+		active, err := s.userStatus.IsActive(r.Context(), claims.ID)
+		if err != nil {
+			log.Println(err)
+			http.Error(w, "Failed to verify token", http.StatusInternalServerError)
+			return
+		}
+		if !active {
+			http.Error(w, "Account is deactivated", http.StatusUnauthorized)
 			return
 		}
-
-		log.Println(">>>>>>>>>>>>>>>>>>>")
-		log.Printf("%+v", claims)
-		log.Println(">>>>>>>>>>>>>>>>>>>")
 
 		// Set the user ID in the context
 		ctx := context.WithValue(r.Context(), "username", claims)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
+
+// accessTokenClaims verifies a JWT access token. On failure it writes the
+// error response and returns false.
+func (s *Server) accessTokenClaims(w http.ResponseWriter, r *http.Request, tokenString string) (*UserClaims, bool) {
+	token, err := jwt.ParseWithClaims(tokenString, &UserClaims{}, s.Keys.Keyfunc, jwt.WithValidMethods(s.Keys.Methods()),
+		jwt.WithAudience(accessTokenAudience), jwt.WithIssuer(s.tokenIssuer()))
+	if err != nil || !token.Valid {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	claims, ok := token.Claims.(*UserClaims)
+	if !ok || claims.RegisteredClaims.ID == "" {
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return nil, false
+	}
+	// Scopes only ever come from API keys.
+	claims.Scopes = nil
+
+	revoked, err := s.Denylist.IsRevoked(r.Context(), claims)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Failed to verify token", http.StatusInternalServerError)
+		return nil, false
+	}
+	if revoked {
+		http.Error(w, "Token has been revoked", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	return claims, true
+}
+
+// apiKeyLastUsedGranularity limits how often a busy key writes its
+// last-used time.
+const apiKeyLastUsedGranularity = time.Minute
+
+// apiKeyClaims builds claims for an API key from the owner's current role,
+// so demoting the owner also narrows the key. On failure it writes the error
+// response and returns false.
+func (s *Server) apiKeyClaims(w http.ResponseWriter, r *http.Request, key string) (*UserClaims, bool) {
+	prefix, ok := parseAPIKeyPrefix(key)
+	if !ok {
+		http.Error(w, "Invalid API key", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	apiKey, err := s.APIKeys.GetByPrefix(r.Context(), prefix)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		log.Println(err)
+		http.Error(w, "Failed to verify API key", http.StatusInternalServerError)
+		return nil, false
+	}
+	if err != nil || subtle.ConstantTimeCompare([]byte(hashToken(key)), []byte(apiKey.KeyHash)) != 1 {
+		http.Error(w, "Invalid API key", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	now := time.Now()
+	if apiKey.RevokedAt != nil {
+		http.Error(w, "API key has been revoked", http.StatusUnauthorized)
+		return nil, false
+	}
+	if !now.Before(apiKey.ExpiresAt) {
+		http.Error(w, "API key has expired", http.StatusUnauthorized)
+		return nil, false
+	}
+
+	user, err := s.Users.Get(r.Context(), apiKey.UserID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "Invalid API key", http.StatusUnauthorized)
+			return nil, false
+		}
+		log.Println(err)
+		http.Error(w, "Failed to verify API key", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) >= apiKeyLastUsedGranularity {
+		if err := s.APIKeys.Touch(r.Context(), apiKey.ID, now); err != nil {
+			log.Println(err)
+		}
+	}
+
+	return &UserClaims{
+		ID:       user.ID,
+		Username: user.Username,
+		Role:     user.Role,
+		// Roles that require two-factor authentication can only mint keys
+		// from a session that passed it.
+		MFA:      true,
+		Scopes:   apiKey.Scopes,
+		APIKeyID: apiKey.ID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.Itoa(user.ID),
+			ExpiresAt: jwt.NewNumericDate(apiKey.ExpiresAt),
+		},
+	}, true
+}
+
+// sessionClaims builds claims for a cookie session and enforces CSRF
+// protection on unsafe methods. On failure it writes the error response and
+// returns false.
+func (s *Server) sessionClaims(w http.ResponseWriter, r *http.Request, token string) (*UserClaims, bool) {
+	session, err := s.Sessions.Get(r.Context(), hashToken(token))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			s.clearSessionCookies(w)
+			http.Error(w, "Invalid or expired session", http.StatusUnauthorized)
+			return nil, false
+		}
+		log.Println(err)
+		http.Error(w, "Failed to verify session", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	if !isSafeMethod(r.Method) && !validCSRF(r, session) {
+		http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+		return nil, false
+	}
+
+	user, err := s.Users.Get(r.Context(), session.UserID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "Invalid or expired session", http.StatusUnauthorized)
+			return nil, false
+		}
+		log.Println(err)
+		http.Error(w, "Failed to verify session", http.StatusInternalServerError)
+		return nil, false
+	}
+
+	return &UserClaims{
+		ID:        user.ID,
+		Username:  user.Username,
+		Role:      user.Role,
+		MFA:       session.MFA,
+		SessionID: session.ID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.Itoa(user.ID),
+			IssuedAt:  jwt.NewNumericDate(session.CreatedAt),
+			ExpiresAt: jwt.NewNumericDate(session.ExpiresAt),
+		},
+	}, true
+}
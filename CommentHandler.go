@@ -0,0 +1,348 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+const (
+	// defaultCommentDepth and maxCommentDepth bound how many levels of
+	// replies a thread listing nests under each comment.
+	defaultCommentDepth = 3
+	maxCommentDepth     = 10
+)
+
+// CommentsHandler lists and creates the comments on the post in the path.
+func (s *Server) CommentsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.listComments(w, r)
+	case http.MethodPost:
+		s.createComment(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// listComments pages through the top-level comments, or the replies to
+// parentId, with their replies nested depth levels deep. Deeper replies are
+// left out; their parent's replyCount shows they exist.
+func (s *Server) listComments(w http.ResponseWriter, r *http.Request) {
+	post, ok := s.postFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	q, ok := parseListQuery(w, r, commentSortFields, "createdAt")
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	errs := make(map[string]string)
+
+	depth := defaultCommentDepth
+	if value := query.Get("depth"); value != "" {
+		var err error
+		depth, err = strconv.Atoi(value)
+		if err != nil || depth < 0 || depth > maxCommentDepth {
+			errs["depth"] = fmt.Sprintf("depth must be between 0 and %d", maxCommentDepth)
+		}
+	}
+
+	parentID := 0
+	if value := query.Get("parentId"); value != "" {
+		var err error
+		parentID, err = strconv.Atoi(value)
+		if err != nil || parentID <= 0 {
+			errs["parentId"] = "parentId must be a positive integer"
+		}
+	}
+
+	if len(errs) > 0 {
+		CustomJsonResponse(w, http.StatusBadRequest, errs)
+		return
+	}
+
+	if parentID != 0 {
+		if _, ok := s.postComment(w, r, post.ID, parentID); !ok {
+			return
+		}
+	}
+
+	comments, err := s.Comments.List(r.Context(), post.ID, parentID, q)
+	if err != nil {
+		http.Error(w, "Failed to get comments from DB", http.StatusInternalServerError)
+		return
+	}
+	page := newPage(comments, q, commentSortFields[q.Sort])
+
+	if depth > 0 && len(page.Data) > 0 {
+		ids := make([]int, len(page.Data))
+		for i, comment := range page.Data {
+			ids[i] = comment.ID
+		}
+
+		replies, err := s.Comments.Descendants(r.Context(), ids, depth)
+		if err != nil {
+			http.Error(w, "Failed to get comments from DB", http.StatusInternalServerError)
+			return
+		}
+		nestReplies(page.Data, replies)
+	}
+
+	writePage(w, r, page)
+}
+
+// nestReplies fills in the Replies of comments, recursively, from replies.
+func nestReplies(comments, replies []Comment) {
+	children := make(map[int][]Comment)
+	for _, reply := range replies {
+		children[parentOf(reply)] = append(children[parentOf(reply)], reply)
+	}
+
+	var nest func(comments []Comment)
+	nest = func(comments []Comment) {
+		for i := range comments {
+			comments[i].Replies = children[comments[i].ID]
+			nest(comments[i].Replies)
+		}
+	}
+	nest(comments)
+}
+
+func (s *Server) createComment(w http.ResponseWriter, r *http.Request) {
+	post, ok := s.postFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var req CreateCommentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if !validateCommentBody(w, req) {
+		return
+	}
+
+	claims, ok := GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	comment := Comment{
+		PostID: post.ID,
+		UserID: claims.ID,
+		Body:   req.Body,
+	}
+	if req.ParentID != nil {
+		parent, ok := s.postComment(w, r, post.ID, *req.ParentID)
+		if !ok {
+			return
+		}
+		if parent.DeletedAt != nil {
+			http.Error(w, "Cannot reply to a deleted comment", http.StatusConflict)
+			return
+		}
+		comment.ParentID = &parent.ID
+		comment.Depth = parent.Depth + 1
+	}
+
+	if err := s.Comments.Create(r.Context(), &comment); err != nil {
+		http.Error(w, "Failed to save comment", http.StatusInternalServerError)
+		return
+	}
+
+	CustomJsonResponse(w, http.StatusCreated, comment)
+}
+
+// CommentHandler edits and deletes the comment in the path.
+func (s *Server) CommentHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPut:
+		s.updateComment(w, r)
+	case http.MethodDelete:
+		s.deleteComment(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// updateComment lets authors change the body of their comment within
+// comment_edit_window of posting it.
+func (s *Server) updateComment(w http.ResponseWriter, r *http.Request) {
+	comment, ok := s.commentFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var req UpdateCommentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if !validateCommentBody(w, req) {
+		return
+	}
+
+	claims, ok := GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	// Moderators can delete other users' comments but not put words in
+	// their mouth.
+	if comment.UserID != claims.ID {
+		http.Error(w, "You can only edit your own comments", http.StatusForbidden)
+		return
+	}
+	if comment.DeletedAt != nil {
+		http.Error(w, "Deleted comments cannot be edited", http.StatusConflict)
+		return
+	}
+	if time.Since(comment.CreatedAt) > s.Config.CommentEditWindow {
+		http.Error(w, fmt.Sprintf("Comments can only be edited within %s of posting", s.Config.CommentEditWindow), http.StatusForbidden)
+		return
+	}
+
+	comment.Body = req.Body
+	if err := s.Comments.Update(r.Context(), &comment); err != nil {
+		// The comment was there a moment ago, so it has just been deleted.
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "Deleted comments cannot be edited", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to update comment", http.StatusInternalServerError)
+		return
+	}
+
+	CustomJsonResponse(w, http.StatusOK, comment)
+}
+
+// deleteComment blanks the comment but keeps it in the thread, so replies
+// stay where they were. Authors and moderators can delete.
+func (s *Server) deleteComment(w http.ResponseWriter, r *http.Request) {
+	comment, ok := s.commentFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	claims, ok := GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if comment.UserID != claims.ID && !claims.Can(PermPostsModerate) {
+		http.Error(w, "You can only delete your own comments", http.StatusForbidden)
+		return
+	}
+
+	if err := s.Comments.Delete(r.Context(), comment.ID); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "Comment not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to delete comment", http.StatusInternalServerError)
+		return
+	}
+
+	CustomJsonResponse(w, http.StatusOK, Success{Completed: true, Message: "Comment deleted successfully"})
+}
+
+// validateCommentBody validates a create or update request. On failure it
+// writes the error response and returns false.
+func validateCommentBody(w http.ResponseWriter, req any) bool {
+	err := validate.Struct(req)
+	if err == nil {
+		return true
+	}
+
+	errs := make(map[string]string)
+	for _, err := range err.(validator.ValidationErrors) {
+		field := err.Field()
+		switch err.Tag() {
+		case "required":
+			errs[field] = fmt.Sprintf("%s is required", field)
+		case "max":
+			errs[field] = fmt.Sprintf("%s must be at most %s characters long", field, err.Param())
+		}
+	}
+
+	CustomJsonResponse(w, http.StatusBadRequest, errs)
+	return false
+}
+
+// postFromPath loads the post named by the id path segment. On failure it
+// writes the error response and returns false.
+func (s *Server) postFromPath(w http.ResponseWriter, r *http.Request) (Post, bool) {
+	postID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || postID <= 0 {
+		http.Error(w, "Invalid post ID", http.StatusBadRequest)
+		return Post{}, false
+	}
+
+	post, err := s.Posts.Get(r.Context(), postID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "Post not found", http.StatusNotFound)
+			return Post{}, false
+		}
+		http.Error(w, "Failed to get post from DB", http.StatusInternalServerError)
+		return Post{}, false
+	}
+
+	return post, true
+}
+
+// commentFromPath loads the comment named by the commentId path segment,
+// which must belong to the post named by id. On failure it writes the error
+// response and returns false.
+func (s *Server) commentFromPath(w http.ResponseWriter, r *http.Request) (Comment, bool) {
+	postID, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil || postID <= 0 {
+		http.Error(w, "Invalid post ID", http.StatusBadRequest)
+		return Comment{}, false
+	}
+
+	commentID, err := strconv.Atoi(r.PathValue("commentId"))
+	if err != nil || commentID <= 0 {
+		http.Error(w, "Invalid comment ID", http.StatusBadRequest)
+		return Comment{}, false
+	}
+
+	return s.postComment(w, r, postID, commentID)
+}
+
+// postComment loads a comment and checks it is on postID, reporting
+// comments on other posts as missing. On failure it writes the error
+// response and returns false.
+func (s *Server) postComment(w http.ResponseWriter, r *http.Request, postID, commentID int) (Comment, bool) {
+	comment, err := s.Comments.Get(r.Context(), commentID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "Comment not found", http.StatusNotFound)
+			return Comment{}, false
+		}
+		http.Error(w, "Failed to get comment from DB", http.StatusInternalServerError)
+		return Comment{}, false
+	}
+
+	if comment.PostID != postID {
+		http.Error(w, "Comment not found", http.StatusNotFound)
+		return Comment{}, false
+	}
+
+	return comment, true
+}
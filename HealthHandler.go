@@ -0,0 +1,20 @@
+package main
+
+import (
+	"net/http"
+)
+
+// HealthHandler reports that the process is up.
+func HealthHandler(w http.ResponseWriter, r *http.Request) {
+	CustomJsonResponse(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// ReadyHandler reports whether the server should receive traffic. It turns
+// not-ready as soon as shutdown begins.
+func (s *Server) ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.ready.Load() {
+		CustomJsonResponse(w, http.StatusServiceUnavailable, map[string]string{"status": "not ready"})
+		return
+	}
+	CustomJsonResponse(w, http.StatusOK, map[string]string{"status": "ready"})
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"net/http"
+)
+
+// JWKSHandler publishes the public verification keys so other services can
+// check tokens issued here without holding a signing secret.
+func (s *Server) JWKSHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		CustomJsonResponse(w, http.StatusOK, s.Keys.JWKS())
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// LockoutHandler lets admins inspect and clear the failed-login counter of a
+// user.
+func (s *Server) LockoutHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.getLockout(w, r)
+	case http.MethodDelete:
+		s.unlockUser(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) getLockout(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.lockoutUser(w, r)
+	if !ok {
+		return
+	}
+
+	throttle := s.loginThrottles(user.Username, r)[0]
+	lockout := LoginLockout{UserID: user.ID}
+
+	attempts, err := s.LoginAttempts.Get(r.Context(), throttle.key)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		http.Error(w, "Failed to check login attempts", http.StatusInternalServerError)
+		return
+	}
+	if err == nil {
+		lockout.Failures = attempts.Failures
+		lockout.LastFailedAt = &attempts.LastFailedAt
+		if until := s.lockedUntil(throttle, attempts); !until.IsZero() {
+			lockout.LockedUntil = &until
+		}
+	}
+
+	CustomJsonResponse(w, http.StatusOK, lockout)
+}
+
+func (s *Server) unlockUser(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.lockoutUser(w, r)
+	if !ok {
+		return
+	}
+
+	throttle := s.loginThrottles(user.Username, r)[0]
+	if err := s.LoginAttempts.Reset(r.Context(), throttle.key); err != nil {
+		http.Error(w, "Failed to unlock user", http.StatusInternalServerError)
+		return
+	}
+
+	if claims, ok := GetUserFromContext(r.Context()); ok {
+		log.Printf("user %d unlocked login for user %d", claims.ID, user.ID)
+	}
+
+	CustomJsonResponse(w, http.StatusOK, Success{Completed: true, Message: "User unlocked successfully"})
+}
+
+// lockoutUser loads the user named by the id query parameter. On failure it
+// writes the error response and returns false.
+func (s *Server) lockoutUser(w http.ResponseWriter, r *http.Request) (User, bool) {
+	userID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil || userID <= 0 {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return User{}, false
+	}
+
+	user, err := s.Users.Get(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return User{}, false
+		}
+		http.Error(w, "Failed to retrieve user", http.StatusInternalServerError)
+		return User{}, false
+	}
+
+	return user, true
+}
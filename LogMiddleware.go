@@ -13,6 +13,7 @@ func LogMiddleware(next http.Handler) http.HandlerFunc {
 		next.ServeHTTP(w, r)
 
 		duration := time.Since(start)
-		log.Printf("Request %s %s took %v", r.Method, r.URL.String(), duration)
+		// Only the path: query strings carry verification and reset tokens.
+		log.Printf("Request %s %s took %v", r.Method, r.URL.Path, duration)
 	})
 }
@@ -3,22 +3,23 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
 
 	"github.com/go-playground/validator/v10"
-	"golang.org/x/crypto/bcrypt"
 )
 
-func LoginHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) LoginHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodPost:
-		login(w, r)
+		s.login(w, r)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
-func login(w http.ResponseWriter, r *http.Request) {
+func (s *Server) login(w http.ResponseWriter, r *http.Request) {
 	var userLogin UserLogin
 
 	if err := json.NewDecoder(r.Body).Decode(&userLogin); err != nil {
@@ -35,38 +36,118 @@ func login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	attempt, ok := s.startLoginAttempt(w, r, s.loginThrottles(userLogin.Username, r))
+	if !ok {
+		return
+	}
+
 	// Authenticate user
-	if err := authenticateUser(userLogin); err != nil {
-		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+	user, err := s.authenticateUser(r.Context(), userLogin)
+	if err != nil {
+		if errors.Is(err, ErrInvalidCredentials) {
+			s.recordLoginFailure(attempt)
+			http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+			return
+		}
+		s.releaseLoginAttempt(r.Context(), attempt)
+		http.Error(w, "Failed to authenticate", http.StatusInternalServerError)
 		return
 	}
+	s.releaseLoginAttempt(r.Context(), attempt)
 
-	// Generate JWT token
-	token, err := generateJWTWithClaims(User{
-		Username: userLogin.Username,
-	})
+	if s.ssoRequired(user.Email) {
+		http.Error(w, "This account must sign in with single sign-on", http.StatusForbidden)
+		return
+	}
+
+	if !user.IsActive {
+		http.Error(w, "Account is deactivated", http.StatusForbidden)
+		return
+	}
+
+	if user.EmailVerifiedAt == nil {
+		http.Error(w, "Email address has not been verified", http.StatusForbidden)
+		return
+	}
+
+	// Users with two-factor authentication get a challenge to exchange at
+	// /login/mfa instead of tokens.
+	totp, err := s.MFA.GetTOTP(r.Context(), user.ID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		http.Error(w, "Failed to look up two-factor settings", http.StatusInternalServerError)
+		return
+	}
+	if err == nil && totp.EnabledAt != nil {
+		challenge, err := s.issueMFAChallenge(user)
+		if err != nil {
+			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+			return
+		}
+		CustomJsonResponse(w, http.StatusOK, challenge)
+		return
+	}
+
+	if userLogin.Session {
+		session, err := s.startSession(r.Context(), w, user, false)
+		if err != nil {
+			http.Error(w, "Failed to create session", http.StatusInternalServerError)
+			return
+		}
+
+		s.resetLoginFailures(r.Context(), attempt)
+
+		CustomJsonResponse(w, http.StatusOK, session)
+		return
+	}
+
+	// Generate JWT and refresh token
+	tokens, err := s.issueTokens(r.Context(), user, "", false)
 	if err != nil {
 		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 		return
 	}
 
-	CustomJsonResponse(w, http.StatusOK, map[string]string{"token": token})
+	s.resetLoginFailures(r.Context(), attempt)
+
+	CustomJsonResponse(w, http.StatusOK, tokens)
 
 }
 
-func authenticateUser(userLogin UserLogin) error {
+// ErrInvalidCredentials covers both an unknown username and a wrong
+// password, so callers cannot tell them apart.
+var ErrInvalidCredentials = errors.New("invalid credentials")
 
-	query := `SELECT id, username, password FROM users WHERE username = $1`
-	var user User
+// authenticateUser checks the password and, when the stored hash uses an
+// older algorithm or weaker parameters, replaces it with a fresh one.
+func (s *Server) authenticateUser(ctx context.Context, userLogin UserLogin) (User, error) {
+	user, err := s.Users.GetByUsername(ctx, userLogin.Username)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			// Take as long as a wrong password so unknown usernames do
+			// not stand out.
+			s.Passwords.VerifyDummy(userLogin.Password)
+			return User{}, ErrInvalidCredentials
+		}
+		return User{}, err
+	}
 
-	if err := DB.QueryRow(context.Background(), query, userLogin.Username).Scan(&user.ID, &user.Username, &user.Password); err != nil {
-		return err
+	ok, rehash, err := s.Passwords.Verify(userLogin.Password, user.Password)
+	if err != nil {
+		return User{}, err
+	}
+	if !ok {
+		return User{}, ErrInvalidCredentials
 	}
 
-	// Compare passwords
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(userLogin.Password)); err != nil {
-		return err
+	if rehash {
+		if hash, err := s.Passwords.Hash(userLogin.Password); err != nil {
+			log.Println(err)
+		} else if err := s.Users.UpdatePassword(ctx, user.ID, hash); err != nil {
+			log.Println(err)
+		} else {
+			user.Password = hash
+		}
 	}
 
-	return nil
+	return user, nil
 }
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+func (s *Server) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		s.logout(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// logout ends the cookie session or revokes the access token used for the
+// request and, if the body carries one, the refresh token family it belongs
+// to.
+func (s *Server) logout(w http.ResponseWriter, r *http.Request) {
+	claims, ok := GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req LogoutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if claims.SessionID != 0 {
+		if err := s.Sessions.Delete(r.Context(), claims.SessionID); err != nil {
+			log.Println(err)
+			http.Error(w, "Failed to end session", http.StatusInternalServerError)
+			return
+		}
+		s.clearSessionCookies(w)
+	} else if err := s.Denylist.Revoke(r.Context(), claims.RegisteredClaims.ID, claims.ExpiresAt.Time); err != nil {
+		log.Println(err)
+		http.Error(w, "Failed to revoke token", http.StatusInternalServerError)
+		return
+	}
+
+	if req.RefreshToken != "" {
+		stored, err := s.RefreshTokens.GetByHash(r.Context(), hashToken(req.RefreshToken))
+		if err == nil && stored.UserID == claims.ID {
+			err = s.RefreshTokens.RevokeFamily(r.Context(), stored.FamilyID)
+		}
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			log.Println(err)
+			http.Error(w, "Failed to revoke refresh token", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	CustomJsonResponse(w, http.StatusOK, Success{Completed: true, Message: "Logged out successfully"})
+}
+
+func (s *Server) RevokeSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		s.revokeSessions(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// revokeSessions invalidates every access token, refresh token and cookie
+// session of the user given by ?id=, forcing them to log in again.
+func (s *Server) revokeSessions(w http.ResponseWriter, r *http.Request) {
+	userIDStr := r.URL.Query().Get("id")
+	if userIDStr == "" {
+		http.Error(w, "User ID is required", http.StatusBadRequest)
+		return
+	}
+
+	userID, err := strconv.Atoi(userIDStr)
+	if err != nil || userID <= 0 {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := s.Users.Get(r.Context(), userID); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to retrieve user", http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.revokeUserCredentials(r.Context(), userID, 0); err != nil {
+		log.Println(err)
+		http.Error(w, "Failed to revoke sessions", http.StatusInternalServerError)
+		return
+	}
+
+	CustomJsonResponse(w, http.StatusOK, Success{Completed: true, Message: "Sessions revoked successfully"})
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const mfaChallengeAudience = "mfa-challenge"
+
+func (s *Server) LoginMFAHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		s.loginMFA(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// loginMFA exchanges the challenge from /login and a TOTP or recovery code
+// for tokens.
+func (s *Server) loginMFA(w http.ResponseWriter, r *http.Request) {
+	var req MFALogin
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
+		CustomJsonResponse(w, http.StatusBadRequest, mfaValidationErrors(err))
+		return
+	}
+
+	token, err := jwt.ParseWithClaims(req.MFAToken, &MFAChallengeClaims{}, s.Keys.Keyfunc,
+		jwt.WithValidMethods(s.Keys.Methods()), jwt.WithAudience(mfaChallengeAudience))
+	if err != nil || !token.Valid {
+		http.Error(w, "Invalid or expired MFA token", http.StatusUnauthorized)
+		return
+	}
+
+	userID, err := strconv.Atoi(token.Claims.(*MFAChallengeClaims).Subject)
+	if err != nil {
+		http.Error(w, "Invalid or expired MFA token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := s.Users.Get(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "Invalid or expired MFA token", http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, "Failed to retrieve user", http.StatusInternalServerError)
+		return
+	}
+
+	if !user.IsActive {
+		http.Error(w, "Account is deactivated", http.StatusForbidden)
+		return
+	}
+
+	totp, err := s.MFA.GetTOTP(r.Context(), user.ID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		http.Error(w, "Failed to look up two-factor settings", http.StatusInternalServerError)
+		return
+	}
+	if err != nil || totp.EnabledAt == nil {
+		http.Error(w, "Invalid or expired MFA token", http.StatusUnauthorized)
+		return
+	}
+
+	// Wrong codes count against the same limits as wrong passwords.
+	attempt, ok := s.startLoginAttempt(w, r, s.loginThrottles(user.Username, r))
+	if !ok {
+		return
+	}
+
+	ok, err = s.verifySecondFactor(r.Context(), totp, req.Code, req.RecoveryCode)
+	if err != nil {
+		s.releaseLoginAttempt(r.Context(), attempt)
+		http.Error(w, "Failed to verify code", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		s.recordLoginFailure(attempt)
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+	s.releaseLoginAttempt(r.Context(), attempt)
+
+	if req.Session {
+		session, err := s.startSession(r.Context(), w, user, true)
+		if err != nil {
+			http.Error(w, "Failed to create session", http.StatusInternalServerError)
+			return
+		}
+
+		s.resetLoginFailures(r.Context(), attempt)
+
+		CustomJsonResponse(w, http.StatusOK, session)
+		return
+	}
+
+	tokens, err := s.issueTokens(r.Context(), user, "", true)
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	s.resetLoginFailures(r.Context(), attempt)
+
+	CustomJsonResponse(w, http.StatusOK, tokens)
+}
+
+// TOTPHandler starts TOTP enrollment for the caller or turns it off.
+func (s *Server) TOTPHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		s.enrollTOTP(w, r)
+	case http.MethodDelete:
+		s.disableTOTP(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// enrollTOTP generates a secret that stays pending until it is confirmed,
+// so a half-finished enrollment never locks the user out.
+func (s *Server) enrollTOTP(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		http.Error(w, "Failed to generate secret", http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.MFA.SetPendingTOTP(r.Context(), user.ID, secret); err != nil {
+		if errors.Is(err, ErrConflict) {
+			http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to save secret", http.StatusInternalServerError)
+		return
+	}
+
+	CustomJsonResponse(w, http.StatusCreated, TOTPEnrollment{
+		Secret: secret,
+		URI:    otpauthURI(s.Config.MFAIssuer, user.Username, secret),
+	})
+}
+
+func (s *Server) disableTOTP(w http.ResponseWriter, r *http.Request) {
+	var req DisableTOTPRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
+		CustomJsonResponse(w, http.StatusBadRequest, mfaValidationErrors(err))
+		return
+	}
+
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	// Wrong passwords and codes count against the login throttle, as they
+	// would at /login and /login/mfa.
+	attempt, ok := s.startLoginAttempt(w, r, s.loginThrottles(user.Username, r))
+	if !ok {
+		return
+	}
+	if _, err := s.authenticateUser(r.Context(), UserLogin{Username: user.Username, Password: req.Password}); err != nil {
+		if errors.Is(err, ErrInvalidCredentials) {
+			s.recordLoginFailure(attempt)
+			http.Error(w, "Password is incorrect", http.StatusUnauthorized)
+			return
+		}
+		s.releaseLoginAttempt(r.Context(), attempt)
+		http.Error(w, "Failed to verify password", http.StatusInternalServerError)
+		return
+	}
+
+	totp, ok := s.enabledTOTP(w, r, user.ID)
+	if !ok {
+		s.releaseLoginAttempt(r.Context(), attempt)
+		return
+	}
+
+	valid, err := s.verifySecondFactor(r.Context(), totp, req.Code, req.RecoveryCode)
+	if err != nil {
+		s.releaseLoginAttempt(r.Context(), attempt)
+		http.Error(w, "Failed to verify code", http.StatusInternalServerError)
+		return
+	}
+	if !valid {
+		s.recordLoginFailure(attempt)
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+	s.releaseLoginAttempt(r.Context(), attempt)
+
+	if err := s.MFA.DisableTOTP(r.Context(), user.ID); err != nil {
+		http.Error(w, "Failed to disable two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	CustomJsonResponse(w, http.StatusOK, Success{Completed: true, Message: "Two-factor authentication disabled"})
+}
+
+func (s *Server) ConfirmTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		s.confirmTOTP(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// confirmTOTP enables the pending secret once the user proves their app
+// produces valid codes, and returns the recovery codes. They are shown only
+// this once.
+func (s *Server) confirmTOTP(w http.ResponseWriter, r *http.Request) {
+	var req TOTPCode
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
+		CustomJsonResponse(w, http.StatusBadRequest, mfaValidationErrors(err))
+		return
+	}
+
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	totp, err := s.MFA.GetTOTP(r.Context(), user.ID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "No two-factor enrollment in progress", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to look up two-factor settings", http.StatusInternalServerError)
+		return
+	}
+	if totp.EnabledAt != nil {
+		http.Error(w, "Two-factor authentication is already enabled", http.StatusConflict)
+		return
+	}
+
+	valid, err := s.verifySecondFactor(r.Context(), totp, req.Code, "")
+	if err != nil {
+		http.Error(w, "Failed to verify code", http.StatusInternalServerError)
+		return
+	}
+	if !valid {
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		http.Error(w, "Failed to generate recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.MFA.EnableTOTP(r.Context(), user.ID, hashes); err != nil {
+		http.Error(w, "Failed to enable two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	CustomJsonResponse(w, http.StatusOK, RecoveryCodes{RecoveryCodes: codes})
+}
+
+func (s *Server) RecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		s.regenerateRecoveryCodes(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// regenerateRecoveryCodes replaces every recovery code, used or not.
+func (s *Server) regenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	var req TOTPCode
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
+		CustomJsonResponse(w, http.StatusBadRequest, mfaValidationErrors(err))
+		return
+	}
+
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	totp, ok := s.enabledTOTP(w, r, user.ID)
+	if !ok {
+		return
+	}
+
+	valid, err := s.verifySecondFactor(r.Context(), totp, req.Code, "")
+	if err != nil {
+		http.Error(w, "Failed to verify code", http.StatusInternalServerError)
+		return
+	}
+	if !valid {
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		http.Error(w, "Failed to generate recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.MFA.ReplaceRecoveryCodes(r.Context(), user.ID, hashes); err != nil {
+		http.Error(w, "Failed to save recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	CustomJsonResponse(w, http.StatusOK, RecoveryCodes{RecoveryCodes: codes})
+}
+
+// ResetUserMFAHandler lets an administrator turn off two-factor
+// authentication for a user who has lost both their device and their
+// recovery codes.
+func (s *Server) ResetUserMFAHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodDelete:
+		s.resetUserMFA(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) resetUserMFA(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil || userID <= 0 {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := s.Users.Get(r.Context(), userID); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to retrieve user", http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.MFA.DisableTOTP(r.Context(), userID); err != nil {
+		http.Error(w, "Failed to disable two-factor authentication", http.StatusInternalServerError)
+		return
+	}
+
+	if claims, ok := GetUserFromContext(r.Context()); ok {
+		log.Printf("user %d reset two-factor authentication for user %d", claims.ID, userID)
+	}
+
+	CustomJsonResponse(w, http.StatusOK, Success{Completed: true, Message: "Two-factor authentication disabled"})
+}
+
+// issueMFAChallenge signs the short-lived token that proves the password
+// step of a login.
+func (s *Server) issueMFAChallenge(user User) (MFAChallenge, error) {
+	now := time.Now()
+
+	token, err := s.Keys.Sign(MFAChallengeClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.Itoa(user.ID),
+			Audience:  jwt.ClaimStrings{mfaChallengeAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.Config.MFAChallengeTTL)),
+		},
+	})
+	if err != nil {
+		return MFAChallenge{}, err
+	}
+
+	return MFAChallenge{
+		MFARequired: true,
+		MFAToken:    token,
+		ExpiresIn:   int(s.Config.MFAChallengeTTL.Seconds()),
+	}, nil
+}
+
+// enabledTOTP loads the caller's confirmed TOTP secret. On failure it writes
+// the error response and returns false.
+func (s *Server) enabledTOTP(w http.ResponseWriter, r *http.Request, userID int) (TOTPSecret, bool) {
+	totp, err := s.MFA.GetTOTP(r.Context(), userID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		http.Error(w, "Failed to look up two-factor settings", http.StatusInternalServerError)
+		return TOTPSecret{}, false
+	}
+	if err != nil || totp.EnabledAt == nil {
+		http.Error(w, "Two-factor authentication is not enabled", http.StatusConflict)
+		return TOTPSecret{}, false
+	}
+	return totp, true
+}
+
+// verifySecondFactor checks a TOTP code, or a recovery code when no TOTP
+// code is given. Both are single use.
+func (s *Server) verifySecondFactor(ctx context.Context, totp TOTPSecret, code, recoveryCode string) (bool, error) {
+	if code == "" {
+		if recoveryCode == "" {
+			return false, nil
+		}
+		return s.MFA.UseRecoveryCode(ctx, totp.UserID, hashRecoveryCode(recoveryCode))
+	}
+
+	step, ok := verifyTOTP(totp.Secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	return s.MFA.UseTOTPStep(ctx, totp.UserID, step)
+}
+
+func mfaValidationErrors(err error) map[string]string {
+	errs := make(map[string]string)
+
+	for _, err := range err.(validator.ValidationErrors) {
+		field := err.Field()
+		switch err.Tag() {
+		case "required":
+			errs[field] = fmt.Sprintf("%s is required", field)
+		case "required_without":
+			errs[field] = fmt.Sprintf("%s or %s is required", field, err.Param())
+		case "len", "numeric":
+			errs[field] = fmt.Sprintf("%s must be a %d digit code", field, totpDigits)
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// MeHandler serves the profile of the authenticated caller.
+func (s *Server) MeHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.getMe(w, r)
+	case http.MethodPatch:
+		s.updateMe(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) getMe(w http.ResponseWriter, r *http.Request) {
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	CustomJsonResponse(w, http.StatusOK, user)
+}
+
+func (s *Server) updateMe(w http.ResponseWriter, r *http.Request) {
+	var update ProfileUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := validate.Struct(update); err != nil {
+		errs := make(map[string]string)
+
+		for _, err := range err.(validator.ValidationErrors) {
+			field := err.Field()
+			switch err.Tag() {
+			case "email":
+				errs[field] = fmt.Sprintf("%s is not a valid email", field)
+			case "min":
+				errs[field] = fmt.Sprintf("%s must be at least %s characters long", field, err.Param())
+			}
+		}
+
+		CustomJsonResponse(w, http.StatusBadRequest, errs)
+		return
+	}
+
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	if update.Username != nil {
+		user.Username = *update.Username
+	}
+	emailChanged := update.Email != nil && *update.Email != user.Email
+	if update.Email != nil {
+		user.Email = *update.Email
+	}
+
+	if err := s.checkUsernameOrEmail(r.Context(), user); err != nil {
+		CustomJsonResponse(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		return
+	}
+
+	if err := s.Users.Update(r.Context(), &user); err != nil {
+		if errors.Is(err, ErrConflict) {
+			CustomJsonResponse(w, http.StatusConflict, map[string]string{"error": "username or email already exists"})
+			return
+		}
+		CustomJsonResponse(w, http.StatusInternalServerError, map[string]string{"error": "Failed to update user"})
+		return
+	}
+
+	// A new address has to be verified again.
+	if emailChanged {
+		if err := s.Users.SetEmailVerified(r.Context(), user.ID, nil); err != nil {
+			CustomJsonResponse(w, http.StatusInternalServerError, map[string]string{"error": "Failed to update user"})
+			return
+		}
+		user.EmailVerifiedAt = nil
+		s.sendVerificationEmail(r.Context(), user)
+	}
+
+	CustomJsonResponse(w, http.StatusOK, user)
+}
+
+// currentUser loads the user behind the request's token. On failure it
+// writes the error response and returns false.
+func (s *Server) currentUser(w http.ResponseWriter, r *http.Request) (User, bool) {
+	claims, ok := GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return User{}, false
+	}
+
+	user, err := s.Users.Get(r.Context(), claims.ID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return User{}, false
+		}
+		http.Error(w, "Failed to retrieve user", http.StatusInternalServerError)
+		return User{}, false
+	}
+
+	return user, true
+}
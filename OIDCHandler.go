@@ -0,0 +1,380 @@
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	oidcFlowAudience   = "oidc-flow"
+	oidcFlowCookieName = "oidc_flow"
+	// oidcFlowTTL is how long the user has to finish logging in at the
+	// provider.
+	oidcFlowTTL = 10 * time.Minute
+)
+
+// OIDCLoginHandler starts a login at the OIDC provider.
+func (s *Server) OIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.startOIDCLogin(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// startOIDCLogin redirects to the provider. The state, nonce and PKCE
+// verifier travel in a signed cookie, so only the browser that started the
+// login can finish it.
+func (s *Server) startOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if s.OIDC == nil {
+		http.Error(w, "Single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+
+	session := false
+	if value := r.URL.Query().Get("session"); value != "" {
+		var err error
+		if session, err = strconv.ParseBool(value); err != nil {
+			http.Error(w, "Invalid session parameter", http.StatusBadRequest)
+			return
+		}
+	}
+
+	state, err := randomID()
+	if err != nil {
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+	nonce, err := randomID()
+	if err != nil {
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+	verifier, err := newPKCEVerifier()
+	if err != nil {
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+
+	authURL, err := s.OIDC.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Failed to reach the identity provider", http.StatusBadGateway)
+		return
+	}
+
+	now := time.Now()
+	flow, err := s.Keys.Sign(OIDCFlowClaims{
+		State:    state,
+		Nonce:    nonce,
+		Verifier: verifier,
+		Session:  session,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{oidcFlowAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(oidcFlowTTL)),
+		},
+	})
+	if err != nil {
+		http.Error(w, "Failed to start login", http.StatusInternalServerError)
+		return
+	}
+
+	http.SetCookie(w, s.oidcFlowCookie(flow, now.Add(oidcFlowTTL)))
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallbackHandler is where the provider sends the browser back.
+func (s *Server) OIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.finishOIDCLogin(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// finishOIDCLogin exchanges the code, finds or creates the user and issues
+// tokens or a session cookie just like a password login.
+func (s *Server) finishOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if s.OIDC == nil {
+		http.Error(w, "Single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+
+	cookie, err := r.Cookie(oidcFlowCookieName)
+	// The flow cookie is only good for one attempt.
+	expired := s.oidcFlowCookie("", time.Unix(0, 0))
+	expired.MaxAge = -1
+	http.SetCookie(w, expired)
+	if err != nil {
+		http.Error(w, "Login expired, please start again", http.StatusBadRequest)
+		return
+	}
+
+	token, err := jwt.ParseWithClaims(cookie.Value, &OIDCFlowClaims{}, s.Keys.Keyfunc,
+		jwt.WithValidMethods(s.Keys.Methods()), jwt.WithAudience(oidcFlowAudience))
+	if err != nil || !token.Valid {
+		http.Error(w, "Login expired, please start again", http.StatusBadRequest)
+		return
+	}
+	flow := token.Claims.(*OIDCFlowClaims)
+
+	query := r.URL.Query()
+	if subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(flow.State)) != 1 {
+		http.Error(w, "Invalid state", http.StatusBadRequest)
+		return
+	}
+	if errCode := query.Get("error"); errCode != "" {
+		http.Error(w, "The identity provider refused the login: "+errCode, http.StatusUnauthorized)
+		return
+	}
+	if query.Get("code") == "" {
+		http.Error(w, "Missing authorization code", http.StatusBadRequest)
+		return
+	}
+
+	claims, err := s.OIDC.Exchange(r.Context(), query.Get("code"), flow.Verifier, flow.Nonce)
+	if err != nil {
+		log.Println(err)
+		http.Error(w, "Failed to verify the identity provider's response", http.StatusUnauthorized)
+		return
+	}
+
+	user, ok := s.oidcUser(w, r, claims)
+	if !ok {
+		return
+	}
+
+	if !user.IsActive {
+		http.Error(w, "Account is deactivated", http.StatusForbidden)
+		return
+	}
+
+	// The provider's own second factor stands in for ours. Without it,
+	// users who enrolled TOTP get the same challenge as at /login, or any
+	// provider account with their email would skip it.
+	mfa := claims.usedMFA()
+	if !mfa {
+		totp, err := s.MFA.GetTOTP(r.Context(), user.ID)
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			http.Error(w, "Failed to look up two-factor settings", http.StatusInternalServerError)
+			return
+		}
+		if err == nil && totp.EnabledAt != nil {
+			challenge, err := s.issueMFAChallenge(user)
+			if err != nil {
+				http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+				return
+			}
+			CustomJsonResponse(w, http.StatusOK, challenge)
+			return
+		}
+	}
+
+	if flow.Session {
+		session, err := s.startSession(r.Context(), w, user, mfa)
+		if err != nil {
+			http.Error(w, "Failed to create session", http.StatusInternalServerError)
+			return
+		}
+		CustomJsonResponse(w, http.StatusOK, session)
+		return
+	}
+
+	tokens, err := s.issueTokens(r.Context(), user, "", mfa)
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	CustomJsonResponse(w, http.StatusOK, tokens)
+}
+
+// oidcUser returns the user linked to the provider account. On the first
+// login it links the account to the user with the same verified email, or
+// creates a member for it. On failure it writes the error response and
+// returns false.
+func (s *Server) oidcUser(w http.ResponseWriter, r *http.Request, claims *OIDCIDTokenClaims) (User, bool) {
+	identity, err := s.Identities.Get(r.Context(), s.OIDC.Issuer, claims.Subject)
+	if err == nil {
+		user, err := s.Users.Get(r.Context(), identity.UserID)
+		if err != nil {
+			http.Error(w, "Failed to retrieve user", http.StatusInternalServerError)
+			return User{}, false
+		}
+		return user, true
+	}
+	if !errors.Is(err, ErrNotFound) {
+		http.Error(w, "Failed to look up linked account", http.StatusInternalServerError)
+		return User{}, false
+	}
+
+	if claims.Email == "" || !claims.EmailVerified {
+		http.Error(w, "The identity provider did not confirm your email address", http.StatusForbidden)
+		return User{}, false
+	}
+
+	user, err := s.Users.GetByEmail(r.Context(), claims.Email)
+	switch {
+	case err == nil:
+		// Only link to owners who proved the address. Otherwise someone
+		// could sign up with a colleague's email, wait for them to log in
+		// with SSO and share the account.
+		if user.EmailVerifiedAt == nil {
+			http.Error(w, "An unverified account already uses this email address", http.StatusConflict)
+			return User{}, false
+		}
+	case errors.Is(err, ErrNotFound):
+		var ok bool
+		if user, ok = s.provisionOIDCUser(w, r, claims); !ok {
+			return User{}, false
+		}
+	default:
+		http.Error(w, "Failed to retrieve user", http.StatusInternalServerError)
+		return User{}, false
+	}
+
+	return s.linkOIDCIdentity(w, r, user, claims)
+}
+
+func (s *Server) linkOIDCIdentity(w http.ResponseWriter, r *http.Request, user User, claims *OIDCIDTokenClaims) (User, bool) {
+	identity := Identity{
+		UserID:  user.ID,
+		Issuer:  s.OIDC.Issuer,
+		Subject: claims.Subject,
+		Email:   claims.Email,
+	}
+	if err := s.Identities.Create(r.Context(), &identity); err != nil {
+		if errors.Is(err, ErrConflict) {
+			http.Error(w, "This account is already linked, please try again", http.StatusConflict)
+			return User{}, false
+		}
+		http.Error(w, "Failed to link account", http.StatusInternalServerError)
+		return User{}, false
+	}
+
+	log.Printf("linked %s subject %s to user %d", identity.Issuer, identity.Subject, user.ID)
+	return user, true
+}
+
+// provisionOIDCUser creates a member with a random password nobody knows,
+// so the account can only be used through the provider until a password is
+// reset.
+func (s *Server) provisionOIDCUser(w http.ResponseWriter, r *http.Request, claims *OIDCIDTokenClaims) (User, bool) {
+	password, _, err := generateOpaqueToken()
+	if err != nil {
+		http.Error(w, "Failed to create user", http.StatusInternalServerError)
+		return User{}, false
+	}
+	hash, err := s.Passwords.Hash(password)
+	if err != nil {
+		http.Error(w, "Failed to create user", http.StatusInternalServerError)
+		return User{}, false
+	}
+
+	username, err := s.availableUsername(r, claims)
+	if err != nil {
+		http.Error(w, "Failed to create user", http.StatusInternalServerError)
+		return User{}, false
+	}
+
+	now := time.Now()
+	user := User{
+		Username:        username,
+		Email:           claims.Email,
+		Password:        hash,
+		IsActive:        true,
+		Role:            RoleMember,
+		EmailVerifiedAt: &now,
+	}
+	if err := s.Users.Create(r.Context(), &user); err != nil {
+		if errors.Is(err, ErrConflict) {
+			http.Error(w, "Username or email already exists, please try again", http.StatusConflict)
+			return User{}, false
+		}
+		http.Error(w, "Failed to create user", http.StatusInternalServerError)
+		return User{}, false
+	}
+
+	log.Printf("provisioned user %d for %s subject %s", user.ID, s.OIDC.Issuer, claims.Subject)
+	return user, true
+}
+
+var usernameUnsafe = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// maxUsernameLength matches the users.username column.
+const maxUsernameLength = 50
+
+// availableUsername derives a username from the provider's preferred
+// username or the email's local part, adding a number if it is taken.
+func (s *Server) availableUsername(r *http.Request, claims *OIDCIDTokenClaims) (string, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+	base = usernameUnsafe.ReplaceAllString(base, "")
+	if len(base) < 3 {
+		base = "user" + base
+	}
+	if len(base) > maxUsernameLength-4 {
+		base = base[:maxUsernameLength-4]
+	}
+
+	for i := 1; i <= 1000; i++ {
+		candidate := base
+		if i > 1 {
+			candidate = fmt.Sprintf("%s%d", base, i)
+		}
+
+		_, err := s.Users.GetByUsername(r.Context(), candidate)
+		if errors.Is(err, ErrNotFound) {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("no free username for %q", base)
+}
+
+// ssoRequired reports whether email belongs to a domain that must log in
+// through the OIDC provider.
+func (s *Server) ssoRequired(email string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := email[at+1:]
+
+	for _, enforced := range s.Config.OIDCEnforcedDomains {
+		if strings.EqualFold(domain, enforced) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) oidcFlowCookie(value string, expires time.Time) *http.Cookie {
+	// Lax, whatever the session cookie uses, because the provider sends the
+	// browser back with a cross-site redirect.
+	return &http.Cookie{
+		Name:     oidcFlowCookieName,
+		Value:    value,
+		Path:     "/login/oidc",
+		Expires:  expires,
+		Secure:   s.Config.SessionCookieSecure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
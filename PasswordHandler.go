@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+func (s *Server) ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		s.changePassword(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// changePassword lets the caller set a new password after proving they know
+// the current one. Access and refresh tokens issued before the change are
+// revoked, including the caller's, and other cookie sessions end.
+func (s *Server) changePassword(w http.ResponseWriter, r *http.Request) {
+	var change PasswordChange
+	if err := json.NewDecoder(r.Body).Decode(&change); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := validate.Struct(change); err != nil {
+		errs := make(map[string]string)
+
+		for _, err := range err.(validator.ValidationErrors) {
+			field := err.Field()
+			switch err.Tag() {
+			case "required":
+				errs[field] = fmt.Sprintf("%s is required", field)
+			case "min":
+				errs[field] = fmt.Sprintf("%s must be at least %s characters long", field, err.Param())
+			}
+		}
+
+		CustomJsonResponse(w, http.StatusBadRequest, errs)
+		return
+	}
+
+	user, ok := s.currentUser(w, r)
+	if !ok {
+		return
+	}
+
+	// Guesses here count against the same throttle as logins, so a stolen
+	// token cannot be used to try passwords without limit.
+	attempt, ok := s.startLoginAttempt(w, r, s.loginThrottles(user.Username, r))
+	if !ok {
+		return
+	}
+	if _, err := s.authenticateUser(r.Context(), UserLogin{Username: user.Username, Password: change.CurrentPassword}); err != nil {
+		if errors.Is(err, ErrInvalidCredentials) {
+			s.recordLoginFailure(attempt)
+			http.Error(w, "Current password is incorrect", http.StatusUnauthorized)
+			return
+		}
+		s.releaseLoginAttempt(r.Context(), attempt)
+		http.Error(w, "Failed to verify password", http.StatusInternalServerError)
+		return
+	}
+	s.releaseLoginAttempt(r.Context(), attempt)
+
+	if !s.checkPasswordPolicy(w, "NewPassword", change.NewPassword, user.Username, user.Email) {
+		return
+	}
+
+	hashedPassword, err := s.Passwords.Hash(change.NewPassword)
+	if err != nil {
+		CustomJsonResponse(w, http.StatusInternalServerError, map[string]string{"error": "Failed to hash password"})
+		return
+	}
+
+	if err := s.Users.UpdatePassword(r.Context(), user.ID, hashedPassword); err != nil {
+		CustomJsonResponse(w, http.StatusInternalServerError, map[string]string{"error": "Failed to update password"})
+		return
+	}
+
+	claims, _ := GetUserFromContext(r.Context())
+	if err := s.revokeUserCredentials(r.Context(), user.ID, claims.SessionID); err != nil {
+		log.Println(err)
+	}
+
+	CustomJsonResponse(w, http.StatusOK, Success{Completed: true, Message: "Password changed successfully"})
+}
+
+func (s *Server) ForgotPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		s.forgotPassword(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// forgotPassword emails a single-use reset token. The response is the same
+// whether or not the email belongs to an account.
+func (s *Server) forgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
+		CustomJsonResponse(w, http.StatusBadRequest, map[string]string{"Email": "Email is not a valid email"})
+		return
+	}
+
+	response := Success{Completed: true, Message: "If an account exists for that email, a reset link has been sent"}
+
+	user, err := s.Users.GetByEmail(r.Context(), req.Email)
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			log.Println(err)
+		}
+		CustomJsonResponse(w, http.StatusAccepted, response)
+		return
+	}
+
+	if err := s.ResetTokens.InvalidateUser(r.Context(), user.ID); err != nil {
+		log.Println(err)
+		http.Error(w, "Failed to create reset token", http.StatusInternalServerError)
+		return
+	}
+
+	token, tokenHash, err := generateOpaqueToken()
+	if err != nil {
+		http.Error(w, "Failed to create reset token", http.StatusInternalServerError)
+		return
+	}
+
+	resetToken := PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: tokenHash,
+		ExpiresAt: time.Now().Add(s.Config.PasswordResetTTL),
+	}
+	if err := s.ResetTokens.Create(r.Context(), &resetToken); err != nil {
+		log.Println(err)
+		http.Error(w, "Failed to create reset token", http.StatusInternalServerError)
+		return
+	}
+
+	msg := Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body:    s.passwordResetBody(user, token),
+	}
+
+	// Send in the background so response time does not reveal whether the
+	// account exists.
+	go func() {
+		if err := s.Mailer.Send(context.WithoutCancel(r.Context()), msg); err != nil {
+			log.Printf("failed to send password reset email to user %d: %v", user.ID, err)
+		}
+	}()
+
+	CustomJsonResponse(w, http.StatusAccepted, response)
+}
+
+// passwordResetBody links to the password_reset_url page, which should send
+// the token to POST /password/reset. Without one there is no page a browser
+// could open, so the email carries the token itself.
+func (s *Server) passwordResetBody(user User, token string) string {
+	if s.Config.PasswordResetURL == "" {
+		return fmt.Sprintf("Hi %s,\n\nUse the code below to reset your password. It expires in %s and can only be used once.\n\n%s\n\nIf you did not ask for this, you can ignore this email.\n",
+			user.Username, s.Config.PasswordResetTTL, token)
+	}
+
+	// The URL was checked when the config was loaded.
+	link, _ := url.Parse(s.Config.PasswordResetURL)
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	return fmt.Sprintf("Hi %s,\n\nUse the link below to reset your password. It expires in %s and can only be used once.\n\n%s\n\nIf you did not ask for this, you can ignore this email.\n",
+		user.Username, s.Config.PasswordResetTTL, link)
+}
+
+func (s *Server) ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		s.resetPassword(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// resetPassword sets a new password using a token from forgotPassword and
+// signs the user out everywhere.
+func (s *Server) resetPassword(w http.ResponseWriter, r *http.Request) {
+	var req ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
+		errs := make(map[string]string)
+
+		for _, err := range err.(validator.ValidationErrors) {
+			field := err.Field()
+			switch err.Tag() {
+			case "required":
+				errs[field] = fmt.Sprintf("%s is required", field)
+			case "min":
+				errs[field] = fmt.Sprintf("%s must be at least %s characters long", field, err.Param())
+			}
+		}
+
+		CustomJsonResponse(w, http.StatusBadRequest, errs)
+		return
+	}
+
+	// Check the token without using it up, so a password the policy rejects
+	// can be retried with the same link.
+	pending, err := s.ResetTokens.Lookup(r.Context(), hashToken(req.Token))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "Invalid or expired reset token", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Failed to verify reset token", http.StatusInternalServerError)
+		return
+	}
+
+	user, err := s.Users.Get(r.Context(), pending.UserID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "Invalid or expired reset token", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Failed to retrieve user", http.StatusInternalServerError)
+		return
+	}
+
+	if !s.checkPasswordPolicy(w, "NewPassword", req.NewPassword, user.Username, user.Email) {
+		return
+	}
+
+	hashedPassword, err := s.Passwords.Hash(req.NewPassword)
+	if err != nil {
+		CustomJsonResponse(w, http.StatusInternalServerError, map[string]string{"error": "Failed to hash password"})
+		return
+	}
+
+	resetToken, err := s.ResetTokens.Consume(r.Context(), hashToken(req.Token))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "Invalid or expired reset token", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Failed to verify reset token", http.StatusInternalServerError)
+		return
+	}
+
+	if err := s.Users.UpdatePassword(r.Context(), resetToken.UserID, hashedPassword); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "Invalid or expired reset token", http.StatusBadRequest)
+			return
+		}
+		CustomJsonResponse(w, http.StatusInternalServerError, map[string]string{"error": "Failed to update password"})
+		return
+	}
+
+	if err := s.revokeUserCredentials(r.Context(), resetToken.UserID, 0); err != nil {
+		log.Println(err)
+	}
+
+	CustomJsonResponse(w, http.StatusOK, Success{Completed: true, Message: "Password reset successfully"})
+}
+
+// revokeUserCredentials revokes every access and refresh token of the user
+// and ends their cookie sessions except keepSessionID. It tries all three
+// even if one fails.
+func (s *Server) revokeUserCredentials(ctx context.Context, userID, keepSessionID int) error {
+	now := time.Now()
+	return errors.Join(
+		s.Denylist.RevokeUser(ctx, userID, now, now.Add(s.Config.AccessTokenTTL)),
+		s.RefreshTokens.RevokeUser(ctx, userID),
+		s.Sessions.DeleteUser(ctx, userID, keepSessionID),
+	)
+}
+
+// checkPasswordPolicy writes a 400 listing every broken rule and returns
+// false if password is not acceptable for the user.
+func (s *Server) checkPasswordPolicy(w http.ResponseWriter, field, password, username, email string) bool {
+	err := s.PasswordPolicy.Check(field, password, username, email)
+	if err == nil {
+		return true
+	}
+
+	CustomJsonResponse(w, http.StatusBadRequest, map[string]string{field: err.Error()})
+	return false
+}
@@ -1,34 +1,35 @@
 package main
 
 import (
-	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
 
 	"github.com/go-playground/validator/v10"
 )
 
-func PostHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) PostHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
-		getPost(w, r)
+		s.getPost(w, r)
 	case http.MethodPost:
-		createPost(w, r)
+		s.createPost(w, r)
 	case http.MethodPut:
-		updatePost(w, r)
+		s.updatePost(w, r)
 	case http.MethodDelete:
-		deletePost(w, r)
+		s.deletePost(w, r)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
-func getPost(w http.ResponseWriter, r *http.Request) {
+func (s *Server) getPost(w http.ResponseWriter, r *http.Request) {
 	postIdStr := r.URL.Query().Get("id")
 	if postIdStr == "" {
-		getPosts(w, r)
+		s.getPosts(w, r)
 
 		return
 	}
@@ -39,9 +40,12 @@ func getPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	query := `SELECT * FROM posts WHERE id = $1`
-	var post Post
-	if err := DB.QueryRow(context.Background(), query, postId).Scan(&post.ID, &post.Title, &post.Body, &post.UserId); err != nil {
+	post, err := s.Posts.Get(r.Context(), postId)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "Post not found", http.StatusNotFound)
+			return
+		}
 		http.Error(w, "Failed to get post from DB", http.StatusInternalServerError)
 		return
 	}
@@ -49,29 +53,95 @@ func getPost(w http.ResponseWriter, r *http.Request) {
 	CustomJsonResponse(w, http.StatusOK, post)
 }
 
-func getPosts(w http.ResponseWriter, _ *http.Request) {
-	query := `SELECT * FROM posts ORDER BY id DESC`
-	rows, err := DB.Query(context.Background(), query)
+// getPosts lists posts newest first, one page at a time. Posts can be
+// filtered by userId and createdAfter.
+func (s *Server) getPosts(w http.ResponseWriter, r *http.Request) {
+	q, ok := parseListQuery(w, r, postSortFields, "-id")
+	if !ok {
+		return
+	}
+
+	errs := make(map[string]string)
+	filter := parsePostFilter(r.URL.Query(), errs)
+	if len(errs) > 0 {
+		CustomJsonResponse(w, http.StatusBadRequest, errs)
+		return
+	}
+
+	posts, err := s.Posts.List(r.Context(), filter, q)
 	if err != nil {
 		http.Error(w, "Failed to get posts from DB", http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
 
-	var posts []Post
-	for rows.Next() {
-		var post Post
-		if err := rows.Scan(&post.ID, &post.Title, &post.Body, &post.UserId); err != nil {
-			http.Error(w, "Failed to scan post", http.StatusInternalServerError)
-			return
+	writePage(w, r, newPage(posts, q, postSortFields[q.Sort]))
+}
+
+// parsePostFilter reads the userId, createdAfter, category, tag and tagMode
+// filters. Errors are added to errs.
+func parsePostFilter(query url.Values, errs map[string]string) PostFilter {
+	var filter PostFilter
+	if value := query.Get("userId"); value != "" {
+		userID, err := strconv.Atoi(value)
+		if err != nil || userID <= 0 {
+			errs["userId"] = "userId must be a positive integer"
+		}
+		filter.UserID = userID
+	}
+	filter.CreatedAfter = timeParam(query, "createdAfter", errs)
+
+	if value := query.Get("category"); value != "" {
+		filter.Category = slugify(value)
+		if !validSlug(filter.Category) {
+			errs["category"] = fmt.Sprintf("%q is not a valid category", value)
+		}
+	}
+
+	if len(query["tag"]) > 0 {
+		tags, err := normalizeTags(query["tag"])
+		if err != nil {
+			errs["tag"] = err.Error()
+		}
+		filter.Tags = tags
+	}
+	switch query.Get("tagMode") {
+	case "", "and":
+	case "or":
+		filter.AnyTag = true
+	default:
+		errs["tagMode"] = `tagMode must be "and" or "or"`
+	}
+
+	return filter
+}
+
+// normalizeTaxonomy turns the post's tags and category into slugs. On
+// failure it writes the error response and returns false.
+func normalizeTaxonomy(w http.ResponseWriter, post *Post) bool {
+	errs := make(map[string]string)
+
+	tags, err := normalizeTags(post.Tags)
+	if err != nil {
+		errs["Tags"] = err.Error()
+	}
+	post.Tags = tags
+
+	if post.Category != "" {
+		category := slugify(post.Category)
+		if !validSlug(category) {
+			errs["Category"] = fmt.Sprintf("%q is not a valid category", post.Category)
 		}
-		posts = append(posts, post)
+		post.Category = category
 	}
 
-	CustomJsonResponse(w, http.StatusOK, posts)
+	if len(errs) > 0 {
+		CustomJsonResponse(w, http.StatusBadRequest, errs)
+		return false
+	}
+	return true
 }
 
-func createPost(w http.ResponseWriter, r *http.Request) {
+func (s *Server) createPost(w http.ResponseWriter, r *http.Request) {
 	var post Post
 
 	if err := json.NewDecoder(r.Body).Decode(&post); err != nil {
@@ -96,21 +166,28 @@ func createPost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	query := `INSERT INTO posts (title, body, user_id) VALUES ($1, $2, $3) RETURNING id`
+	if !normalizeTaxonomy(w, &post) {
+		return
+	}
+
+	claims, ok := GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
 
-	var id int
+	// The author is always the caller, whatever the body says.
+	post.UserId = claims.ID
 
-	if err := DB.QueryRow(context.Background(), query, post.Title, post.Body, post.UserId).Scan(&id); err != nil {
+	if err := s.Posts.Create(r.Context(), &post); err != nil {
 		http.Error(w, "Failed to insert post in DB", http.StatusInternalServerError)
 		return
 	}
 
-	post.ID = id
-
 	CustomJsonResponse(w, http.StatusCreated, post)
 }
 
-func deletePost(w http.ResponseWriter, r *http.Request) {
+func (s *Server) deletePost(w http.ResponseWriter, r *http.Request) {
 	postIdStr := r.URL.Query().Get("id")
 	if postIdStr == "" {
 		http.Error(w, "postIs is missing", http.StatusBadRequest)
@@ -125,23 +202,23 @@ func deletePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	query := `DELETE FROM posts WHERE id = $1`
-
-	result, err := DB.Exec(context.Background(), query, postId)
-	if err != nil {
-		http.Error(w, "Failed to delete post from DB", http.StatusInternalServerError)
+	if _, ok := s.authorizePostWrite(w, r, postId); !ok {
 		return
 	}
 
-	if result.RowsAffected() == 0 {
-		http.Error(w, "Post not found", http.StatusNotFound)
+	if err := s.Posts.Delete(r.Context(), postId); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "Post not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to delete post from DB", http.StatusInternalServerError)
 		return
 	}
 
 	CustomJsonResponse(w, http.StatusOK, Success{Completed: true, Message: "Post deleted successfully"})
 }
 
-func updatePost(w http.ResponseWriter, r *http.Request) {
+func (s *Server) updatePost(w http.ResponseWriter, r *http.Request) {
 	var post Post
 	if err := json.NewDecoder(r.Body).Decode(&post); err != nil {
 		http.Error(w, "Unable to get Request", http.StatusBadRequest)
@@ -167,18 +244,52 @@ func updatePost(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	query := `UPDATE posts set title=$1, user_id=$2, body=$3 WHERE id=$4`
-	result, err := DB.Exec(context.Background(), query, post.Title, post.UserId, post.Body, post.ID)
-	if err != nil {
-		http.Error(w, "Failed to update post from DB", http.StatusInternalServerError)
+	if !normalizeTaxonomy(w, &post) {
+		return
+	}
+
+	existing, ok := s.authorizePostWrite(w, r, post.ID)
+	if !ok {
 		return
 	}
+	post.UserId = existing.UserId
 
-	if result.RowsAffected() == 0 {
-		http.Error(w, "Post not found", http.StatusNotFound)
+	if err := s.Posts.Update(r.Context(), &post); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "Post not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to update post from DB", http.StatusInternalServerError)
 		return
 	}
 
 	CustomJsonResponse(w, http.StatusOK, post)
 
 }
+
+// authorizePostWrite loads the post and checks that the caller owns it or may
+// moderate posts. On failure it writes the error response and returns false.
+func (s *Server) authorizePostWrite(w http.ResponseWriter, r *http.Request, postId int) (Post, bool) {
+	claims, ok := GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return Post{}, false
+	}
+
+	post, err := s.Posts.Get(r.Context(), postId)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "Post not found", http.StatusNotFound)
+			return Post{}, false
+		}
+		http.Error(w, "Failed to get post from DB", http.StatusInternalServerError)
+		return Post{}, false
+	}
+
+	if post.UserId != claims.ID && !claims.Can(PermPostsModerate) {
+		http.Error(w, "You can only modify your own posts", http.StatusForbidden)
+		return Post{}, false
+	}
+
+	return post, true
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// PostSearchHandler searches post titles and bodies.
+func (s *Server) PostSearchHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.searchPosts(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// searchPosts pages through the posts matching q, best matches first. It
+// takes the same filters as the post listing.
+func (s *Server) searchPosts(w http.ResponseWriter, r *http.Request) {
+	q, ok := parseListQuery(w, r, searchSortFields, "-rank")
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	errs := make(map[string]string)
+
+	text := query.Get("q")
+	terms := parseSearchQuery(text)
+	switch {
+	case len(text) > maxSearchQueryLength:
+		errs["q"] = fmt.Sprintf("q must be at most %d characters long", maxSearchQueryLength)
+	case len(terms) == 0:
+		errs["q"] = "q must contain at least one word"
+	}
+
+	filter := parsePostFilter(query, errs)
+	if len(errs) > 0 {
+		CustomJsonResponse(w, http.StatusBadRequest, errs)
+		return
+	}
+
+	results, err := s.Posts.Search(r.Context(), terms, filter, q)
+	if err != nil {
+		http.Error(w, "Failed to search posts", http.StatusInternalServerError)
+		return
+	}
+
+	writePage(w, r, newPage(results, q, searchSortFields[q.Sort]))
+}
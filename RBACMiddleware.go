@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+)
+
+// Policy maps HTTP methods to the permission they require. Methods that are
+// not listed are passed through without authentication.
+type Policy map[string]Permission
+
+// Authorize authenticates and checks the permission for methods covered by
+// policy before calling next.
+func (s *Server) Authorize(policy Policy, next http.Handler) http.Handler {
+	protected := make(map[string]http.Handler, len(policy))
+	for method, perm := range policy {
+		protected[method] = s.AuthMiddleware(RequirePermission(perm)(next))
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if handler, ok := protected[r.Method]; ok {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequirePermission must run after AuthMiddleware.
+func RequirePermission(perm Permission) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			claims, ok := GetUserFromContext(r.Context())
+			if !ok {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if !claims.Can(perm) {
+				if claims.MissingMFA(perm) {
+					http.Error(w, "Two-factor authentication is required for this role", http.StatusForbidden)
+					return
+				}
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireSession refuses API keys for anything but reads, so a leaked key
+// cannot change the account's email, password, two-factor settings or keys.
+// It must run after AuthMiddleware.
+func RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := GetUserFromContext(r.Context())
+		if !ok {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if claims.APIKeyID != 0 && !isSafeMethod(r.Method) {
+			http.Error(w, "API keys cannot be used for this request", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/go-playground/validator/v10"
+)
+
+func (s *Server) SignupHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		s.signup(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// signup registers a member account that cannot log in until its email
+// address is verified.
+func (s *Server) signup(w http.ResponseWriter, r *http.Request) {
+	var req Signup
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
+		errs := make(map[string]string)
+
+		for _, err := range err.(validator.ValidationErrors) {
+			field := err.Field()
+			switch err.Tag() {
+			case "required":
+				errs[field] = fmt.Sprintf("%s is required", field)
+			case "email":
+				errs[field] = fmt.Sprintf("%s is not a valid email", field)
+			case "min":
+				errs[field] = fmt.Sprintf("%s must be at least %s characters long", field, err.Param())
+			}
+		}
+
+		CustomJsonResponse(w, http.StatusBadRequest, errs)
+		return
+	}
+
+	if s.ssoRequired(req.Email) {
+		http.Error(w, "Accounts for this email domain are created through single sign-on", http.StatusForbidden)
+		return
+	}
+
+	user := User{
+		Username: req.Username,
+		Email:    req.Email,
+		IsActive: true,
+		Role:     RoleMember,
+	}
+
+	if err := s.checkUsernameOrEmail(r.Context(), user); err != nil {
+		CustomJsonResponse(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		return
+	}
+
+	if !s.checkPasswordPolicy(w, "Password", req.Password, req.Username, req.Email) {
+		return
+	}
+
+	hashedPassword, err := s.Passwords.Hash(req.Password)
+	if err != nil {
+		CustomJsonResponse(w, http.StatusInternalServerError, map[string]string{"error": "Failed to hash password"})
+		return
+	}
+
+	user.Password = hashedPassword
+	if err := s.Users.Create(r.Context(), &user); err != nil {
+		if errors.Is(err, ErrConflict) {
+			CustomJsonResponse(w, http.StatusConflict, map[string]string{"error": "username or email already exists"})
+			return
+		}
+		CustomJsonResponse(w, http.StatusInternalServerError, map[string]string{"error": "Failed to create user"})
+		return
+	}
+
+	s.sendVerificationEmail(r.Context(), user)
+
+	CustomJsonResponse(w, http.StatusCreated, map[string]int{"id": user.ID})
+}
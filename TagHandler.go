@@ -0,0 +1,23 @@
+package main
+
+import "net/http"
+
+// TagsHandler lists the tags in use with how many posts carry each.
+func (s *Server) TagsHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.getTags(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) getTags(w http.ResponseWriter, r *http.Request) {
+	tags, err := s.Posts.Tags(r.Context())
+	if err != nil {
+		http.Error(w, "Failed to get tags from DB", http.StatusInternalServerError)
+		return
+	}
+
+	CustomJsonResponse(w, http.StatusOK, tags)
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+func (s *Server) RefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		s.refreshToken(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) refreshToken(w http.ResponseWriter, r *http.Request) {
+	var req RefreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
+		CustomJsonResponse(w, http.StatusBadRequest, map[string]string{"refreshToken": "refreshToken is required"})
+		return
+	}
+
+	stored, err := s.RefreshTokens.GetByHash(r.Context(), hashToken(req.RefreshToken))
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, "Failed to look up refresh token", http.StatusInternalServerError)
+		return
+	}
+
+	if stored.RevokedAt != nil || time.Now().After(stored.ExpiresAt) {
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	// A token that was already rotated is being replayed, so whoever holds
+	// it may have stolen the family. Revoke every token descended from it.
+	rotated, err := s.RefreshTokens.MarkUsed(r.Context(), stored.ID)
+	if err != nil {
+		http.Error(w, "Failed to rotate refresh token", http.StatusInternalServerError)
+		return
+	}
+	if !rotated {
+		log.Printf("refresh token reuse detected for user %d, revoking family %s", stored.UserID, stored.FamilyID)
+		if err := s.RefreshTokens.RevokeFamily(r.Context(), stored.FamilyID); err != nil {
+			log.Println(err)
+		}
+		http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+		return
+	}
+
+	user, err := s.Users.Get(r.Context(), stored.UserID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "Invalid refresh token", http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, "Failed to retrieve user", http.StatusInternalServerError)
+		return
+	}
+
+	if !user.IsActive {
+		http.Error(w, "Account is deactivated", http.StatusForbidden)
+		return
+	}
+
+	tokens, err := s.issueTokens(r.Context(), user, stored.FamilyID, stored.MFA)
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+
+	CustomJsonResponse(w, http.StatusOK, tokens)
+}
+
+// issueTokens creates an access token and a refresh token for user. An empty
+// familyID starts a new refresh token family, as on login. mfa marks a session
+// that passed a second factor and is carried over on rotation.
+func (s *Server) issueTokens(ctx context.Context, user User, familyID string, mfa bool) (TokenResponse, error) {
+	accessToken, err := s.generateJWTWithClaims(user, mfa)
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	if familyID == "" {
+		if familyID, err = randomID(); err != nil {
+			return TokenResponse{}, err
+		}
+	}
+
+	refreshToken, refreshHash, err := generateOpaqueToken()
+	if err != nil {
+		return TokenResponse{}, err
+	}
+
+	stored := RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		TokenHash: refreshHash,
+		MFA:       mfa,
+		ExpiresAt: time.Now().Add(s.Config.RefreshTokenTTL),
+	}
+	if err := s.RefreshTokens.Create(ctx, &stored); err != nil {
+		return TokenResponse{}, err
+	}
+
+	return TokenResponse{
+		Token:        accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(s.Config.AccessTokenTTL.Seconds()),
+	}, nil
+}
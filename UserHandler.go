@@ -1,34 +1,37 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"mime"
 	"net/http"
 	"strconv"
-	"time"
 
 	"github.com/go-playground/validator/v10"
-	"github.com/jackc/pgx/v5"
 )
 
-func UserHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) UserHandler(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodPost:
-		createUser(w, r)
+		s.createUser(w, r)
 	case http.MethodGet:
-		getUser(w, r)
+		s.getUser(w, r)
 	case http.MethodPut:
-		updateUser(w, r)
+		s.updateUser(w, r)
+	case http.MethodPatch:
+		s.patchUser(w, r)
 	case http.MethodDelete:
-		deleteUser(w, r)
+		s.deleteUser(w, r)
 	default:
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}
 }
 
-func createUser(w http.ResponseWriter, r *http.Request) {
+func (s *Server) createUser(w http.ResponseWriter, r *http.Request) {
 	var user User
 	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
@@ -47,6 +50,8 @@ func createUser(w http.ResponseWriter, r *http.Request) {
 				errs[field] = fmt.Sprintf("%s is not a valid email", field)
 			case "min":
 				errs[field] = fmt.Sprintf("%s must be at least %s characters long", field, err.Param())
+			case "oneof":
+				errs[field] = fmt.Sprintf("%s must be one of: %s", field, err.Param())
 			}
 		}
 
@@ -54,50 +59,59 @@ func createUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := checkUsernameOrEmail(user); err != nil {
+	if err := s.checkUsernameOrEmail(r.Context(), user); err != nil {
 		CustomJsonResponse(w, http.StatusConflict, map[string]string{"error": err.Error()})
 		return
 	}
 
-	hashedPassword, err := HashPassword(user.Password)
+	if !s.checkPasswordPolicy(w, "Password", user.Password, user.Username, user.Email) {
+		return
+	}
+
+	hashedPassword, err := s.Passwords.Hash(user.Password)
 	if err != nil {
 		CustomJsonResponse(w, http.StatusInternalServerError, map[string]string{"error": "Failed to hash password"})
 		return
 	}
 
-	query := `INSERT INTO users (username, email, password, is_active) VALUES ($1, $2, $3, $4) RETURNING id`
-	var userID int
-	if err := DB.QueryRow(context.Background(), query, user.Username, user.Email, hashedPassword, user.IsActive).Scan(&userID); err != nil {
+	if user.Role == "" {
+		user.Role = RoleMember
+	}
+
+	// New accounts always start unverified, whatever the body says.
+	user.EmailVerifiedAt = nil
+	user.DeactivatedAt, user.DeactivationReason = nil, nil
+
+	user.Password = hashedPassword
+	if err := s.Users.Create(r.Context(), &user); err != nil {
+		if errors.Is(err, ErrConflict) {
+			CustomJsonResponse(w, http.StatusConflict, map[string]string{"error": "username or email already exists"})
+			return
+		}
 		CustomJsonResponse(w, http.StatusInternalServerError, map[string]string{"error": "Failed to create user"})
 		return
 	}
 
-	CustomJsonResponse(w, http.StatusCreated, map[string]int{"id": userID})
-}
-
-func checkUsernameOrEmail(user User) error {
-	var count int
+	s.sendVerificationEmail(r.Context(), user)
 
-	args := []any{user.Username, user.Email}
+	CustomJsonResponse(w, http.StatusCreated, map[string]int{"id": user.ID})
+}
 
-	query := `SELECT COUNT(*) FROM users WHERE (username = $1 OR email = $2)`
-	if user.ID != 0 {
-		query += ` AND id != $3`
-		args = append(args, user.ID)
-	}
-	if err := DB.QueryRow(context.Background(), query, args...).Scan(&count); err != nil {
+func (s *Server) checkUsernameOrEmail(ctx context.Context, user User) error {
+	exists, err := s.Users.ExistsByUsernameOrEmail(ctx, user.Username, user.Email, user.ID)
+	if err != nil {
 		return err
 	}
-	if count > 0 {
+	if exists {
 		return fmt.Errorf("username or email already exists")
 	}
 	return nil
 }
 
-func getUser(w http.ResponseWriter, r *http.Request) {
+func (s *Server) getUser(w http.ResponseWriter, r *http.Request) {
 	userIDStr := r.URL.Query().Get("id")
 	if userIDStr == "" {
-		getUsers(w, r)
+		s.getUsers(w, r)
 		return
 	}
 
@@ -107,15 +121,12 @@ func getUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	query := `SELECT id, username, email, is_active, created_at, updated_at FROM users WHERE id = $1`
-
-	var user User
-
-	if err := DB.QueryRow(context.Background(), query, userID).Scan(&user.ID, &user.Username, &user.Email, &user.IsActive, &user.CreatedAt, &user.UpdatedAt); err != nil {
+	user, err := s.Users.Get(r.Context(), userID)
+	if err != nil {
 
 		log.Println(err)
 
-		if err == pgx.ErrNoRows {
+		if errors.Is(err, ErrNotFound) {
 			http.Error(w, "User not found", http.StatusNotFound)
 			return
 		}
@@ -123,56 +134,65 @@ func getUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	user.ID = userID
-
 	CustomJsonResponse(w, http.StatusOK, user)
 }
 
-func getUsers(w http.ResponseWriter, r *http.Request) {
-	// checking the user from context
-	user, ok := GetUserFromContext(r.Context())
+func (s *Server) getUsers(w http.ResponseWriter, r *http.Request) {
+	q, ok := parseListQuery(w, r, userSortFields, "id")
 	if !ok {
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
 		return
 	}
 
-	println(">>>>>>>>>>>>>>>>>>>>")
-	log.Printf("%+v", user)
-	log.Println(user.Username)
-	println(">>>>>>>>>>>>>>>>>>>>")
+	query := r.URL.Query()
+	errs := make(map[string]string)
+
+	var filter UserFilter
+	if value := query.Get("isActive"); value != "" {
+		isActive, err := strconv.ParseBool(value)
+		if err != nil {
+			errs["isActive"] = "isActive must be true or false"
+		}
+		filter.IsActive = &isActive
+	}
+	if value := query.Get("role"); value != "" {
+		filter.Role = Role(value)
+		if _, ok := rolePermissions[filter.Role]; !ok {
+			errs["role"] = "role must be one of: admin editor member"
+		}
+	}
+	filter.CreatedAfter = timeParam(query, "createdAfter", errs)
 
-	users := make([]User, 0)
+	if len(errs) > 0 {
+		CustomJsonResponse(w, http.StatusBadRequest, errs)
+		return
+	}
 
-	query := `SELECT id, username, email, is_active, created_at, updated_at FROM users`
-	rows, err := DB.Query(context.Background(), query)
+	users, err := s.Users.List(r.Context(), filter, q)
 	if err != nil {
 		log.Println(err)
 		http.Error(w, "Failed to retrieve users", http.StatusInternalServerError)
 		return
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var user User
-		if err := rows.Scan(&user.ID, &user.Username, &user.Email, &user.IsActive, &user.CreatedAt, &user.UpdatedAt); err != nil {
-			log.Println(err)
-			http.Error(w, "Failed to scan user", http.StatusInternalServerError)
-			return
-		}
-		users = append(users, user)
-	}
 
-	CustomJsonResponse(w, http.StatusOK, users)
+	writePage(w, r, newPage(users, q, userSortFields[q.Sort]))
 }
 
-func updateUser(w http.ResponseWriter, r *http.Request) {
+// updateUser replaces the user's details. The password and status are kept
+// as stored: passwords change through /user/password or a reset, and the
+// status through /user/deactivate and /user/reactivate.
+func (s *Server) updateUser(w http.ResponseWriter, r *http.Request) {
 	var user User
 	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
 
-	if err := validate.Struct(user); err != nil {
+	if user.Password != "" {
+		http.Error(w, "Use POST /user/password to change the password", http.StatusBadRequest)
+		return
+	}
+
+	if err := validate.StructExcept(user, "IsActive", "Password"); err != nil {
 		errs := make(map[string]string)
 
 		for _, err := range err.(validator.ValidationErrors) {
@@ -184,6 +204,8 @@ func updateUser(w http.ResponseWriter, r *http.Request) {
 				errs[field] = fmt.Sprintf("%s is not a valid email", field)
 			case "min":
 				errs[field] = fmt.Sprintf("%s must be at least %s characters long", field, err.Param())
+			case "oneof":
+				errs[field] = fmt.Sprintf("%s must be one of: %s", field, err.Param())
 			}
 		}
 
@@ -191,27 +213,189 @@ func updateUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := checkUsernameOrEmail(user); err != nil {
+	if err := s.checkUsernameOrEmail(r.Context(), user); err != nil {
 		CustomJsonResponse(w, http.StatusConflict, map[string]string{"error": err.Error()})
 		return
 	}
 
-	hashedPassword, err := HashPassword(user.Password)
+	// Keep the current role unless the request sets one.
+	if user.Role == "" {
+		existing, err := s.Users.Get(r.Context(), user.ID)
+		if err != nil {
+			if errors.Is(err, ErrNotFound) {
+				http.Error(w, "User not found", http.StatusNotFound)
+				return
+			}
+			CustomJsonResponse(w, http.StatusInternalServerError, map[string]string{"error": "Failed to update user"})
+			return
+		}
+		user.Role = existing.Role
+	}
+
+	if err := s.Users.Update(r.Context(), &user); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		CustomJsonResponse(w, http.StatusInternalServerError, map[string]string{"error": "Failed to update user"})
+		return
+	}
+	s.userStatus.Invalidate(user.ID)
+
+	CustomJsonResponse(w, http.StatusOK, map[string]string{"message": "User updated successfully"})
+}
+
+// patchUser applies a JSON Merge Patch or JSON Patch, chosen by Content-Type,
+// to the editable fields of a user. Only the fields the patch touches are
+// validated, and the password is never changed here.
+func (s *Server) patchUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil || userID <= 0 {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != mergePatchContentType && mediaType != jsonPatchContentType {
+		http.Error(w, "Content-Type must be "+mergePatchContentType+" or "+jsonPatchContentType, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	user, err := s.Users.Get(r.Context(), userID)
 	if err != nil {
-		CustomJsonResponse(w, http.StatusInternalServerError, map[string]string{"error": "Failed to hash password"})
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to retrieve user", http.StatusInternalServerError)
 		return
 	}
 
-	query := `UPDATE users SET username = $1, email = $2, password = $3, is_active = $4, updated_at = $5 WHERE id = $6`
-	if _, err := DB.Exec(context.Background(), query, user.Username, user.Email, hashedPassword, user.IsActive, time.Now(), user.ID); err != nil {
+	var doc any = map[string]any{
+		"username": user.Username,
+		"email":    user.Email,
+		"isActive": user.IsActive,
+		"role":     string(user.Role),
+	}
+	touched := make(map[string]bool)
+
+	if mediaType == mergePatchContentType {
+		var patch map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		for key := range patch {
+			touched[key] = true
+		}
+		doc = applyMergePatch(doc, patch)
+	} else {
+		var ops []PatchOperation
+		if err := json.NewDecoder(r.Body).Decode(&ops); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		for _, op := range ops {
+			paths := []string{op.Path}
+			if op.Op == "move" || op.Op == "copy" {
+				paths = append(paths, op.From)
+			}
+			for _, path := range paths {
+				tokens, err := parsePointer(path)
+				switch {
+				case err != nil:
+				case len(tokens) == 0:
+					// The operation works on the whole document, so any
+					// field may have changed.
+					for key := range userPatchFields {
+						touched[key] = true
+					}
+				default:
+					touched[tokens[0]] = true
+				}
+			}
+		}
+		if doc, err = applyJSONPatch(doc, ops); err != nil {
+			CustomJsonResponse(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+			return
+		}
+	}
+
+	if touched["password"] {
+		http.Error(w, "Use POST /user/password to change the password", http.StatusBadRequest)
+		return
+	}
+
+	patched, err := json.Marshal(doc)
+	if err != nil {
+		http.Error(w, "Failed to apply patch", http.StatusInternalServerError)
+		return
+	}
+
+	var fields UserPatch
+	decoder := json.NewDecoder(bytes.NewReader(patched))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&fields); err != nil {
+		CustomJsonResponse(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+		return
+	}
+
+	var validateFields []string
+	for key := range touched {
+		if field, ok := userPatchFields[key]; ok {
+			validateFields = append(validateFields, field)
+		}
+	}
+
+	if err := validate.StructPartial(fields, validateFields...); err != nil {
+		errs := make(map[string]string)
+
+		for _, err := range err.(validator.ValidationErrors) {
+			field := err.Field()
+			switch err.Tag() {
+			case "required":
+				errs[field] = fmt.Sprintf("%s is required", field)
+			case "email":
+				errs[field] = fmt.Sprintf("%s is not a valid email", field)
+			case "min":
+				errs[field] = fmt.Sprintf("%s must be at least %s characters long", field, err.Param())
+			case "oneof":
+				errs[field] = fmt.Sprintf("%s must be one of: %s", field, err.Param())
+			}
+		}
+
+		CustomJsonResponse(w, http.StatusBadRequest, errs)
+		return
+	}
+
+	if fields.IsActive != user.IsActive {
+		http.Error(w, "Use POST /user/deactivate or /user/reactivate to change isActive", http.StatusBadRequest)
+		return
+	}
+
+	user.Username = fields.Username
+	user.Email = fields.Email
+	user.Role = fields.Role
+
+	if err := s.checkUsernameOrEmail(r.Context(), user); err != nil {
+		CustomJsonResponse(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		return
+	}
+
+	if err := s.Users.Update(r.Context(), &user); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
 		CustomJsonResponse(w, http.StatusInternalServerError, map[string]string{"error": "Failed to update user"})
 		return
 	}
+	s.userStatus.Invalidate(user.ID)
 
-	CustomJsonResponse(w, http.StatusOK, map[string]string{"message": "User updated successfully"})
+	CustomJsonResponse(w, http.StatusOK, user)
 }
 
-func deleteUser(w http.ResponseWriter, r *http.Request) {
+func (s *Server) deleteUser(w http.ResponseWriter, r *http.Request) {
 	userIDStr := r.URL.Query().Get("id")
 	if userIDStr == "" {
 		http.Error(w, "User ID is required", http.StatusBadRequest)
@@ -224,18 +408,16 @@ func deleteUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	query := `DELETE FROM users WHERE id = $1`
-	result, err := DB.Exec(context.Background(), query, userID)
-	if err != nil {
+	if err := s.Users.Delete(r.Context(), userID); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
 		log.Println(err)
 		http.Error(w, "Failed to delete user", http.StatusInternalServerError)
 		return
 	}
-
-	if result.RowsAffected() == 0 {
-		http.Error(w, "User not found", http.StatusNotFound)
-		return
-	}
+	s.userStatus.Invalidate(userID)
 
 	CustomJsonResponse(w, http.StatusOK, map[string]string{"message": "User deleted successfully"})
 }
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/go-playground/validator/v10"
+)
+
+func (s *Server) DeactivateUserHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		s.deactivateUser(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// deactivateUser blocks the user from logging in and ends their sessions.
+// Access tokens already issued stop working once the status cache expires.
+func (s *Server) deactivateUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil || userID <= 0 {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	var req DeactivateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
+		errs := make(map[string]string)
+
+		for _, err := range err.(validator.ValidationErrors) {
+			field := err.Field()
+			switch err.Tag() {
+			case "required":
+				errs[field] = fmt.Sprintf("%s is required", field)
+			case "max":
+				errs[field] = fmt.Sprintf("%s must be at most %s characters long", field, err.Param())
+			}
+		}
+
+		CustomJsonResponse(w, http.StatusBadRequest, errs)
+		return
+	}
+
+	claims, ok := GetUserFromContext(r.Context())
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if claims.ID == userID {
+		http.Error(w, "You cannot deactivate your own account", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.Users.SetActive(r.Context(), userID, false, req.Reason); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to deactivate user", http.StatusInternalServerError)
+		return
+	}
+	s.userStatus.Invalidate(userID)
+
+	if err := s.RefreshTokens.RevokeUser(r.Context(), userID); err != nil {
+		log.Println(err)
+	}
+	if err := s.Sessions.DeleteUser(r.Context(), userID, 0); err != nil {
+		log.Println(err)
+	}
+
+	log.Printf("user %d deactivated user %d: %s", claims.ID, userID, req.Reason)
+
+	CustomJsonResponse(w, http.StatusOK, Success{Completed: true, Message: "User deactivated successfully"})
+}
+
+func (s *Server) ReactivateUserHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		s.reactivateUser(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) reactivateUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil || userID <= 0 {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.Users.SetActive(r.Context(), userID, true, ""); err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "Failed to reactivate user", http.StatusInternalServerError)
+		return
+	}
+	s.userStatus.Invalidate(userID)
+
+	if claims, ok := GetUserFromContext(r.Context()); ok {
+		log.Printf("user %d reactivated user %d", claims.ID, userID)
+	}
+
+	CustomJsonResponse(w, http.StatusOK, Success{Completed: true, Message: "User reactivated successfully"})
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const emailVerificationAudience = "email-verification"
+
+func (s *Server) VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		s.verifyEmail(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	tokenString := r.URL.Query().Get("token")
+	if tokenString == "" {
+		http.Error(w, "token is required", http.StatusBadRequest)
+		return
+	}
+
+	token, err := jwt.ParseWithClaims(tokenString, &EmailVerificationClaims{}, s.Keys.Keyfunc,
+		jwt.WithValidMethods(s.Keys.Methods()), jwt.WithAudience(emailVerificationAudience))
+	if err != nil || !token.Valid {
+		http.Error(w, "Invalid or expired verification link", http.StatusBadRequest)
+		return
+	}
+
+	claims := token.Claims.(*EmailVerificationClaims)
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		http.Error(w, "Invalid or expired verification link", http.StatusBadRequest)
+		return
+	}
+
+	user, err := s.Users.Get(r.Context(), userID)
+	if err != nil {
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "Invalid or expired verification link", http.StatusBadRequest)
+			return
+		}
+		http.Error(w, "Failed to retrieve user", http.StatusInternalServerError)
+		return
+	}
+
+	// A link sent to an address the user has since changed is no longer valid.
+	if user.Email != claims.Email {
+		http.Error(w, "Invalid or expired verification link", http.StatusBadRequest)
+		return
+	}
+
+	if user.EmailVerifiedAt == nil {
+		now := time.Now()
+		if err := s.Users.SetEmailVerified(r.Context(), user.ID, &now); err != nil {
+			http.Error(w, "Failed to verify email", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	CustomJsonResponse(w, http.StatusOK, Success{Completed: true, Message: "Email verified successfully"})
+}
+
+func (s *Server) ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		s.resendVerification(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// resendVerification answers the same way whether or not the address
+// belongs to an unverified account.
+func (s *Server) resendVerification(w http.ResponseWriter, r *http.Request) {
+	var req ResendVerificationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if err := validate.Struct(req); err != nil {
+		CustomJsonResponse(w, http.StatusBadRequest, map[string]string{"Email": "Email is not a valid email"})
+		return
+	}
+
+	if !s.resendLimiter.Allow(strings.ToLower(req.Email)) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(s.Config.VerificationResendInterval.Seconds())))
+		http.Error(w, "Too many requests, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	user, err := s.Users.GetByEmail(r.Context(), req.Email)
+	if err == nil && user.EmailVerifiedAt == nil {
+		s.sendVerificationEmail(r.Context(), user)
+	} else if err != nil && !errors.Is(err, ErrNotFound) {
+		log.Println(err)
+	}
+
+	CustomJsonResponse(w, http.StatusAccepted, Success{Completed: true, Message: "If the address needs verifying, a new link has been sent"})
+}
+
+// sendVerificationEmail signs a link bound to the user's current email and
+// mails it in the background.
+func (s *Server) sendVerificationEmail(ctx context.Context, user User) {
+	token, err := s.Keys.Sign(EmailVerificationClaims{
+		Email: user.Email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   strconv.Itoa(user.ID),
+			Audience:  jwt.ClaimStrings{emailVerificationAudience},
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(s.Config.EmailVerificationTTL)),
+		},
+	})
+	if err != nil {
+		log.Printf("failed to sign verification token for user %d: %v", user.ID, err)
+		return
+	}
+
+	msg := Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nConfirm your email address by opening the link below. It expires in %s.\n\n%s/verify-email?token=%s\n",
+			user.Username, s.Config.EmailVerificationTTL, s.Config.PublicURL, url.QueryEscape(token)),
+	}
+
+	go func() {
+		if err := s.Mailer.Send(context.WithoutCancel(ctx), msg); err != nil {
+			log.Printf("failed to send verification email to user %d: %v", user.ID, err)
+		}
+	}()
+}
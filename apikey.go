@@ -0,0 +1,49 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+)
+
+// API keys look like grk_<prefix>_<secret>. The prefix is stored in the clear
+// to find the key, and the whole key is stored hashed.
+const (
+	apiKeyMarker       = "grk_"
+	apiKeyPrefixLength = 12
+)
+
+func generateAPIKey() (key, prefix, keyHash string, err error) {
+	buf := make([]byte, apiKeyPrefixLength/2)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", "", err
+	}
+	prefix = hex.EncodeToString(buf)
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", "", err
+	}
+
+	key = apiKeyMarker + prefix + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	return key, prefix, hashToken(key), nil
+}
+
+// isAPIKey tells API keys apart from JWTs sent as bearer tokens.
+func isAPIKey(token string) bool {
+	return strings.HasPrefix(token, apiKeyMarker)
+}
+
+func parseAPIKeyPrefix(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, apiKeyMarker)
+	if !ok {
+		return "", false
+	}
+
+	prefix, secret, ok := strings.Cut(rest, "_")
+	if !ok || len(prefix) != apiKeyPrefixLength || secret == "" {
+		return "", false
+	}
+	return prefix, true
+}
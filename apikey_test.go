@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseAPIKeyPrefix(t *testing.T) {
+	key, prefix, keyHash, err := generateAPIKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !isAPIKey(key) || keyHash != hashToken(key) {
+		t.Errorf("generateAPIKey() = %q, %q, want a marked key and its hash", key, keyHash)
+	}
+	if got, ok := parseAPIKeyPrefix(key); !ok || got != prefix {
+		t.Errorf("parseAPIKeyPrefix(%q) = %q, %v, want %q", key, got, ok, prefix)
+	}
+
+	for _, key := range []string{
+		"",
+		"eyJhbGciOiJIUzI1NiJ9.e30.sig",
+		"grk_" + prefix,
+		"grk_" + prefix + "_",
+		"grk_short_secret",
+		"grk_" + prefix + "00_secret",
+	} {
+		if _, ok := parseAPIKeyPrefix(key); ok {
+			t.Errorf("parseAPIKeyPrefix(%q) accepted a malformed key", key)
+		}
+	}
+}
+
+// mintAPIKey creates a key through the API with the caller's token.
+func (ts *testServer) mintAPIKey(token string, req CreateAPIKeyRequest) CreatedAPIKey {
+	ts.t.Helper()
+
+	resp := ts.do(http.MethodPost, "/me/api-keys", token, req)
+	return decodeResponse[CreatedAPIKey](ts.t, resp, http.StatusCreated)
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	ts := newTestServer(t)
+	editor := ts.createUser("editor", RoleEditor)
+	token := ts.login("editor").Token
+
+	// A role's permissions bound what a key may ask for.
+	expectStatus(t, ts.do(http.MethodPost, "/me/api-keys", token, CreateAPIKeyRequest{Name: "too wide", Scopes: []Permission{PermUsersWrite}}), http.StatusBadRequest)
+	expectStatus(t, ts.do(http.MethodPost, "/me/api-keys", token, CreateAPIKeyRequest{Name: "unknown", Scopes: []Permission{"posts:everything"}}), http.StatusBadRequest)
+
+	key := ts.mintAPIKey(token, CreateAPIKeyRequest{Name: "reader", Scopes: []Permission{PermUsersRead}}).Key
+	expectStatus(t, ts.do(http.MethodGet, "/user", key, nil), http.StatusOK)
+	// The editor may write posts, but the key was not granted it.
+	expectStatus(t, ts.do(http.MethodPost, "/post", key, Post{Title: "From a key"}), http.StatusForbidden)
+
+	// The owner's current role narrows the key further.
+	editor.Role = RoleMember
+	if err := ts.Users.Update(context.Background(), &editor); err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(t, ts.do(http.MethodGet, "/user", key, nil), http.StatusForbidden)
+}
+
+func TestAPIKeyExpiry(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser("alice", RoleMember)
+	token := ts.login("alice").Token
+
+	expectStatus(t, ts.do(http.MethodPost, "/me/api-keys", token, CreateAPIKeyRequest{Name: "past", Scopes: []Permission{PermPostsWrite}, ExpiresAt: new(time.Time)}), http.StatusBadRequest)
+	tooLate := time.Now().Add(ts.Config.APIKeyMaxTTL + time.Hour)
+	expectStatus(t, ts.do(http.MethodPost, "/me/api-keys", token, CreateAPIKeyRequest{Name: "too late", Scopes: []Permission{PermPostsWrite}, ExpiresAt: &tooLate}), http.StatusBadRequest)
+
+	created := ts.mintAPIKey(token, CreateAPIKeyRequest{Name: "ci", Scopes: []Permission{PermPostsWrite}})
+	expectStatus(t, ts.do(http.MethodGet, "/me", created.Key, nil), http.StatusOK)
+
+	store := ts.APIKeys.(*MemoryAPIKeyStore)
+	store.mu.Lock()
+	stored := store.keys[created.ID]
+	stored.ExpiresAt = time.Now().Add(-time.Second)
+	store.keys[created.ID] = stored
+	store.mu.Unlock()
+
+	expectStatus(t, ts.do(http.MethodGet, "/me", created.Key, nil), http.StatusUnauthorized)
+}
+
+func TestAPIKeyRevocation(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.createUser("alice", RoleMember)
+	ts.createUser("bob", RoleMember)
+	admin := ts.createUser("admin", RoleAdmin)
+	aliceToken, bobToken := ts.login("alice").Token, ts.login("bob").Token
+	adminToken := ts.accessToken(admin, true, time.Now())
+
+	first := ts.mintAPIKey(aliceToken, CreateAPIKeyRequest{Name: "first", Scopes: []Permission{PermPostsWrite}})
+	second := ts.mintAPIKey(aliceToken, CreateAPIKeyRequest{Name: "second", Scopes: []Permission{PermPostsWrite}})
+
+	// Other users' keys look missing.
+	expectStatus(t, ts.do(http.MethodDelete, fmt.Sprintf("/me/api-keys?id=%d", first.ID), bobToken, nil), http.StatusNotFound)
+	expectStatus(t, ts.do(http.MethodGet, "/me", first.Key, nil), http.StatusOK)
+
+	expectStatus(t, ts.do(http.MethodDelete, fmt.Sprintf("/me/api-keys?id=%d", first.ID), aliceToken, nil), http.StatusOK)
+	expectStatus(t, ts.do(http.MethodGet, "/me", first.Key, nil), http.StatusUnauthorized)
+
+	expectStatus(t, ts.do(http.MethodDelete, fmt.Sprintf("/user/api-keys?id=%d", second.ID), adminToken, nil), http.StatusOK)
+	expectStatus(t, ts.do(http.MethodGet, "/me", second.Key, nil), http.StatusUnauthorized)
+
+	keys := decodeResponse[[]APIKey](t, ts.do(http.MethodGet, fmt.Sprintf("/user/api-keys?userId=%d", alice.ID), adminToken, nil), http.StatusOK)
+	for _, key := range keys {
+		if key.RevokedAt == nil {
+			t.Errorf("key %d is not revoked", key.ID)
+		}
+	}
+}
+
+func TestRequireSessionRefusesAPIKeys(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser("alice", RoleMember)
+	token := ts.login("alice").Token
+	created := ts.mintAPIKey(token, CreateAPIKeyRequest{Name: "ci", Scopes: []Permission{PermPostsWrite}})
+
+	// A key can read the account but not manage it, so a leaked key cannot
+	// mint more keys, revoke itself or take the account over.
+	expectStatus(t, ts.do(http.MethodGet, "/me", created.Key, nil), http.StatusOK)
+	for _, req := range []struct{ method, path string }{
+		{http.MethodPost, "/me/api-keys"},
+		{http.MethodDelete, fmt.Sprintf("/me/api-keys?id=%d", created.ID)},
+		{http.MethodPost, "/user/password"},
+		{http.MethodPost, "/me/mfa/totp"},
+		{http.MethodPost, "/logout"},
+	} {
+		resp := ts.do(req.method, req.path, created.Key, `{}`)
+		if resp.StatusCode != http.StatusForbidden {
+			t.Errorf("%s %s with an API key: status %d, want %d", req.method, req.path, resp.StatusCode, http.StatusForbidden)
+		}
+		resp.Body.Close()
+	}
+
+	// X-API-Key is refused the same way as a bearer key.
+	req, err := http.NewRequest(http.MethodPost, ts.srv.URL+"/me/api-keys", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-API-Key", created.Key)
+	expectStatus(t, ts.send(req), http.StatusForbidden)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestLogin(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser("alice", RoleMember)
+
+	if err := ts.Users.SetEmailVerified(context.Background(), ts.createUser("unverified", RoleMember).ID, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.Users.SetActive(context.Background(), ts.createUser("inactive", RoleMember).ID, false, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		want     int
+	}{
+		{"valid", "alice", testPassword, http.StatusOK},
+		{"wrong password", "alice", "wrong password", http.StatusUnauthorized},
+		{"unknown user", "nobody", testPassword, http.StatusUnauthorized},
+		{"unverified email", "unverified", testPassword, http.StatusForbidden},
+		{"deactivated", "inactive", testPassword, http.StatusForbidden},
+		{"invalid body", "al", "", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := ts.do(http.MethodPost, "/login", "", map[string]string{"username": tt.username, "password": tt.password})
+			expectStatus(t, resp, tt.want)
+		})
+	}
+
+	tokens := ts.login("alice")
+	if tokens.Token == "" || tokens.RefreshToken == "" {
+		t.Fatalf("login returned %+v, want both tokens", tokens)
+	}
+	expectStatus(t, ts.do(http.MethodGet, "/me", tokens.Token, nil), http.StatusOK)
+}
+
+func TestAccessTokenAudienceAndIssuer(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.createUser("alice", RoleMember)
+	ts.enableTOTP(alice)
+
+	sign := func(issuer string, audience ...string) string {
+		t.Helper()
+		token, err := ts.Keys.Sign(UserClaims{
+			ID:       alice.ID,
+			Username: alice.Username,
+			Role:     alice.Role,
+			RegisteredClaims: jwt.RegisteredClaims{
+				ID:        "test-" + issuer + strings.Join(audience, ","),
+				Issuer:    issuer,
+				Subject:   strconv.Itoa(alice.ID),
+				Audience:  audience,
+				IssuedAt:  jwt.NewNumericDate(time.Now()),
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	expectStatus(t, ts.do(http.MethodGet, "/me", sign(ts.tokenIssuer(), accessTokenAudience), nil), http.StatusOK)
+	expectStatus(t, ts.do(http.MethodGet, "/me", sign(ts.tokenIssuer()), nil), http.StatusUnauthorized)
+	expectStatus(t, ts.do(http.MethodGet, "/me", sign(ts.tokenIssuer(), mfaChallengeAudience), nil), http.StatusUnauthorized)
+	expectStatus(t, ts.do(http.MethodGet, "/me", sign("https://other.example.com", accessTokenAudience), nil), http.StatusUnauthorized)
+
+	// A real MFA challenge is signed with the same key but is not an access
+	// token.
+	resp := ts.do(http.MethodPost, "/login", "", UserLogin{Username: "alice", Password: testPassword})
+	challenge := decodeResponse[MFAChallenge](t, resp, http.StatusOK)
+	expectStatus(t, ts.do(http.MethodGet, "/me", challenge.MFAToken, nil), http.StatusUnauthorized)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestUpdateComment(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.createUser("alice", RoleMember)
+	ts.createUser("bob", RoleMember)
+	aliceToken, bobToken := ts.login("alice").Token, ts.login("bob").Token
+
+	post := Post{Title: "A post", UserId: alice.ID}
+	if err := ts.Posts.Create(context.Background(), &post); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := ts.do(http.MethodPost, fmt.Sprintf("/post/%d/comments", post.ID), aliceToken, CreateCommentRequest{Body: "first"})
+	comment := decodeResponse[Comment](t, resp, http.StatusCreated)
+	path := fmt.Sprintf("/post/%d/comments/%d", post.ID, comment.ID)
+
+	edited := decodeResponse[Comment](t, ts.do(http.MethodPut, path, aliceToken, UpdateCommentRequest{Body: "edited"}), http.StatusOK)
+	if edited.Body != "edited" || edited.EditedAt == nil {
+		t.Errorf("edit returned %+v, want the new body and editedAt", edited)
+	}
+	expectStatus(t, ts.do(http.MethodPut, path, bobToken, UpdateCommentRequest{Body: "hijacked"}), http.StatusForbidden)
+
+	expectStatus(t, ts.do(http.MethodDelete, path, aliceToken, nil), http.StatusOK)
+	expectStatus(t, ts.do(http.MethodPut, path, aliceToken, UpdateCommentRequest{Body: "restored"}), http.StatusConflict)
+
+	// An edit that loaded the comment before it was deleted must not bring
+	// the body back.
+	stale := edited
+	stale.Body = "restored"
+	if err := ts.Comments.Update(context.Background(), &stale); !errors.Is(err, ErrNotFound) {
+		t.Errorf("updating a deleted comment: got %v, want ErrNotFound", err)
+	}
+	got, err := ts.Comments.Get(context.Background(), comment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Body != "" || got.DeletedAt == nil {
+		t.Errorf("deleted comment is %+v, want an empty body and deletedAt", got)
+	}
+}
+
+func TestDeletePostDeletesComments(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.createUser("alice", RoleMember)
+	token := ts.login("alice").Token
+
+	var posts [2]Post
+	for i := range posts {
+		posts[i] = Post{Title: fmt.Sprintf("Post %d", i), UserId: alice.ID}
+		if err := ts.Posts.Create(context.Background(), &posts[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var comments [2]Comment
+	for i, post := range posts {
+		resp := ts.do(http.MethodPost, fmt.Sprintf("/post/%d/comments", post.ID), token, CreateCommentRequest{Body: "a comment"})
+		comments[i] = decodeResponse[Comment](t, resp, http.StatusCreated)
+	}
+	resp := ts.do(http.MethodPost, fmt.Sprintf("/post/%d/comments", posts[0].ID), token, CreateCommentRequest{Body: "a reply", ParentID: &comments[0].ID})
+	reply := decodeResponse[Comment](t, resp, http.StatusCreated)
+
+	expectStatus(t, ts.do(http.MethodDelete, fmt.Sprintf("/post?id=%d", posts[0].ID), token, nil), http.StatusOK)
+
+	for _, id := range []int{comments[0].ID, reply.ID} {
+		if _, err := ts.Comments.Get(context.Background(), id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("comment %d on the deleted post: got %v, want ErrNotFound", id, err)
+		}
+	}
+	if _, err := ts.Comments.Get(context.Background(), comments[1].ID); err != nil {
+		t.Errorf("comment on the other post: %v", err)
+	}
+}
+
+func TestDeleteUserDeletesContent(t *testing.T) {
+	ts := newTestServer(t)
+	admin := ts.createUser("admin", RoleAdmin)
+	alice := ts.createUser("alice", RoleMember)
+	bob := ts.createUser("bob", RoleMember)
+	aliceToken, bobToken := ts.login("alice").Token, ts.login("bob").Token
+
+	alicePost, bobPost := Post{Title: "Alice's post", UserId: alice.ID}, Post{Title: "Bob's post", UserId: bob.ID}
+	for _, post := range []*Post{&alicePost, &bobPost} {
+		if err := ts.Posts.Create(context.Background(), post); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	comment := func(token string, postID int, parentID *int) Comment {
+		t.Helper()
+		resp := ts.do(http.MethodPost, fmt.Sprintf("/post/%d/comments", postID), token, CreateCommentRequest{Body: "a comment", ParentID: parentID})
+		return decodeResponse[Comment](t, resp, http.StatusCreated)
+	}
+	onAlicePost := comment(bobToken, alicePost.ID, nil)
+	byAlice := comment(aliceToken, bobPost.ID, nil)
+	reply := comment(bobToken, bobPost.ID, &byAlice.ID)
+	kept := comment(bobToken, bobPost.ID, nil)
+
+	expectStatus(t, ts.do(http.MethodDelete, fmt.Sprintf("/user?id=%d", alice.ID), ts.accessToken(admin, true, time.Now()), nil), http.StatusOK)
+
+	if _, err := ts.Posts.Get(context.Background(), alicePost.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("post by the deleted user: got %v, want ErrNotFound", err)
+	}
+	for _, id := range []int{onAlicePost.ID, byAlice.ID, reply.ID} {
+		if _, err := ts.Comments.Get(context.Background(), id); !errors.Is(err, ErrNotFound) {
+			t.Errorf("comment %d: got %v, want ErrNotFound", id, err)
+		}
+	}
+	if _, err := ts.Posts.Get(context.Background(), bobPost.ID); err != nil {
+		t.Errorf("post by another user: %v", err)
+	}
+	if _, err := ts.Comments.Get(context.Background(), kept.ID); err != nil {
+		t.Errorf("unrelated comment: %v", err)
+	}
+}
@@ -0,0 +1,740 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type Config struct {
+	Addr                       string        `json:"addr"`
+	PublicURL                  string        `json:"public_url"`
+	Store                      string        `json:"store"`
+	DatabaseURL                string        `json:"database_url"`
+	JWTSecret                  string        `json:"jwt_secret"`
+	JWTKeysDir                 string        `json:"jwt_keys_dir"`
+	JWTActiveKey               string        `json:"jwt_active_key"`
+	JWTRetiredKeys             []string      `json:"jwt_retired_keys"`
+	AccessTokenTTL             time.Duration `json:"access_token_ttl"`
+	RefreshTokenTTL            time.Duration `json:"refresh_token_ttl"`
+	PasswordResetTTL           time.Duration `json:"password_reset_ttl"`
+	PasswordResetURL           string        `json:"password_reset_url"`
+	EmailVerificationTTL       time.Duration `json:"email_verification_ttl"`
+	VerificationResendInterval time.Duration `json:"verification_resend_interval"`
+	MFAChallengeTTL            time.Duration `json:"mfa_challenge_ttl"`
+	MFAIssuer                  string        `json:"mfa_issuer"`
+	LoginMaxAttempts           int           `json:"login_max_attempts"`
+	LoginIPMaxAttempts         int           `json:"login_ip_max_attempts"`
+	LoginBackoffBase           time.Duration `json:"login_backoff_base"`
+	LoginLockoutDuration       time.Duration `json:"login_lockout_duration"`
+	TrustedProxies             []string      `json:"trusted_proxies"`
+	UserStatusCacheTTL         time.Duration `json:"user_status_cache_ttl"`
+	APIKeyMaxTTL               time.Duration `json:"api_key_max_ttl"`
+	CommentEditWindow          time.Duration `json:"comment_edit_window"`
+	SessionTTL                 time.Duration `json:"session_ttl"`
+	SessionCookieSecure        bool          `json:"session_cookie_secure"`
+	SessionCookieSameSite      string        `json:"session_cookie_same_site"`
+	SessionCookieDomain        string        `json:"session_cookie_domain"`
+	OIDCIssuer                 string        `json:"oidc_issuer"`
+	OIDCClientID               string        `json:"oidc_client_id"`
+	OIDCClientSecret           string        `json:"oidc_client_secret"`
+	OIDCRedirectURL            string        `json:"oidc_redirect_url"`
+	OIDCEnforcedDomains        []string      `json:"oidc_enforced_domains"`
+	PasswordHasher             string        `json:"password_hasher"`
+	Argon2Memory               int           `json:"argon2_memory"`
+	Argon2Iterations           int           `json:"argon2_iterations"`
+	Argon2Parallelism          int           `json:"argon2_parallelism"`
+	BcryptCost                 int           `json:"bcrypt_cost"`
+	PasswordMinLength          int           `json:"password_min_length"`
+	PasswordMaxLength          int           `json:"password_max_length"`
+	PasswordCharacterClasses   []string      `json:"password_character_classes"`
+	PasswordRejectSimilar      bool          `json:"password_reject_similar"`
+	PasswordBreachedFile       string        `json:"password_breached_file"`
+	ReadTimeout                time.Duration `json:"read_timeout"`
+	WriteTimeout               time.Duration `json:"write_timeout"`
+	// ShutdownDelay is how long the server keeps serving after reporting
+	// not-ready, giving load balancers time to stop routing to it.
+	ShutdownDelay   time.Duration `json:"shutdown_delay"`
+	ShutdownTimeout time.Duration `json:"shutdown_timeout"`
+	AutoMigrate     bool          `json:"auto_migrate"`
+	Mailer          string        `json:"mailer"`
+	MailFrom        string        `json:"mail_from"`
+	MailOutboxDir   string        `json:"mail_outbox_dir"`
+	SMTPAddr        string        `json:"smtp_addr"`
+	SMTPUsername    string        `json:"smtp_username"`
+	SMTPPassword    string        `json:"smtp_password"`
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		Addr:                       ":1414",
+		Store:                      "postgres",
+		AccessTokenTTL:             15 * time.Minute,
+		RefreshTokenTTL:            30 * 24 * time.Hour,
+		ReadTimeout:                5 * time.Second,
+		WriteTimeout:               10 * time.Second,
+		ShutdownTimeout:            15 * time.Second,
+		PublicURL:                  "http://localhost:1414",
+		PasswordResetTTL:           time.Hour,
+		Mailer:                     "log",
+		MailFrom:                   "no-reply@localhost",
+		EmailVerificationTTL:       24 * time.Hour,
+		VerificationResendInterval: time.Minute,
+		MFAChallengeTTL:            5 * time.Minute,
+		MFAIssuer:                  "go-rest",
+		LoginMaxAttempts:           10,
+		LoginIPMaxAttempts:         100,
+		LoginBackoffBase:           time.Second,
+		LoginLockoutDuration:       15 * time.Minute,
+		UserStatusCacheTTL:         30 * time.Second,
+		APIKeyMaxTTL:               365 * 24 * time.Hour,
+		CommentEditWindow:          15 * time.Minute,
+		SessionTTL:                 24 * time.Hour,
+		SessionCookieSecure:        true,
+		SessionCookieSameSite:      "lax",
+		PasswordHasher:             "argon2id",
+		Argon2Memory:               64 * 1024,
+		Argon2Iterations:           3,
+		Argon2Parallelism:          2,
+		BcryptCost:                 10,
+		PasswordMinLength:          12,
+		PasswordMaxLength:          72,
+		PasswordCharacterClasses:   []string{"lower", "upper", "digit"},
+		PasswordRejectSimilar:      true,
+	}
+}
+
+// LoadConfig builds the config from defaults, then the optional file at path,
+// then environment variables. Each key in the file maps to an env var of the
+// same name in upper case, e.g. database_url -> DATABASE_URL.
+func LoadConfig(path string) (*Config, error) {
+	cfg := DefaultConfig()
+
+	if path != "" {
+		values, err := readConfigFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range values {
+			if err := cfg.set(key, value); err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+		}
+	}
+
+	for _, key := range configKeys {
+		if value, ok := os.LookupEnv(strings.ToUpper(key)); ok {
+			if err := cfg.set(key, value); err != nil {
+				return nil, fmt.Errorf("env %s: %w", strings.ToUpper(key), err)
+			}
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+var configKeys = []string{
+	"addr",
+	"public_url",
+	"store",
+	"database_url",
+	"jwt_secret",
+	"jwt_keys_dir",
+	"jwt_active_key",
+	"jwt_retired_keys",
+	"access_token_ttl",
+	"refresh_token_ttl",
+	"password_reset_ttl",
+	"password_reset_url",
+	"email_verification_ttl",
+	"verification_resend_interval",
+	"mfa_challenge_ttl",
+	"mfa_issuer",
+	"login_max_attempts",
+	"login_ip_max_attempts",
+	"login_backoff_base",
+	"login_lockout_duration",
+	"trusted_proxies",
+	"user_status_cache_ttl",
+	"api_key_max_ttl",
+	"comment_edit_window",
+	"session_ttl",
+	"session_cookie_secure",
+	"session_cookie_same_site",
+	"session_cookie_domain",
+	"oidc_issuer",
+	"oidc_client_id",
+	"oidc_client_secret",
+	"oidc_redirect_url",
+	"oidc_enforced_domains",
+	"password_hasher",
+	"argon2_memory",
+	"argon2_iterations",
+	"argon2_parallelism",
+	"bcrypt_cost",
+	"password_min_length",
+	"password_max_length",
+	"password_character_classes",
+	"password_reject_similar",
+	"password_breached_file",
+	"read_timeout",
+	"write_timeout",
+	"shutdown_delay",
+	"shutdown_timeout",
+	"auto_migrate",
+	"mailer",
+	"mail_from",
+	"mail_outbox_dir",
+	"smtp_addr",
+	"smtp_username",
+	"smtp_password",
+}
+
+func (c *Config) set(key, value string) error {
+	var err error
+
+	switch key {
+	case "addr":
+		c.Addr = value
+	case "public_url":
+		c.PublicURL = value
+	case "store":
+		c.Store = value
+	case "database_url":
+		c.DatabaseURL = value
+	case "jwt_secret":
+		c.JWTSecret = value
+	case "jwt_keys_dir":
+		c.JWTKeysDir = value
+	case "jwt_active_key":
+		c.JWTActiveKey = value
+	case "jwt_retired_keys":
+		c.JWTRetiredKeys = splitList(value)
+	case "access_token_ttl":
+		c.AccessTokenTTL, err = parseDuration(value)
+	case "refresh_token_ttl":
+		c.RefreshTokenTTL, err = parseDuration(value)
+	case "password_reset_ttl":
+		c.PasswordResetTTL, err = parseDuration(value)
+	case "password_reset_url":
+		c.PasswordResetURL = value
+	case "email_verification_ttl":
+		c.EmailVerificationTTL, err = parseDuration(value)
+	case "verification_resend_interval":
+		c.VerificationResendInterval, err = parseDuration(value)
+	case "mfa_challenge_ttl":
+		c.MFAChallengeTTL, err = parseDuration(value)
+	case "mfa_issuer":
+		c.MFAIssuer = value
+	case "login_max_attempts":
+		c.LoginMaxAttempts, err = strconv.Atoi(value)
+	case "login_ip_max_attempts":
+		c.LoginIPMaxAttempts, err = strconv.Atoi(value)
+	case "login_backoff_base":
+		c.LoginBackoffBase, err = parseDuration(value)
+	case "login_lockout_duration":
+		c.LoginLockoutDuration, err = parseDuration(value)
+	case "trusted_proxies":
+		c.TrustedProxies = splitList(value)
+	case "user_status_cache_ttl":
+		c.UserStatusCacheTTL, err = parseDuration(value)
+	case "api_key_max_ttl":
+		c.APIKeyMaxTTL, err = parseDuration(value)
+	case "comment_edit_window":
+		c.CommentEditWindow, err = parseDuration(value)
+	case "session_ttl":
+		c.SessionTTL, err = parseDuration(value)
+	case "session_cookie_secure":
+		c.SessionCookieSecure, err = strconv.ParseBool(value)
+	case "session_cookie_same_site":
+		c.SessionCookieSameSite = value
+	case "session_cookie_domain":
+		c.SessionCookieDomain = value
+	case "oidc_issuer":
+		c.OIDCIssuer = value
+	case "oidc_client_id":
+		c.OIDCClientID = value
+	case "oidc_client_secret":
+		c.OIDCClientSecret = value
+	case "oidc_redirect_url":
+		c.OIDCRedirectURL = value
+	case "oidc_enforced_domains":
+		c.OIDCEnforcedDomains = splitList(value)
+	case "password_hasher":
+		c.PasswordHasher = value
+	case "argon2_memory":
+		c.Argon2Memory, err = strconv.Atoi(value)
+	case "argon2_iterations":
+		c.Argon2Iterations, err = strconv.Atoi(value)
+	case "argon2_parallelism":
+		c.Argon2Parallelism, err = strconv.Atoi(value)
+	case "bcrypt_cost":
+		c.BcryptCost, err = strconv.Atoi(value)
+	case "password_min_length":
+		c.PasswordMinLength, err = strconv.Atoi(value)
+	case "password_max_length":
+		c.PasswordMaxLength, err = strconv.Atoi(value)
+	case "password_character_classes":
+		c.PasswordCharacterClasses = splitList(value)
+	case "password_reject_similar":
+		c.PasswordRejectSimilar, err = strconv.ParseBool(value)
+	case "password_breached_file":
+		c.PasswordBreachedFile = value
+	case "read_timeout":
+		c.ReadTimeout, err = parseDuration(value)
+	case "write_timeout":
+		c.WriteTimeout, err = parseDuration(value)
+	case "shutdown_delay":
+		c.ShutdownDelay, err = parseDuration(value)
+	case "shutdown_timeout":
+		c.ShutdownTimeout, err = parseDuration(value)
+	case "auto_migrate":
+		c.AutoMigrate, err = strconv.ParseBool(value)
+	case "mailer":
+		c.Mailer = value
+	case "mail_from":
+		c.MailFrom = value
+	case "mail_outbox_dir":
+		c.MailOutboxDir = value
+	case "smtp_addr":
+		c.SMTPAddr = value
+	case "smtp_username":
+		c.SMTPUsername = value
+	case "smtp_password":
+		c.SMTPPassword = value
+	default:
+		return fmt.Errorf("unknown config key %q", key)
+	}
+
+	if err != nil {
+		return fmt.Errorf("invalid value for %s: %w", key, err)
+	}
+	return nil
+}
+
+func (c *Config) Validate() error {
+	var errs []string
+
+	if c.Addr == "" {
+		errs = append(errs, "addr is required")
+	}
+	switch c.Store {
+	case "postgres":
+		if c.DatabaseURL == "" {
+			errs = append(errs, "database_url is required")
+		} else if _, err := url.Parse(c.DatabaseURL); err != nil {
+			errs = append(errs, "database_url is not a valid URL")
+		}
+	case "memory":
+	default:
+		errs = append(errs, `store must be "postgres" or "memory"`)
+	}
+	if c.JWTKeysDir == "" && c.JWTSecret == "" {
+		errs = append(errs, "jwt_secret is required when jwt_keys_dir is not set")
+	}
+	if c.JWTKeysDir != "" && c.JWTActiveKey == "" {
+		errs = append(errs, "jwt_active_key is required when jwt_keys_dir is set")
+	}
+	if slices.Contains(c.JWTRetiredKeys, c.JWTActiveKey) && c.JWTActiveKey != "" {
+		errs = append(errs, "jwt_active_key must not be retired")
+	}
+	if c.AccessTokenTTL <= 0 {
+		errs = append(errs, "access_token_ttl must be positive")
+	}
+	if c.RefreshTokenTTL <= c.AccessTokenTTL {
+		errs = append(errs, "refresh_token_ttl must be longer than access_token_ttl")
+	}
+	if c.ReadTimeout <= 0 {
+		errs = append(errs, "read_timeout must be positive")
+	}
+	if c.WriteTimeout <= 0 {
+		errs = append(errs, "write_timeout must be positive")
+	}
+	if c.PasswordResetTTL <= 0 {
+		errs = append(errs, "password_reset_ttl must be positive")
+	}
+	if c.EmailVerificationTTL <= 0 {
+		errs = append(errs, "email_verification_ttl must be positive")
+	}
+	if c.VerificationResendInterval < 0 {
+		errs = append(errs, "verification_resend_interval must not be negative")
+	}
+	if c.MFAChallengeTTL <= 0 {
+		errs = append(errs, "mfa_challenge_ttl must be positive")
+	}
+	if c.MFAIssuer == "" {
+		errs = append(errs, "mfa_issuer is required")
+	}
+	if c.LoginMaxAttempts <= loginFreeAttempts {
+		errs = append(errs, fmt.Sprintf("login_max_attempts must be greater than %d", loginFreeAttempts))
+	}
+	if c.LoginIPMaxAttempts < c.LoginMaxAttempts {
+		errs = append(errs, "login_ip_max_attempts must be at least login_max_attempts")
+	}
+	if c.LoginBackoffBase <= 0 {
+		errs = append(errs, "login_backoff_base must be positive")
+	}
+	if c.LoginLockoutDuration < c.LoginBackoffBase {
+		errs = append(errs, "login_lockout_duration must be at least login_backoff_base")
+	}
+	if _, err := parseTrustedProxies(c.TrustedProxies); err != nil {
+		errs = append(errs, "trusted_proxies: "+err.Error())
+	}
+	if c.UserStatusCacheTTL < 0 {
+		errs = append(errs, "user_status_cache_ttl must not be negative")
+	}
+	if c.APIKeyMaxTTL <= 0 {
+		errs = append(errs, "api_key_max_ttl must be positive")
+	}
+	if c.CommentEditWindow < 0 {
+		errs = append(errs, "comment_edit_window must not be negative")
+	}
+	if c.SessionTTL <= 0 {
+		errs = append(errs, "session_ttl must be positive")
+	}
+	switch c.SessionCookieSameSite {
+	case "lax", "strict":
+	case "none":
+		if !c.SessionCookieSecure {
+			errs = append(errs, "session_cookie_secure is required when session_cookie_same_site is none")
+		}
+	default:
+		errs = append(errs, `session_cookie_same_site must be "lax", "strict" or "none"`)
+	}
+	if c.OIDCIssuer != "" {
+		if u, err := url.Parse(c.OIDCIssuer); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, "oidc_issuer is not a valid URL")
+		}
+		if c.OIDCClientID == "" {
+			errs = append(errs, "oidc_client_id is required when oidc_issuer is set")
+		}
+	} else if len(c.OIDCEnforcedDomains) > 0 {
+		errs = append(errs, "oidc_enforced_domains requires oidc_issuer")
+	}
+	if c.PasswordHasher != "argon2id" && c.PasswordHasher != "bcrypt" {
+		errs = append(errs, fmt.Sprintf("password_hasher must be argon2id or bcrypt, got %q", c.PasswordHasher))
+	}
+	if c.Argon2Iterations < 1 {
+		errs = append(errs, "argon2_iterations must be at least 1")
+	}
+	if c.Argon2Parallelism < 1 || c.Argon2Parallelism > 255 {
+		errs = append(errs, "argon2_parallelism must be between 1 and 255")
+	}
+	if c.Argon2Memory < 8*c.Argon2Parallelism || c.Argon2Memory > math.MaxUint32 {
+		errs = append(errs, "argon2_memory must be at least 8 KiB per unit of argon2_parallelism")
+	}
+	if c.BcryptCost < bcrypt.MinCost || c.BcryptCost > bcrypt.MaxCost {
+		errs = append(errs, fmt.Sprintf("bcrypt_cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost))
+	}
+	if c.PasswordMinLength < 1 {
+		errs = append(errs, "password_min_length must be at least 1")
+	}
+	if c.PasswordMaxLength < c.PasswordMinLength {
+		errs = append(errs, "password_max_length must be at least password_min_length")
+	}
+	if c.PasswordHasher == "bcrypt" && c.PasswordMaxLength > 72 {
+		errs = append(errs, "password_max_length must be at most 72 with password_hasher bcrypt")
+	}
+	for _, class := range c.PasswordCharacterClasses {
+		if _, ok := passwordClasses[class]; !ok {
+			errs = append(errs, fmt.Sprintf("password_character_classes: unknown class %q, use lower, upper, digit or symbol", class))
+		}
+	}
+	if _, err := url.Parse(c.PublicURL); err != nil || c.PublicURL == "" {
+		errs = append(errs, "public_url must be a valid URL")
+	}
+	if u, err := url.Parse(c.PasswordResetURL); c.PasswordResetURL != "" && (err != nil || !u.IsAbs()) {
+		errs = append(errs, "password_reset_url must be an absolute URL")
+	}
+	switch c.Mailer {
+	case "log":
+	case "file":
+		if c.MailOutboxDir == "" {
+			errs = append(errs, "mail_outbox_dir is required for the file mailer")
+		}
+	case "smtp":
+		if c.SMTPAddr == "" {
+			errs = append(errs, "smtp_addr is required for the smtp mailer")
+		}
+	default:
+		errs = append(errs, `mailer must be "log", "file" or "smtp"`)
+	}
+	if c.ShutdownDelay < 0 {
+		errs = append(errs, "shutdown_delay must not be negative")
+	}
+	if c.ShutdownTimeout <= 0 {
+		errs = append(errs, "shutdown_timeout must be positive")
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// String renders the config with secrets redacted so it is safe to log.
+func (c *Config) String() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "addr: %s\n", c.Addr)
+	fmt.Fprintf(&b, "public_url: %s\n", c.PublicURL)
+	fmt.Fprintf(&b, "store: %s\n", c.Store)
+	fmt.Fprintf(&b, "database_url: %s\n", redactURL(c.DatabaseURL))
+	fmt.Fprintf(&b, "jwt_secret: %s\n", redact(c.JWTSecret))
+	fmt.Fprintf(&b, "jwt_keys_dir: %s\n", c.JWTKeysDir)
+	fmt.Fprintf(&b, "jwt_active_key: %s\n", c.JWTActiveKey)
+	fmt.Fprintf(&b, "jwt_retired_keys: %s\n", strings.Join(c.JWTRetiredKeys, ","))
+	fmt.Fprintf(&b, "access_token_ttl: %s\n", c.AccessTokenTTL)
+	fmt.Fprintf(&b, "refresh_token_ttl: %s\n", c.RefreshTokenTTL)
+	fmt.Fprintf(&b, "password_reset_ttl: %s\n", c.PasswordResetTTL)
+	fmt.Fprintf(&b, "password_reset_url: %s\n", c.PasswordResetURL)
+	fmt.Fprintf(&b, "email_verification_ttl: %s\n", c.EmailVerificationTTL)
+	fmt.Fprintf(&b, "verification_resend_interval: %s\n", c.VerificationResendInterval)
+	fmt.Fprintf(&b, "mfa_challenge_ttl: %s\n", c.MFAChallengeTTL)
+	fmt.Fprintf(&b, "mfa_issuer: %s\n", c.MFAIssuer)
+	fmt.Fprintf(&b, "login_max_attempts: %d\n", c.LoginMaxAttempts)
+	fmt.Fprintf(&b, "login_ip_max_attempts: %d\n", c.LoginIPMaxAttempts)
+	fmt.Fprintf(&b, "login_backoff_base: %s\n", c.LoginBackoffBase)
+	fmt.Fprintf(&b, "login_lockout_duration: %s\n", c.LoginLockoutDuration)
+	fmt.Fprintf(&b, "trusted_proxies: %s\n", strings.Join(c.TrustedProxies, ","))
+	fmt.Fprintf(&b, "user_status_cache_ttl: %s\n", c.UserStatusCacheTTL)
+	fmt.Fprintf(&b, "api_key_max_ttl: %s\n", c.APIKeyMaxTTL)
+	fmt.Fprintf(&b, "comment_edit_window: %s\n", c.CommentEditWindow)
+	fmt.Fprintf(&b, "session_ttl: %s\n", c.SessionTTL)
+	fmt.Fprintf(&b, "session_cookie_secure: %t\n", c.SessionCookieSecure)
+	fmt.Fprintf(&b, "session_cookie_same_site: %s\n", c.SessionCookieSameSite)
+	fmt.Fprintf(&b, "session_cookie_domain: %s\n", c.SessionCookieDomain)
+	fmt.Fprintf(&b, "oidc_issuer: %s\n", c.OIDCIssuer)
+	fmt.Fprintf(&b, "oidc_client_id: %s\n", c.OIDCClientID)
+	fmt.Fprintf(&b, "oidc_client_secret: %s\n", redact(c.OIDCClientSecret))
+	fmt.Fprintf(&b, "oidc_redirect_url: %s\n", c.OIDCRedirectURL)
+	fmt.Fprintf(&b, "oidc_enforced_domains: %s\n", strings.Join(c.OIDCEnforcedDomains, ","))
+	fmt.Fprintf(&b, "password_hasher: %s\n", c.PasswordHasher)
+	fmt.Fprintf(&b, "argon2_memory: %d\n", c.Argon2Memory)
+	fmt.Fprintf(&b, "argon2_iterations: %d\n", c.Argon2Iterations)
+	fmt.Fprintf(&b, "argon2_parallelism: %d\n", c.Argon2Parallelism)
+	fmt.Fprintf(&b, "bcrypt_cost: %d\n", c.BcryptCost)
+	fmt.Fprintf(&b, "password_min_length: %d\n", c.PasswordMinLength)
+	fmt.Fprintf(&b, "password_max_length: %d\n", c.PasswordMaxLength)
+	fmt.Fprintf(&b, "password_character_classes: %s\n", strings.Join(c.PasswordCharacterClasses, ","))
+	fmt.Fprintf(&b, "password_reject_similar: %t\n", c.PasswordRejectSimilar)
+	fmt.Fprintf(&b, "password_breached_file: %s\n", c.PasswordBreachedFile)
+	fmt.Fprintf(&b, "read_timeout: %s\n", c.ReadTimeout)
+	fmt.Fprintf(&b, "write_timeout: %s\n", c.WriteTimeout)
+	fmt.Fprintf(&b, "shutdown_delay: %s\n", c.ShutdownDelay)
+	fmt.Fprintf(&b, "shutdown_timeout: %s\n", c.ShutdownTimeout)
+	fmt.Fprintf(&b, "auto_migrate: %t\n", c.AutoMigrate)
+	fmt.Fprintf(&b, "mailer: %s\n", c.Mailer)
+	fmt.Fprintf(&b, "mail_from: %s\n", c.MailFrom)
+	fmt.Fprintf(&b, "mail_outbox_dir: %s\n", c.MailOutboxDir)
+	fmt.Fprintf(&b, "smtp_addr: %s\n", c.SMTPAddr)
+	fmt.Fprintf(&b, "smtp_username: %s\n", c.SMTPUsername)
+	fmt.Fprintf(&b, "smtp_password: %s\n", redact(c.SMTPPassword))
+
+	return b.String()
+}
+
+func redact(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return "[REDACTED]"
+}
+
+// dsnPasswordPattern finds the password in a key=value connection string.
+// Values may be single-quoted with backslash escapes.
+var dsnPasswordPattern = regexp.MustCompile(`(?i)(\bpassword\s*=\s*)('(?:\\.|[^'\\])*'|\S*)`)
+
+// redactURL hides the password of a database URL, whether it is in the
+// userinfo or the query, or of a key=value connection string.
+func redactURL(raw string) string {
+	if !strings.Contains(raw, "://") {
+		return dsnPasswordPattern.ReplaceAllString(raw, "${1}xxxxx")
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return redact(raw)
+	}
+	if query := u.Query(); query.Has("password") {
+		query.Set("password", "xxxxx")
+		u.RawQuery = query.Encode()
+	}
+	return u.Redacted()
+}
+
+func splitList(value string) []string {
+	value = strings.Trim(value, "[]")
+
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.Trim(strings.TrimSpace(item), `"'`)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func parseDuration(value string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// readConfigFile reads a JSON file, or a flat YAML/TOML file made of
+// "key: value" or "key = value" lines. In YAML a key with no value may be
+// followed by "- item" lines. Sections and nested values are rejected
+// rather than misread.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read config file: %w", err)
+	}
+
+	values := make(map[string]string)
+
+	if filepath.Ext(path) == ".json" {
+		// Numbers are kept as written; as float64 large ones would come
+		// out in exponent form.
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+
+		var raw map[string]any
+		if err := decoder.Decode(&raw); err != nil {
+			return nil, fmt.Errorf("unable to parse config file %s: %w", path, err)
+		}
+		for key, value := range raw {
+			if list, ok := value.([]any); ok {
+				items := make([]string, len(list))
+				for i, item := range list {
+					items[i] = fmt.Sprint(item)
+				}
+				values[key] = strings.Join(items, ",")
+				continue
+			}
+			values[key] = fmt.Sprint(value)
+		}
+		return values, nil
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	lineNo := 0
+	// listKey is the key whose "- item" lines are being read.
+	listKey := ""
+	var list []string
+	endList := func() {
+		if listKey != "" {
+			values[listKey] = strings.Join(list, ",")
+			listKey, list = "", nil
+		}
+	}
+
+	for scanner.Scan() {
+		lineNo++
+		text := scanner.Text()
+		line := strings.TrimSpace(text)
+		if line == "" || strings.HasPrefix(line, "#") || line == "---" {
+			continue
+		}
+
+		if item, ok := strings.CutPrefix(line, "-"); ok && (item == "" || item[0] == ' ' || item[0] == '\t') {
+			if listKey == "" {
+				return nil, fmt.Errorf("%s:%d: list item without a key", path, lineNo)
+			}
+			value, err := configValue(item)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, lineNo, err)
+			}
+			list = append(list, value)
+			continue
+		}
+		endList()
+
+		if strings.HasPrefix(line, "[") {
+			return nil, fmt.Errorf("%s:%d: sections are not supported, put every key at the top level", path, lineNo)
+		}
+		if text[0] == ' ' || text[0] == '\t' {
+			return nil, fmt.Errorf("%s:%d: nested values are not supported", path, lineNo)
+		}
+
+		idx := strings.IndexAny(line, ":=")
+		if idx < 0 {
+			return nil, fmt.Errorf("%s:%d: expected key: value", path, lineNo)
+		}
+
+		key := strings.TrimSpace(line[:idx])
+		value, err := configValue(line[idx+1:])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, lineNo, err)
+		}
+		if value == "" && line[idx] == ':' {
+			listKey = key
+		}
+
+		values[key] = value
+	}
+	endList()
+
+	return values, scanner.Err()
+}
+
+var configCommentPattern = regexp.MustCompile(`\s#`)
+
+// configValue parses a YAML/TOML value: a quoted string, an inline [list],
+// or bare text. A # comment may follow.
+func configValue(text string) (string, error) {
+	text = strings.TrimSpace(text)
+
+	var value, rest string
+	switch {
+	case strings.HasPrefix(text, `"`):
+		quoted, err := strconv.QuotedPrefix(text)
+		if err != nil {
+			return "", fmt.Errorf("unterminated string %s", text)
+		}
+		value, _ = strconv.Unquote(quoted)
+		rest = text[len(quoted):]
+	case strings.HasPrefix(text, "'"):
+		end := strings.Index(text[1:], "'")
+		if end < 0 {
+			return "", fmt.Errorf("unterminated string %s", text)
+		}
+		value, rest = text[1:end+1], text[end+2:]
+	case strings.HasPrefix(text, "["):
+		end := strings.Index(text, "]")
+		if end < 0 {
+			return "", fmt.Errorf("unterminated list %s", text)
+		}
+		value, rest = text[:end+1], text[end+1:]
+	default:
+		// A # only starts a comment after whitespace, so values like
+		// URL fragments keep theirs.
+		value = text
+		if loc := configCommentPattern.FindStringIndex(text); loc != nil {
+			value = strings.TrimSpace(text[:loc[0]])
+		}
+	}
+
+	if rest = strings.TrimSpace(rest); rest != "" && !strings.HasPrefix(rest, "#") {
+		return "", fmt.Errorf("unexpected %q after value", rest)
+	}
+	return value, nil
+}
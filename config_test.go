@@ -0,0 +1,119 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRedactURL(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"", ""},
+		{"postgres://app:s3cret@db:5432/app", "postgres://app:xxxxx@db:5432/app"},
+		{"postgres://app@db/app?sslmode=disable&password=s3cret", "postgres://app@db/app?password=xxxxx&sslmode=disable"},
+		{"host=db password=s3cret dbname=app", "host=db password=xxxxx dbname=app"},
+		{"host=db PASSWORD = s3cret", "host=db PASSWORD = xxxxx"},
+		{`host=db password='s3 cr\'et' dbname=app`, "host=db password=xxxxx dbname=app"},
+		{"host=db user=app", "host=db user=app"},
+	}
+	for _, tt := range tests {
+		if got := redactURL(tt.raw); got != tt.want {
+			t.Errorf("redactURL(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestLoadConfigJSONNumbers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	data := `{"database_url": "postgres://db/app", "jwt_secret": "secret", "argon2_memory": 1048576, "login_max_attempts": 12, "access_token_ttl": 900, "session_cookie_secure": false, "trusted_proxies": ["10.0.0.0/8", "192.0.2.1"]}`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Argon2Memory != 1048576 || cfg.LoginMaxAttempts != 12 || cfg.AccessTokenTTL != 15*time.Minute || cfg.SessionCookieSecure {
+		t.Errorf("loaded %+v, want the values from the file", cfg)
+	}
+	if want := []string{"10.0.0.0/8", "192.0.2.1"}; !slices.Equal(cfg.TrustedProxies, want) {
+		t.Errorf("trusted_proxies = %q, want %q", cfg.TrustedProxies, want)
+	}
+}
+
+func TestReadConfigFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		content string
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			name:    "yaml",
+			file:    "config.yaml",
+			content: "---\n# comment\naddr: \":8080\"\nmailer: smtp # inline comment\nsmtp_password: 'pa#ss'\npublic_url: https://example.com/#top\n",
+			want:    map[string]string{"addr": ":8080", "mailer": "smtp", "smtp_password": "pa#ss", "public_url": "https://example.com/#top"},
+		},
+		{
+			name:    "yaml list",
+			file:    "config.yaml",
+			content: "trusted_proxies:\n  - 10.0.0.0/8 # load balancers\n  - \"192.0.2.1\"\nstore: memory\n",
+			want:    map[string]string{"trusted_proxies": "10.0.0.0/8,192.0.2.1", "store": "memory"},
+		},
+		{
+			name:    "toml",
+			file:    "config.toml",
+			content: "addr = \":8080\" # comment\ntrusted_proxies = [\"10.0.0.0/8\", \"192.0.2.1\"] # comment\nauto_migrate = true\n",
+			want:    map[string]string{"addr": ":8080", "trusted_proxies": `["10.0.0.0/8", "192.0.2.1"]`, "auto_migrate": "true"},
+		},
+		{name: "toml section", file: "config.toml", content: "addr = \":8080\"\n[mail]\nmailer = \"smtp\"\n", wantErr: "config.toml:2: sections are not supported"},
+		{name: "nested value", file: "config.yaml", content: "mail:\n  mailer: smtp\n", wantErr: "config.yaml:2: nested values are not supported"},
+		{name: "list item without key", file: "config.yaml", content: "addr: :8080\n- item\n", wantErr: "config.yaml:2: list item without a key"},
+		{name: "text after string", file: "config.toml", content: "addr = \":8080\" extra\n", wantErr: `config.toml:1: unexpected "extra" after value`},
+		{name: "unterminated string", file: "config.toml", content: "addr = \":8080\n", wantErr: "config.toml:1: unterminated string"},
+		{name: "no separator", file: "config.yaml", content: "addr\n", wantErr: "config.yaml:1: expected key: value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.file)
+			if err := os.WriteFile(path, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := readConfigFile(path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("readConfigFile() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("readConfigFile() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadmeDocumentsConfigKeys(t *testing.T) {
+	readme, err := os.ReadFile("Readme.md")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range configKeys {
+		if row := "| " + key + " | " + strings.ToUpper(key) + " |"; !strings.Contains(string(readme), row) {
+			t.Errorf("Readme.md has no row for %s", key)
+		}
+	}
+}
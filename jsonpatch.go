@@ -0,0 +1,288 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	mergePatchContentType = "application/merge-patch+json"
+	jsonPatchContentType  = "application/json-patch+json"
+)
+
+// applyMergePatch applies an RFC 7386 JSON Merge Patch to doc.
+func applyMergePatch(doc, patch any) any {
+	patchObj, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+
+	docObj, ok := doc.(map[string]any)
+	if !ok {
+		docObj = make(map[string]any)
+	}
+
+	for key, value := range patchObj {
+		if value == nil {
+			delete(docObj, key)
+			continue
+		}
+		docObj[key] = applyMergePatch(docObj[key], value)
+	}
+
+	return docObj
+}
+
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from"`
+	Value json.RawMessage `json:"value"`
+}
+
+// applyJSONPatch applies an RFC 6902 JSON Patch to doc. The operations are
+// applied in order and the first failure aborts the whole patch.
+func applyJSONPatch(doc any, ops []PatchOperation) (any, error) {
+	var err error
+
+	for i, op := range ops {
+		switch op.Op {
+		case "add", "replace", "test":
+			if op.Value == nil {
+				return nil, fmt.Errorf("operation %d: value is required", i)
+			}
+			var value any
+			if err := json.Unmarshal(op.Value, &value); err != nil {
+				return nil, fmt.Errorf("operation %d: %w", i, err)
+			}
+
+			switch op.Op {
+			case "add":
+				doc, err = pointerAdd(doc, op.Path, value)
+			case "replace":
+				_, err = pointerGet(doc, op.Path)
+				switch {
+				case err != nil:
+				case op.Path == "":
+					// pointerRemove refuses the root, but replacing it
+					// is allowed.
+					doc = value
+				default:
+					if doc, err = pointerRemove(doc, op.Path); err == nil {
+						doc, err = pointerAdd(doc, op.Path, value)
+					}
+				}
+			case "test":
+				var current any
+				if current, err = pointerGet(doc, op.Path); err == nil && !reflect.DeepEqual(current, value) {
+					err = fmt.Errorf("test failed at %q", op.Path)
+				}
+			}
+		case "remove":
+			doc, err = pointerRemove(doc, op.Path)
+		case "move", "copy":
+			var value any
+			if value, err = pointerGet(doc, op.From); err != nil {
+				break
+			}
+			if op.Op == "move" {
+				if strings.HasPrefix(op.Path, op.From+"/") {
+					err = fmt.Errorf("cannot move %q into itself", op.From)
+					break
+				}
+				if doc, err = pointerRemove(doc, op.From); err != nil {
+					break
+				}
+			}
+			doc, err = pointerAdd(doc, op.Path, deepCopy(value))
+		default:
+			err = fmt.Errorf("unknown op %q", op.Op)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+	}
+
+	return doc, nil
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into unescaped tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+func arrayIndex(token string, length int, allowEnd bool) (int, error) {
+	if allowEnd && token == "-" {
+		return length, nil
+	}
+
+	idx, err := strconv.Atoi(token)
+	if err != nil || idx < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+
+	last := length - 1
+	if allowEnd {
+		last = length
+	}
+	if idx > last {
+		return 0, fmt.Errorf("array index %d out of range", idx)
+	}
+	return idx, nil
+}
+
+func pointerGet(doc any, pointer string) (any, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+
+	current := doc
+	for _, token := range tokens {
+		switch node := current.(type) {
+		case map[string]any:
+			value, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("path %q does not exist", pointer)
+			}
+			current = value
+		case []any:
+			idx, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			current = node[idx]
+		default:
+			return nil, fmt.Errorf("path %q does not exist", pointer)
+		}
+	}
+
+	return current, nil
+}
+
+// pointerAdd and pointerRemove return the updated document because changing
+// an array's length, or the root, replaces the value rather than mutating it.
+func pointerAdd(doc any, pointer string, value any) (any, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return value, nil
+	}
+	return updateAt(doc, tokens, pointer, func(parent any, token string) (any, error) {
+		switch node := parent.(type) {
+		case map[string]any:
+			node[token] = value
+			return node, nil
+		case []any:
+			idx, err := arrayIndex(token, len(node), true)
+			if err != nil {
+				return nil, err
+			}
+			node = append(node, nil)
+			copy(node[idx+1:], node[idx:])
+			node[idx] = value
+			return node, nil
+		default:
+			return nil, fmt.Errorf("path %q does not exist", pointer)
+		}
+	})
+}
+
+func pointerRemove(doc any, pointer string) (any, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("cannot remove the whole document")
+	}
+	return updateAt(doc, tokens, pointer, func(parent any, token string) (any, error) {
+		switch node := parent.(type) {
+		case map[string]any:
+			if _, ok := node[token]; !ok {
+				return nil, fmt.Errorf("path %q does not exist", pointer)
+			}
+			delete(node, token)
+			return node, nil
+		case []any:
+			idx, err := arrayIndex(token, len(node), false)
+			if err != nil {
+				return nil, err
+			}
+			return append(node[:idx], node[idx+1:]...), nil
+		default:
+			return nil, fmt.Errorf("path %q does not exist", pointer)
+		}
+	})
+}
+
+// updateAt walks to the parent of the last token, applies fn to it and
+// writes the result back up the tree.
+func updateAt(node any, tokens []string, pointer string, fn func(parent any, token string) (any, error)) (any, error) {
+	if len(tokens) == 1 {
+		return fn(node, tokens[0])
+	}
+
+	switch parent := node.(type) {
+	case map[string]any:
+		child, ok := parent[tokens[0]]
+		if !ok {
+			return nil, fmt.Errorf("path %q does not exist", pointer)
+		}
+		updated, err := updateAt(child, tokens[1:], pointer, fn)
+		if err != nil {
+			return nil, err
+		}
+		parent[tokens[0]] = updated
+		return parent, nil
+	case []any:
+		idx, err := arrayIndex(tokens[0], len(parent), false)
+		if err != nil {
+			return nil, err
+		}
+		updated, err := updateAt(parent[idx], tokens[1:], pointer, fn)
+		if err != nil {
+			return nil, err
+		}
+		parent[idx] = updated
+		return parent, nil
+	default:
+		return nil, fmt.Errorf("path %q does not exist", pointer)
+	}
+}
+
+func deepCopy(value any) any {
+	switch v := value.(type) {
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for key, item := range v {
+			out[key] = deepCopy(item)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, item := range v {
+			out[i] = deepCopy(item)
+		}
+		return out
+	default:
+		return v
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type SigningKey struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private crypto.PrivateKey
+	Public  crypto.PublicKey
+}
+
+// KeyManager signs tokens with the active key and verifies them against any
+// loaded key that has not been retired. Keys are PEM files named <kid>.pem;
+// without a key directory it falls back to HS256 with the shared secret.
+type KeyManager struct {
+	active *SigningKey
+	keys   map[string]*SigningKey
+}
+
+func LoadKeyManager(cfg *Config) (*KeyManager, error) {
+	if cfg.JWTKeysDir == "" {
+		return NewHMACKeyManager(cfg.JWTSecret), nil
+	}
+
+	paths, err := filepath.Glob(filepath.Join(cfg.JWTKeysDir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	km := &KeyManager{keys: make(map[string]*SigningKey)}
+	for _, path := range paths {
+		kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+		if slices.Contains(cfg.JWTRetiredKeys, kid) {
+			continue
+		}
+
+		key, err := loadSigningKey(path, kid)
+		if err != nil {
+			return nil, err
+		}
+		km.keys[kid] = key
+	}
+
+	active, ok := km.keys[cfg.JWTActiveKey]
+	if !ok {
+		return nil, fmt.Errorf("active signing key %q not found in %s", cfg.JWTActiveKey, cfg.JWTKeysDir)
+	}
+	km.active = active
+
+	return km, nil
+}
+
+func NewHMACKeyManager(secret string) *KeyManager {
+	key := &SigningKey{Method: jwt.SigningMethodHS256, Private: []byte(secret), Public: []byte(secret)}
+	return &KeyManager{active: key, keys: map[string]*SigningKey{"": key}}
+}
+
+func loadSigningKey(path, kid string) (*SigningKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+
+	var parsed any
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s: unsupported PEM block %q", path, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	switch key := parsed.(type) {
+	case *rsa.PrivateKey:
+		return &SigningKey{ID: kid, Method: jwt.SigningMethodRS256, Private: key, Public: &key.PublicKey}, nil
+	case ed25519.PrivateKey:
+		return &SigningKey{ID: kid, Method: jwt.SigningMethodEdDSA, Private: key, Public: key.Public()}, nil
+	default:
+		return nil, fmt.Errorf("%s: unsupported key type %T", path, parsed)
+	}
+}
+
+// Sign signs claims with the active key and stamps its kid in the header.
+func (km *KeyManager) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(km.active.Method, claims)
+	if km.active.ID != "" {
+		token.Header["kid"] = km.active.ID
+	}
+	return token.SignedString(km.active.Private)
+}
+
+// Keyfunc picks the verification key named by the token's kid header.
+func (km *KeyManager) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	key, ok := km.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method")
+	}
+	return key.Public, nil
+}
+
+// Methods lists the algorithms of every key that may verify a token.
+func (km *KeyManager) Methods() []string {
+	var methods []string
+	for _, key := range km.keys {
+		if !slices.Contains(methods, key.Method.Alg()) {
+			methods = append(methods, key.Method.Alg())
+		}
+	}
+	return methods
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public half of every verification key. The HS256
+// fallback has no public key and is never published.
+func (km *KeyManager) JWKS() JWKSet {
+	set := JWKSet{Keys: make([]JWK, 0, len(km.keys))}
+
+	for _, key := range km.keys {
+		jwk := JWK{Use: "sig", Alg: key.Method.Alg(), Kid: key.ID}
+
+		switch pub := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		default:
+			continue
+		}
+
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+
+	return set
+}
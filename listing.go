@@ -0,0 +1,354 @@
+package main
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// List endpoints page with keyset cursors instead of offsets, so every page
+// costs the same however deep it is.
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// ListQuery selects one page of a listing.
+type ListQuery struct {
+	Limit int
+	// Sort names one of the resource's sort fields. Rows with equal values
+	// are ordered by id in the same direction.
+	Sort string
+	Desc bool
+	// Cursor is the row the page starts after, exclusive. With Backward the
+	// page ends before it instead.
+	Cursor   *Cursor
+	Backward bool
+}
+
+// descending reports whether rows are read in descending order, which is
+// the opposite of the sort order when paging backwards.
+func (q ListQuery) descending() bool {
+	return q.Desc != q.Backward
+}
+
+// Cursor is the position of a row in a sort order. Clients get it as an
+// opaque string.
+type Cursor struct {
+	Sort string
+	Desc bool
+	// Value is the row's sort field, an int, float32, string or time.Time.
+	Value any
+	ID    int
+}
+
+type cursorJSON struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d,omitempty"`
+	Value string `json:"v"`
+	ID    int    `json:"i"`
+}
+
+// SortField is a field a listing can be sorted by.
+type SortField[T any] struct {
+	Column string
+	// Value returns the field of an item as an int, float32, string or
+	// time.Time.
+	Value func(item T) any
+	ID    func(item T) int
+}
+
+func encodeCursor(c Cursor) string {
+	var value string
+	switch v := c.Value.(type) {
+	case int:
+		value = strconv.Itoa(v)
+	case float32:
+		value = strconv.FormatFloat(float64(v), 'g', -1, 32)
+	case time.Time:
+		value = v.UTC().Format(time.RFC3339Nano)
+	case string:
+		value = v
+	}
+
+	data, _ := json.Marshal(cursorJSON{Sort: c.Sort, Desc: c.Desc, Value: value, ID: c.ID})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// errCursorSort is returned for a cursor from a listing with another sort
+// order, whose position means nothing in this one.
+var errCursorSort = errors.New("cursor belongs to a different sort order")
+
+// decodeCursor parses a cursor from a listing sorted by q's order,
+// converting its value to the type field.Value returns.
+func decodeCursor[T any](raw string, q ListQuery, field SortField[T]) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, err
+	}
+	var c cursorJSON
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	if c.Sort != q.Sort || c.Desc != q.Desc {
+		return nil, errCursorSort
+	}
+
+	var zero T
+	var value any
+	switch field.Value(zero).(type) {
+	case int:
+		value, err = strconv.Atoi(c.Value)
+	case float32:
+		var f float64
+		f, err = strconv.ParseFloat(c.Value, 32)
+		value = float32(f)
+	case time.Time:
+		value, err = time.Parse(time.RFC3339Nano, c.Value)
+	default:
+		value = c.Value
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Cursor{Sort: c.Sort, Desc: c.Desc, Value: value, ID: c.ID}, nil
+}
+
+// parseListQuery reads limit, sort, after and before from the query string.
+// Sort is a field name, prefixed with "-" for descending order. On failure it
+// writes the error response and returns false.
+func parseListQuery[T any](w http.ResponseWriter, r *http.Request, fields map[string]SortField[T], defaultSort string) (ListQuery, bool) {
+	query := r.URL.Query()
+	errs := make(map[string]string)
+
+	q := ListQuery{Limit: defaultPageSize}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			errs["limit"] = fmt.Sprintf("limit must be between 1 and %d", maxPageSize)
+		}
+		q.Limit = limit
+	}
+
+	sort := query.Get("sort")
+	if sort == "" {
+		sort = defaultSort
+	}
+	q.Sort, q.Desc = strings.TrimPrefix(sort, "-"), strings.HasPrefix(sort, "-")
+	field, ok := fields[q.Sort]
+	if !ok {
+		names := make([]string, 0, len(fields))
+		for name := range fields {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		errs["sort"] = fmt.Sprintf("sort must be one of: %s", strings.Join(names, ", "))
+	}
+
+	after, before := query.Get("after"), query.Get("before")
+	switch {
+	case after != "" && before != "":
+		errs["after"] = "after and before cannot be combined"
+	case ok && (after != "" || before != ""):
+		param, raw := "after", after
+		if before != "" {
+			param, raw, q.Backward = "before", before, true
+		}
+		cursor, err := decodeCursor(raw, q, field)
+		switch {
+		case errors.Is(err, errCursorSort):
+			errs[param] = fmt.Sprintf("%s is a cursor for a different sort order", param)
+		case err != nil:
+			errs[param] = fmt.Sprintf("%s is not a valid cursor", param)
+		}
+		q.Cursor = cursor
+	}
+
+	if len(errs) > 0 {
+		CustomJsonResponse(w, http.StatusBadRequest, errs)
+		return ListQuery{}, false
+	}
+	return q, true
+}
+
+// timeParam parses the RFC 3339 time in the named query parameter, or
+// returns nil when it is absent. Errors are added to errs.
+func timeParam(query url.Values, name string, errs map[string]string) *time.Time {
+	value := query.Get(name)
+	if value == "" {
+		return nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		errs[name] = fmt.Sprintf("%s must be an RFC 3339 time", name)
+		return nil
+	}
+	return &t
+}
+
+// newPage turns the rows a store returned for q, up to q.Limit+1 in reading
+// order, into a page with cursors to its neighbours.
+func newPage[T any](rows []T, q ListQuery, field SortField[T]) Page[T] {
+	more := len(rows) > q.Limit
+	if more {
+		rows = rows[:q.Limit]
+	}
+	if q.Backward {
+		slices.Reverse(rows)
+	}
+
+	page := Page[T]{Data: rows}
+	if len(rows) == 0 {
+		page.Data = make([]T, 0)
+		return page
+	}
+
+	cursor := func(item T) string {
+		return encodeCursor(Cursor{Sort: q.Sort, Desc: q.Desc, Value: field.Value(item), ID: field.ID(item)})
+	}
+	// Reading from a cursor means there are rows on the side it came from.
+	if more && !q.Backward || q.Backward && q.Cursor != nil {
+		page.NextCursor = cursor(rows[len(rows)-1])
+	}
+	if more && q.Backward || !q.Backward && q.Cursor != nil {
+		page.PrevCursor = cursor(rows[0])
+	}
+	return page
+}
+
+// writePage responds with page and a Link header pointing at its neighbours.
+// The links keep the request's other query parameters.
+func writePage[T any](w http.ResponseWriter, r *http.Request, page Page[T]) {
+	link := func(param, cursor, rel string) string {
+		query := r.URL.Query()
+		query.Del("after")
+		query.Del("before")
+		query.Set(param, cursor)
+		return fmt.Sprintf(`<%s>; rel="%s"`, (&url.URL{Path: r.URL.Path, RawQuery: query.Encode()}).String(), rel)
+	}
+
+	var links []string
+	if page.NextCursor != "" {
+		links = append(links, link("after", page.NextCursor, "next"))
+	}
+	if page.PrevCursor != "" {
+		links = append(links, link("before", page.PrevCursor, "prev"))
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+
+	CustomJsonResponse(w, http.StatusOK, page)
+}
+
+// pageOf applies q to items in memory the way the Postgres stores do in SQL.
+// It returns up to q.Limit+1 items in reading order.
+func pageOf[T any](items []T, q ListQuery, field SortField[T]) []T {
+	// compare orders (value, id) pairs in reading order.
+	compare := func(aValue any, aID int, bValue any, bID int) int {
+		c := compareSortValues(aValue, bValue)
+		if c == 0 {
+			c = aID - bID
+		}
+		if q.descending() {
+			return -c
+		}
+		return c
+	}
+	slices.SortFunc(items, func(a, b T) int {
+		return compare(field.Value(a), field.ID(a), field.Value(b), field.ID(b))
+	})
+
+	if q.Cursor != nil {
+		start := len(items)
+		for i, item := range items {
+			if compare(field.Value(item), field.ID(item), q.Cursor.Value, q.Cursor.ID) > 0 {
+				start = i
+				break
+			}
+		}
+		items = items[start:]
+	}
+
+	if len(items) > q.Limit+1 {
+		items = items[:q.Limit+1]
+	}
+	return items
+}
+
+func compareSortValues(a, b any) int {
+	switch a := a.(type) {
+	case int:
+		return a - b.(int)
+	case float32:
+		return cmp.Compare(a, b.(float32))
+	case string:
+		return strings.Compare(a, b.(string))
+	case time.Time:
+		return a.Compare(b.(time.Time))
+	}
+	return 0
+}
+
+// sqlConditions collects a WHERE clause and its numbered arguments.
+type sqlConditions struct {
+	conds []string
+	args  []any
+}
+
+// add appends a condition whose single %d verb becomes the argument's
+// placeholder number.
+func (c *sqlConditions) add(format string, arg any) {
+	c.conds = append(c.conds, fmt.Sprintf(format, c.argNumber(arg)))
+}
+
+// arg adds an argument used outside the WHERE clause and returns its
+// placeholder.
+func (c *sqlConditions) arg(arg any) string {
+	return "$" + strconv.Itoa(c.argNumber(arg))
+}
+
+func (c *sqlConditions) argNumber(arg any) int {
+	c.args = append(c.args, arg)
+	return len(c.args)
+}
+
+// keyset adds the condition that starts q's page after its cursor and
+// returns the matching ORDER BY clause and LIMIT.
+func (c *sqlConditions) keyset(column string, q ListQuery) string {
+	op, dir := ">", "ASC"
+	if q.descending() {
+		op, dir = "<", "DESC"
+	}
+
+	if q.Cursor != nil {
+		if column == "id" {
+			c.add("id "+op+" $%d", q.Cursor.ID)
+		} else {
+			c.conds = append(c.conds, fmt.Sprintf("(%s, id) %s (%s, %s)", column, op, c.arg(q.Cursor.Value), c.arg(q.Cursor.ID)))
+		}
+	}
+
+	order := fmt.Sprintf(" ORDER BY %s %s", column, dir)
+	if column != "id" {
+		order += ", id " + dir
+	}
+	return order + fmt.Sprintf(" LIMIT %d", q.Limit+1)
+}
+
+func (c *sqlConditions) where() string {
+	if len(c.conds) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(c.conds, " AND ")
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestLogMiddlewareOmitsQuery(t *testing.T) {
+	var buf bytes.Buffer
+	previous := log.Writer()
+	log.SetOutput(&buf)
+	t.Cleanup(func() { log.SetOutput(previous) })
+
+	handler := LogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/verify-email?token=secret-token", nil))
+
+	if line := buf.String(); !strings.Contains(line, "GET /verify-email") || strings.Contains(line, "secret-token") {
+		t.Errorf("logged %q, want the path without the token", line)
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLogout(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser("alice", RoleMember)
+	tokens := ts.login("alice")
+	other := ts.login("alice")
+
+	expectStatus(t, ts.do(http.MethodPost, "/logout", "", nil), http.StatusUnauthorized)
+
+	resp := ts.do(http.MethodPost, "/logout", tokens.Token, LogoutRequest{RefreshToken: tokens.RefreshToken})
+	expectStatus(t, resp, http.StatusOK)
+
+	expectStatus(t, ts.do(http.MethodGet, "/me", tokens.Token, nil), http.StatusUnauthorized)
+	expectStatus(t, ts.do(http.MethodPost, "/token/refresh", "", RefreshRequest{RefreshToken: tokens.RefreshToken}), http.StatusUnauthorized)
+
+	// Other logins keep working.
+	expectStatus(t, ts.do(http.MethodGet, "/me", other.Token, nil), http.StatusOK)
+}
+
+func TestRevokeSessions(t *testing.T) {
+	ts := newTestServer(t)
+	admin := ts.createUser("admin", RoleAdmin)
+	alice := ts.createUser("alice", RoleMember)
+	tokens := ts.login("alice")
+	earlier := ts.accessToken(alice, false, time.Now().Add(-time.Minute))
+
+	adminToken := ts.accessToken(admin, true, time.Now())
+	expectStatus(t, ts.do(http.MethodPost, "/user/sessions/revoke?id="+strconv.Itoa(alice.ID), tokens.Token, nil), http.StatusForbidden)
+	expectStatus(t, ts.do(http.MethodPost, "/user/sessions/revoke?id="+strconv.Itoa(alice.ID), adminToken, nil), http.StatusOK)
+
+	expectStatus(t, ts.do(http.MethodGet, "/me", earlier, nil), http.StatusUnauthorized)
+	expectStatus(t, ts.do(http.MethodPost, "/token/refresh", "", RefreshRequest{RefreshToken: tokens.RefreshToken}), http.StatusUnauthorized)
+
+	// Logging in again right away works, even within the same second.
+	expectStatus(t, ts.do(http.MethodGet, "/me", ts.login("alice").Token, nil), http.StatusOK)
+}
@@ -1,39 +1,121 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
-)
 
-var JWT_SECRET = "SANJEEV_KUMAR"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
 
 func main() {
-	InitDB()
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path to a JSON, YAML or TOML config file")
+	printConfig := flag.Bool("print-config", false, "print the resolved config with secrets redacted and exit")
+	flag.Parse()
+
+	cfg, err := LoadConfig(*configPath)
+	if err != nil {
+		log.Fatal("Failed to load config: ", err)
+	}
+
+	if *printConfig {
+		fmt.Print(cfg)
+		return
+	}
+
+	keys, err := LoadKeyManager(cfg)
+	if err != nil {
+		log.Fatal("Failed to load signing keys: ", err)
+	}
+
+	mailer := NewMailer(cfg)
+
+	policy, err := LoadPasswordPolicy(cfg)
+	if err != nil {
+		log.Fatal("Failed to load password policy: ", err)
+	}
+
+	var app *Server
+	var db *pgxpool.Pool
 
-	mux := http.NewServeMux()
+	switch cfg.Store {
+	case "memory":
+		if flag.Arg(0) == "migrate" {
+			log.Fatal("migrate requires store: postgres")
+		}
+		app = NewServer(cfg, NewMemoryStores(), keys, mailer, policy)
+	default:
+		db = InitDB(cfg.DatabaseURL)
 
-	mux.HandleFunc("/", IndexHandler)
-	mux.HandleFunc("/time", GetTimeHandler)
-	mux.HandleFunc("/ip", GetIPHandler)
-	mux.HandleFunc("/dummyPost", DummyPostHandler)
-	mux.HandleFunc("/post", PostHandler)
-	mux.Handle("/user", AuthMiddleware(http.HandlerFunc(UserHandler)))
-	mux.HandleFunc("/login", LoginHandler)
+		if flag.Arg(0) == "migrate" {
+			if err := RunMigrateCommand(context.Background(), db, flag.Args()[1:]); err != nil {
+				log.Fatal("Migration failed: ", err)
+			}
+			return
+		}
 
-	LoggingMiddleware := LogMiddleware(mux)
+		if cfg.AutoMigrate {
+			migrator, err := NewMigrator(db)
+			if err != nil {
+				log.Fatal("Failed to load migrations: ", err)
+			}
+			if err := migrator.Up(context.Background()); err != nil {
+				log.Fatal("Migration failed: ", err)
+			}
+		}
+
+		app = NewServer(cfg, NewPgStores(db), keys, mailer, policy)
+	}
 
 	server := &http.Server{
-		Addr:         ":1414",
-		Handler:      LoggingMiddleware,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
+		Addr:         cfg.Addr,
+		Handler:      app.Routes(),
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
 	}
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
 	fmt.Printf("Custom server running on port %s\n", server.Addr)
+	app.SetReady(true)
 
-	if err := server.ListenAndServe(); err != nil {
-		log.Fatal("Failed to start server: ", err)
+	select {
+	case err := <-serverErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal("Failed to start server: ", err)
+		}
+	case <-ctx.Done():
+		// A second signal kills the process instead of waiting for the drain.
+		stop()
 	}
+
+	log.Println("Shutting down")
+	app.SetReady(false)
+	time.Sleep(cfg.ShutdownDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Println("Failed to drain connections: ", err)
+	}
+
+	if db != nil {
+		db.Close()
+	}
+
+	log.Println("Server stopped")
 }
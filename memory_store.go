@@ -0,0 +1,1168 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+func NewMemoryStores() Stores {
+	users, posts, comments := NewMemoryUserStore(), NewMemoryPostStore(), NewMemoryCommentStore()
+	users.posts = posts
+	posts.comments = comments
+
+	return Stores{
+		Users:         users,
+		Posts:         posts,
+		RefreshTokens: NewMemoryRefreshTokenStore(),
+		Denylist:      NewMemoryTokenDenylist(),
+		ResetTokens:   NewMemoryPasswordResetStore(),
+		MFA:           NewMemoryMFAStore(),
+		LoginAttempts: NewMemoryLoginAttemptStore(),
+		APIKeys:       NewMemoryAPIKeyStore(),
+		Sessions:      NewMemorySessionStore(),
+		Identities:    NewMemoryIdentityStore(),
+		Comments:      comments,
+	}
+}
+
+// MemoryUserStore is a UserStore kept in process memory, used for local
+// development and handler tests.
+type MemoryUserStore struct {
+	mu     sync.RWMutex
+	users  map[int]User
+	nextID int
+	// posts, when set, loses a user's posts and comments with the user,
+	// like the foreign key cascades in Postgres.
+	posts *MemoryPostStore
+}
+
+func NewMemoryUserStore() *MemoryUserStore {
+	return &MemoryUserStore{users: make(map[int]User), nextID: 1}
+}
+
+func (s *MemoryUserStore) Create(_ context.Context, user *User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.exists(user.Username, user.Email, 0) {
+		return ErrConflict
+	}
+
+	now := time.Now()
+	user.ID = s.nextID
+	user.CreatedAt = now
+	user.UpdatedAt = now
+	s.nextID++
+
+	s.users[user.ID] = *user
+	return nil
+}
+
+func (s *MemoryUserStore) Get(_ context.Context, id int) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return User{}, ErrNotFound
+	}
+	user.Password = ""
+	return user, nil
+}
+
+func (s *MemoryUserStore) GetByUsername(_ context.Context, username string) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, user := range s.users {
+		if user.Username == username {
+			return user, nil
+		}
+	}
+	return User{}, ErrNotFound
+}
+
+func (s *MemoryUserStore) GetByEmail(_ context.Context, email string) (User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, user := range s.users {
+		if user.Email == email {
+			user.Password = ""
+			return user, nil
+		}
+	}
+	return User{}, ErrNotFound
+}
+
+func (s *MemoryUserStore) List(_ context.Context, filter UserFilter, q ListQuery) ([]User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := make([]User, 0, len(s.users))
+	for _, user := range s.users {
+		if filter.IsActive != nil && user.IsActive != *filter.IsActive {
+			continue
+		}
+		if filter.Role != "" && user.Role != filter.Role {
+			continue
+		}
+		if filter.CreatedAfter != nil && !user.CreatedAt.After(*filter.CreatedAfter) {
+			continue
+		}
+		user.Password = ""
+		users = append(users, user)
+	}
+
+	return pageOf(users, q, userSortFields[q.Sort]), nil
+}
+
+func (s *MemoryUserStore) Update(_ context.Context, user *User) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.users[user.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if s.exists(user.Username, user.Email, user.ID) {
+		return ErrConflict
+	}
+
+	stored := *user
+	if stored.Password == "" {
+		stored.Password = existing.Password
+	}
+	stored.EmailVerifiedAt = existing.EmailVerifiedAt
+	user.EmailVerifiedAt = existing.EmailVerifiedAt
+
+	user.IsActive, user.DeactivatedAt, user.DeactivationReason = existing.IsActive, existing.DeactivatedAt, existing.DeactivationReason
+	stored.IsActive, stored.DeactivatedAt, stored.DeactivationReason = user.IsActive, user.DeactivatedAt, user.DeactivationReason
+
+	user.CreatedAt = existing.CreatedAt
+	user.UpdatedAt = time.Now()
+	stored.CreatedAt = user.CreatedAt
+	stored.UpdatedAt = user.UpdatedAt
+	s.users[user.ID] = stored
+	return nil
+}
+
+func (s *MemoryUserStore) UpdatePassword(_ context.Context, id int, passwordHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	user.Password = passwordHash
+	user.UpdatedAt = time.Now()
+	s.users[id] = user
+	return nil
+}
+
+func (s *MemoryUserStore) SetEmailVerified(_ context.Context, id int, verifiedAt *time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+	user.EmailVerifiedAt = verifiedAt
+	s.users[id] = user
+	return nil
+}
+
+func (s *MemoryUserStore) SetActive(_ context.Context, id int, active bool, reason string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[id]
+	if !ok {
+		return ErrNotFound
+	}
+
+	now := time.Now()
+	user.IsActive = active
+	user.UpdatedAt = now
+	user.DeactivatedAt, user.DeactivationReason = nil, nil
+	if !active {
+		user.DeactivatedAt, user.DeactivationReason = &now, &reason
+	}
+	s.users[id] = user
+	return nil
+}
+
+func (s *MemoryUserStore) Delete(_ context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.users, id)
+	if s.posts != nil {
+		s.posts.deleteUser(id)
+	}
+	return nil
+}
+
+func (s *MemoryUserStore) ExistsByUsernameOrEmail(_ context.Context, username, email string, excludeID int) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.exists(username, email, excludeID), nil
+}
+
+func (s *MemoryUserStore) exists(username, email string, excludeID int) bool {
+	for _, user := range s.users {
+		if user.ID == excludeID {
+			continue
+		}
+		if user.Username == username || user.Email == email {
+			return true
+		}
+	}
+	return false
+}
+
+type MemoryPostStore struct {
+	mu     sync.RWMutex
+	posts  map[int]Post
+	nextID int
+	// comments, when set, loses a post's comments with the post, like the
+	// foreign key cascade in Postgres.
+	comments *MemoryCommentStore
+}
+
+func NewMemoryPostStore() *MemoryPostStore {
+	return &MemoryPostStore{posts: make(map[int]Post), nextID: 1}
+}
+
+func (s *MemoryPostStore) Create(_ context.Context, post *Post) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	post.ID = s.nextID
+	post.CreatedAt = time.Now()
+	post.Tags = slices.Clone(post.Tags)
+	s.nextID++
+
+	s.posts[post.ID] = *post
+	return nil
+}
+
+func (s *MemoryPostStore) Get(_ context.Context, id int) (Post, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	post, ok := s.posts[id]
+	if !ok {
+		return Post{}, ErrNotFound
+	}
+	return post, nil
+}
+
+func matchesPostFilter(post Post, filter PostFilter) bool {
+	if filter.UserID != 0 && post.UserId != filter.UserID {
+		return false
+	}
+	if filter.CreatedAfter != nil && !post.CreatedAt.After(*filter.CreatedAfter) {
+		return false
+	}
+	if filter.Category != "" && post.Category != filter.Category {
+		return false
+	}
+	if len(filter.Tags) == 0 {
+		return true
+	}
+
+	for _, tag := range filter.Tags {
+		tagged := slices.Contains(post.Tags, tag)
+		if tagged && filter.AnyTag {
+			return true
+		}
+		if !tagged && !filter.AnyTag {
+			return false
+		}
+	}
+	return !filter.AnyTag
+}
+
+func (s *MemoryPostStore) List(_ context.Context, filter PostFilter, q ListQuery) ([]Post, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	posts := make([]Post, 0, len(s.posts))
+	for _, post := range s.posts {
+		if !matchesPostFilter(post, filter) {
+			continue
+		}
+		posts = append(posts, post)
+	}
+
+	return pageOf(posts, q, postSortFields[q.Sort]), nil
+}
+
+func (s *MemoryPostStore) Search(_ context.Context, terms []SearchTerm, filter PostFilter, q ListQuery) ([]SearchResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	results := make([]SearchResult, 0)
+	for _, post := range s.posts {
+		if !matchesPostFilter(post, filter) {
+			continue
+		}
+		rank, ok := matchPost(post, terms)
+		if !ok {
+			continue
+		}
+		results = append(results, SearchResult{Post: post, Rank: rank})
+	}
+
+	results = pageOf(results, q, searchSortFields[q.Sort])
+	for i := range results {
+		results[i].Headline = memoryHeadline(results[i].Body, terms)
+	}
+	return results, nil
+}
+
+func (s *MemoryPostStore) Update(_ context.Context, post *Post) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.posts[post.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	post.CreatedAt = existing.CreatedAt
+	post.Tags = slices.Clone(post.Tags)
+	s.posts[post.ID] = *post
+	return nil
+}
+
+func (s *MemoryPostStore) Delete(_ context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.posts[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.posts, id)
+	if s.comments != nil {
+		s.comments.deletePost(id)
+	}
+	return nil
+}
+
+// deleteUser removes the posts and comments written by userID.
+func (s *MemoryPostStore) deleteUser(userID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, post := range s.posts {
+		if post.UserId == userID {
+			delete(s.posts, id)
+			if s.comments != nil {
+				s.comments.deletePost(id)
+			}
+		}
+	}
+	if s.comments != nil {
+		s.comments.deleteUser(userID)
+	}
+}
+
+func (s *MemoryPostStore) Tags(_ context.Context) ([]Tag, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := make(map[string]int)
+	for _, post := range s.posts {
+		for _, tag := range post.Tags {
+			counts[tag]++
+		}
+	}
+
+	tags := make([]Tag, 0, len(counts))
+	for slug, count := range counts {
+		tags = append(tags, Tag{Slug: slug, Count: count})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Count != tags[j].Count {
+			return tags[i].Count > tags[j].Count
+		}
+		return tags[i].Slug < tags[j].Slug
+	})
+
+	return tags, nil
+}
+
+type MemoryRefreshTokenStore struct {
+	mu     sync.Mutex
+	tokens map[int]RefreshToken
+	nextID int
+}
+
+func NewMemoryRefreshTokenStore() *MemoryRefreshTokenStore {
+	return &MemoryRefreshTokenStore{tokens: make(map[int]RefreshToken), nextID: 1}
+}
+
+func (s *MemoryRefreshTokenStore) Create(_ context.Context, token *RefreshToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.tokens {
+		if existing.TokenHash == token.TokenHash {
+			return ErrConflict
+		}
+	}
+
+	token.ID = s.nextID
+	token.CreatedAt = time.Now()
+	s.nextID++
+
+	s.tokens[token.ID] = *token
+	return nil
+}
+
+func (s *MemoryRefreshTokenStore) GetByHash(_ context.Context, tokenHash string) (RefreshToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, token := range s.tokens {
+		if token.TokenHash == tokenHash {
+			return token, nil
+		}
+	}
+	return RefreshToken{}, ErrNotFound
+}
+
+func (s *MemoryRefreshTokenStore) MarkUsed(_ context.Context, id int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.tokens[id]
+	if !ok {
+		return false, ErrNotFound
+	}
+	if token.UsedAt != nil {
+		return false, nil
+	}
+
+	now := time.Now()
+	token.UsedAt = &now
+	s.tokens[id] = token
+	return true, nil
+}
+
+func (s *MemoryRefreshTokenStore) RevokeFamily(_ context.Context, familyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id, token := range s.tokens {
+		if token.FamilyID == familyID && token.RevokedAt == nil {
+			token.RevokedAt = &now
+			s.tokens[id] = token
+		}
+	}
+	return nil
+}
+
+func (s *MemoryRefreshTokenStore) RevokeUser(_ context.Context, userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id, token := range s.tokens {
+		if token.UserID == userID && token.RevokedAt == nil {
+			token.RevokedAt = &now
+			s.tokens[id] = token
+		}
+	}
+	return nil
+}
+
+type userRevocation struct {
+	revokedBefore time.Time
+	expiresAt     time.Time
+}
+
+// MemoryTokenDenylist evicts expired entries whenever a new one is added,
+// so it never holds more than the tokens that are still live.
+type MemoryTokenDenylist struct {
+	mu     sync.Mutex
+	tokens map[string]time.Time
+	users  map[int]userRevocation
+}
+
+func NewMemoryTokenDenylist() *MemoryTokenDenylist {
+	return &MemoryTokenDenylist{
+		tokens: make(map[string]time.Time),
+		users:  make(map[int]userRevocation),
+	}
+}
+
+func (d *MemoryTokenDenylist) Revoke(_ context.Context, jti string, expiresAt time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.evictExpired(time.Now())
+	d.tokens[jti] = expiresAt
+	return nil
+}
+
+func (d *MemoryTokenDenylist) RevokeUser(_ context.Context, userID int, revokedBefore, expiresAt time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.evictExpired(time.Now())
+	d.users[userID] = userRevocation{revokedBefore: revokedBefore.Truncate(time.Second), expiresAt: expiresAt}
+	return nil
+}
+
+func (d *MemoryTokenDenylist) IsRevoked(_ context.Context, claims *UserClaims) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := time.Now()
+
+	if expiresAt, ok := d.tokens[claims.RegisteredClaims.ID]; ok && now.Before(expiresAt) {
+		return true, nil
+	}
+
+	if revocation, ok := d.users[claims.ID]; ok && now.Before(revocation.expiresAt) {
+		if claims.IssuedAt == nil || claims.IssuedAt.Before(revocation.revokedBefore) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (d *MemoryTokenDenylist) evictExpired(now time.Time) {
+	for jti, expiresAt := range d.tokens {
+		if !now.Before(expiresAt) {
+			delete(d.tokens, jti)
+		}
+	}
+	for userID, revocation := range d.users {
+		if !now.Before(revocation.expiresAt) {
+			delete(d.users, userID)
+		}
+	}
+}
+
+type MemoryPasswordResetStore struct {
+	mu     sync.Mutex
+	tokens map[string]PasswordResetToken
+	nextID int
+}
+
+func NewMemoryPasswordResetStore() *MemoryPasswordResetStore {
+	return &MemoryPasswordResetStore{tokens: make(map[string]PasswordResetToken), nextID: 1}
+}
+
+func (s *MemoryPasswordResetStore) Create(_ context.Context, token *PasswordResetToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.tokens[token.TokenHash]; ok {
+		return ErrConflict
+	}
+
+	token.ID = s.nextID
+	token.CreatedAt = time.Now()
+	s.nextID++
+
+	s.tokens[token.TokenHash] = *token
+	return nil
+}
+
+func (s *MemoryPasswordResetStore) Lookup(_ context.Context, tokenHash string) (PasswordResetToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.tokens[tokenHash]
+	if !ok || token.UsedAt != nil || !time.Now().Before(token.ExpiresAt) {
+		return PasswordResetToken{}, ErrNotFound
+	}
+	return token, nil
+}
+
+func (s *MemoryPasswordResetStore) Consume(_ context.Context, tokenHash string) (PasswordResetToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	token, ok := s.tokens[tokenHash]
+	if !ok || token.UsedAt != nil || !now.Before(token.ExpiresAt) {
+		return PasswordResetToken{}, ErrNotFound
+	}
+
+	token.UsedAt = &now
+	s.tokens[tokenHash] = token
+	return token, nil
+}
+
+func (s *MemoryPasswordResetStore) InvalidateUser(_ context.Context, userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for hash, token := range s.tokens {
+		if token.UserID == userID && token.UsedAt == nil {
+			token.UsedAt = &now
+			s.tokens[hash] = token
+		}
+	}
+	return nil
+}
+
+type MemoryMFAStore struct {
+	mu    sync.Mutex
+	totp  map[int]TOTPSecret
+	codes map[int]map[string]bool
+}
+
+func NewMemoryMFAStore() *MemoryMFAStore {
+	return &MemoryMFAStore{totp: make(map[int]TOTPSecret), codes: make(map[int]map[string]bool)}
+}
+
+func (s *MemoryMFAStore) GetTOTP(_ context.Context, userID int) (TOTPSecret, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	totp, ok := s.totp[userID]
+	if !ok {
+		return TOTPSecret{}, ErrNotFound
+	}
+	return totp, nil
+}
+
+func (s *MemoryMFAStore) SetPendingTOTP(_ context.Context, userID int, secret string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.totp[userID]; ok && existing.EnabledAt != nil {
+		return ErrConflict
+	}
+
+	s.totp[userID] = TOTPSecret{UserID: userID, Secret: secret, CreatedAt: time.Now()}
+	return nil
+}
+
+func (s *MemoryMFAStore) EnableTOTP(_ context.Context, userID int, recoveryCodeHashes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	totp, ok := s.totp[userID]
+	if !ok || totp.EnabledAt != nil {
+		return ErrNotFound
+	}
+
+	now := time.Now()
+	totp.EnabledAt = &now
+	s.totp[userID] = totp
+	s.replaceRecoveryCodes(userID, recoveryCodeHashes)
+	return nil
+}
+
+func (s *MemoryMFAStore) DisableTOTP(_ context.Context, userID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.totp, userID)
+	delete(s.codes, userID)
+	return nil
+}
+
+func (s *MemoryMFAStore) UseTOTPStep(_ context.Context, userID int, step int64) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	totp, ok := s.totp[userID]
+	if !ok || totp.LastStep >= step {
+		return false, nil
+	}
+
+	totp.LastStep = step
+	s.totp[userID] = totp
+	return true, nil
+}
+
+func (s *MemoryMFAStore) ReplaceRecoveryCodes(_ context.Context, userID int, codeHashes []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.replaceRecoveryCodes(userID, codeHashes)
+	return nil
+}
+
+// replaceRecoveryCodes must be called with s.mu held. The map value records
+// whether the code has been used.
+func (s *MemoryMFAStore) replaceRecoveryCodes(userID int, codeHashes []string) {
+	codes := make(map[string]bool, len(codeHashes))
+	for _, hash := range codeHashes {
+		codes[hash] = false
+	}
+	s.codes[userID] = codes
+}
+
+func (s *MemoryMFAStore) UseRecoveryCode(_ context.Context, userID int, codeHash string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	used, ok := s.codes[userID][codeHash]
+	if !ok || used {
+		return false, nil
+	}
+
+	s.codes[userID][codeHash] = true
+	return true, nil
+}
+
+type MemoryLoginAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]LoginAttempts
+}
+
+func NewMemoryLoginAttemptStore() *MemoryLoginAttemptStore {
+	return &MemoryLoginAttemptStore{attempts: make(map[string]LoginAttempts)}
+}
+
+func (s *MemoryLoginAttemptStore) Get(_ context.Context, key string) (LoginAttempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempts, ok := s.attempts[key]
+	if !ok {
+		return LoginAttempts{}, ErrNotFound
+	}
+	return attempts, nil
+}
+
+func (s *MemoryLoginAttemptStore) Reserve(_ context.Context, key string, window time.Duration, wait func(LoginAttempts) time.Duration) (LoginAttempts, time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	cutoff := now.Add(-window)
+
+	for k, attempts := range s.attempts {
+		if attempts.LastFailedAt.Before(cutoff) {
+			delete(s.attempts, k)
+		}
+	}
+
+	previous, ok := s.attempts[key]
+	if !ok {
+		previous = LoginAttempts{Key: key}
+	}
+	if d := wait(previous); d > 0 {
+		return previous, d, nil
+	}
+
+	attempts := previous
+	attempts.Failures++
+	attempts.LastFailedAt = now
+	s.attempts[key] = attempts
+
+	return previous, 0, nil
+}
+
+func (s *MemoryLoginAttemptStore) Release(_ context.Context, previous LoginAttempts) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	attempts, ok := s.attempts[previous.Key]
+	if !ok {
+		return nil
+	}
+
+	attempts.Failures--
+	switch {
+	case attempts.Failures <= 0:
+		delete(s.attempts, previous.Key)
+		return nil
+	case attempts.Failures == previous.Failures:
+		// Nothing else was counted since, so the last failure is the
+		// one before this attempt.
+		attempts.LastFailedAt = previous.LastFailedAt
+	}
+	s.attempts[previous.Key] = attempts
+	return nil
+}
+
+func (s *MemoryLoginAttemptStore) Reset(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.attempts, key)
+	return nil
+}
+
+type MemoryAPIKeyStore struct {
+	mu     sync.Mutex
+	keys   map[int]APIKey
+	nextID int
+}
+
+func NewMemoryAPIKeyStore() *MemoryAPIKeyStore {
+	return &MemoryAPIKeyStore{keys: make(map[int]APIKey), nextID: 1}
+}
+
+func (s *MemoryAPIKeyStore) Create(_ context.Context, key *APIKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.keys {
+		if existing.Prefix == key.Prefix {
+			return ErrConflict
+		}
+	}
+
+	key.ID = s.nextID
+	key.CreatedAt = time.Now()
+	s.nextID++
+
+	stored := *key
+	stored.Scopes = slices.Clone(key.Scopes)
+	s.keys[key.ID] = stored
+	return nil
+}
+
+func (s *MemoryAPIKeyStore) Get(_ context.Context, id int) (APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return APIKey{}, ErrNotFound
+	}
+	return key, nil
+}
+
+func (s *MemoryAPIKeyStore) GetByPrefix(_ context.Context, prefix string) (APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, key := range s.keys {
+		if key.Prefix == prefix {
+			return key, nil
+		}
+	}
+	return APIKey{}, ErrNotFound
+}
+
+func (s *MemoryAPIKeyStore) ListByUser(_ context.Context, userID int) ([]APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := []APIKey{}
+	for _, key := range s.keys {
+		if key.UserID == userID {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+	return keys, nil
+}
+
+func (s *MemoryAPIKeyStore) Revoke(_ context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if key.RevokedAt == nil {
+		now := time.Now()
+		key.RevokedAt = &now
+		s.keys[id] = key
+	}
+	return nil
+}
+
+func (s *MemoryAPIKeyStore) Touch(_ context.Context, id int, usedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[id]
+	if !ok {
+		return ErrNotFound
+	}
+	key.LastUsedAt = &usedAt
+	s.keys[id] = key
+	return nil
+}
+
+type MemorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]Session
+	nextID   int
+}
+
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: make(map[string]Session), nextID: 1}
+}
+
+// Create also drops expired sessions, so the map does not grow forever.
+func (s *MemorySessionStore) Create(_ context.Context, session *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for hash, existing := range s.sessions {
+		if !now.Before(existing.ExpiresAt) {
+			delete(s.sessions, hash)
+		}
+	}
+
+	if _, ok := s.sessions[session.TokenHash]; ok {
+		return ErrConflict
+	}
+
+	session.ID = s.nextID
+	session.CreatedAt = now
+	s.nextID++
+
+	s.sessions[session.TokenHash] = *session
+	return nil
+}
+
+func (s *MemorySessionStore) Get(_ context.Context, tokenHash string) (Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[tokenHash]
+	if !ok || !time.Now().Before(session.ExpiresAt) {
+		return Session{}, ErrNotFound
+	}
+	return session, nil
+}
+
+func (s *MemorySessionStore) Delete(_ context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for hash, session := range s.sessions {
+		if session.ID == id {
+			delete(s.sessions, hash)
+		}
+	}
+	return nil
+}
+
+func (s *MemorySessionStore) DeleteUser(_ context.Context, userID, keepID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for hash, session := range s.sessions {
+		if session.UserID == userID && session.ID != keepID {
+			delete(s.sessions, hash)
+		}
+	}
+	return nil
+}
+
+type MemoryIdentityStore struct {
+	mu         sync.Mutex
+	identities map[[2]string]Identity
+	nextID     int
+}
+
+func NewMemoryIdentityStore() *MemoryIdentityStore {
+	return &MemoryIdentityStore{identities: make(map[[2]string]Identity), nextID: 1}
+}
+
+func (s *MemoryIdentityStore) Get(_ context.Context, issuer, subject string) (Identity, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	identity, ok := s.identities[[2]string{issuer, subject}]
+	if !ok {
+		return Identity{}, ErrNotFound
+	}
+	return identity, nil
+}
+
+func (s *MemoryIdentityStore) Create(_ context.Context, identity *Identity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := [2]string{identity.Issuer, identity.Subject}
+	if _, ok := s.identities[key]; ok {
+		return ErrConflict
+	}
+
+	identity.ID = s.nextID
+	identity.CreatedAt = time.Now()
+	s.nextID++
+
+	s.identities[key] = *identity
+	return nil
+}
+
+type MemoryCommentStore struct {
+	mu       sync.RWMutex
+	comments map[int]Comment
+	nextID   int
+}
+
+func NewMemoryCommentStore() *MemoryCommentStore {
+	return &MemoryCommentStore{comments: make(map[int]Comment), nextID: 1}
+}
+
+func (s *MemoryCommentStore) Create(_ context.Context, comment *Comment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	comment.ID = s.nextID
+	comment.CreatedAt = time.Now()
+	s.nextID++
+
+	s.comments[comment.ID] = *comment
+	return nil
+}
+
+func (s *MemoryCommentStore) Get(_ context.Context, id int) (Comment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	comment, ok := s.comments[id]
+	if !ok {
+		return Comment{}, ErrNotFound
+	}
+	return s.withReplyCount(comment), nil
+}
+
+func (s *MemoryCommentStore) List(_ context.Context, postID, parentID int, q ListQuery) ([]Comment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	comments := make([]Comment, 0)
+	for _, comment := range s.comments {
+		if comment.PostID == postID && parentOf(comment) == parentID {
+			comments = append(comments, s.withReplyCount(comment))
+		}
+	}
+
+	return pageOf(comments, q, commentSortFields[q.Sort]), nil
+}
+
+func (s *MemoryCommentStore) Descendants(_ context.Context, ids []int, depth int) ([]Comment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	descendants := make([]Comment, 0)
+	for level := 0; level < depth && len(ids) > 0; level++ {
+		var next []int
+		for _, comment := range s.comments {
+			if slices.Contains(ids, parentOf(comment)) {
+				descendants = append(descendants, s.withReplyCount(comment))
+				next = append(next, comment.ID)
+			}
+		}
+		ids = next
+	}
+
+	sort.Slice(descendants, func(i, j int) bool {
+		if !descendants[i].CreatedAt.Equal(descendants[j].CreatedAt) {
+			return descendants[i].CreatedAt.Before(descendants[j].CreatedAt)
+		}
+		return descendants[i].ID < descendants[j].ID
+	})
+	return descendants, nil
+}
+
+func (s *MemoryCommentStore) Update(_ context.Context, comment *Comment) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.comments[comment.ID]
+	if !ok || existing.DeletedAt != nil {
+		return ErrNotFound
+	}
+
+	now := time.Now()
+	existing.Body = comment.Body
+	existing.EditedAt = &now
+	s.comments[comment.ID] = existing
+
+	*comment = s.withReplyCount(existing)
+	return nil
+}
+
+func (s *MemoryCommentStore) Delete(_ context.Context, id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	comment, ok := s.comments[id]
+	if !ok {
+		return ErrNotFound
+	}
+
+	comment.Body = ""
+	if comment.DeletedAt == nil {
+		now := time.Now()
+		comment.DeletedAt = &now
+	}
+	s.comments[id] = comment
+	return nil
+}
+
+// deletePost removes every comment on the post.
+func (s *MemoryCommentStore) deletePost(postID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id, comment := range s.comments {
+		if comment.PostID == postID {
+			delete(s.comments, id)
+		}
+	}
+}
+
+// deleteUser removes the comments written by userID together with every
+// reply below them, as the parent_id cascade does in Postgres.
+func (s *MemoryCommentStore) deleteUser(userID int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := make(map[int]bool)
+	for id, comment := range s.comments {
+		if comment.UserID == userID {
+			deleted[id] = true
+		}
+	}
+	for found := true; found; {
+		found = false
+		for id, comment := range s.comments {
+			if !deleted[id] && deleted[parentOf(comment)] {
+				deleted[id] = true
+				found = true
+			}
+		}
+	}
+	for id := range deleted {
+		delete(s.comments, id)
+	}
+}
+
+func (s *MemoryCommentStore) withReplyCount(comment Comment) Comment {
+	comment.ReplyCount = 0
+	for _, other := range s.comments {
+		if parentOf(other) == comment.ID {
+			comment.ReplyCount++
+		}
+	}
+	return comment
+}
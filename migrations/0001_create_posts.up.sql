@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS public.posts (
+id serial4 NOT NULL,
+title varchar(255) NOT NULL,
+body text NOT NULL,
+user_id int4 NOT NULL,
+CONSTRAINT posts_pkey PRIMARY KEY (id)
+);
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS public.users (
+id serial4 NOT NULL,
+username varchar(50) NOT NULL,
+email varchar(100) NOT NULL,
+"password" text NOT NULL,
+is_active bool DEFAULT true NULL,
+created_at timestamp DEFAULT CURRENT_TIMESTAMP NULL,
+updated_at timestamp DEFAULT CURRENT_TIMESTAMP NULL,
+CONSTRAINT users_email_key UNIQUE (email),
+CONSTRAINT users_pkey PRIMARY KEY (id),
+CONSTRAINT users_username_key UNIQUE (username)
+);
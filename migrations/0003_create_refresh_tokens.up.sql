@@ -0,0 +1,15 @@
+CREATE TABLE public.refresh_tokens (
+id serial4 NOT NULL,
+user_id int4 NOT NULL,
+family_id varchar(64) NOT NULL,
+token_hash varchar(64) NOT NULL,
+expires_at timestamptz NOT NULL,
+created_at timestamptz DEFAULT CURRENT_TIMESTAMP NOT NULL,
+used_at timestamptz NULL,
+revoked_at timestamptz NULL,
+CONSTRAINT refresh_tokens_pkey PRIMARY KEY (id),
+CONSTRAINT refresh_tokens_token_hash_key UNIQUE (token_hash),
+CONSTRAINT refresh_tokens_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX refresh_tokens_family_id_idx ON public.refresh_tokens (family_id);
@@ -0,0 +1,12 @@
+CREATE TABLE public.revoked_tokens (
+jti varchar(64) NOT NULL,
+expires_at timestamptz NOT NULL,
+CONSTRAINT revoked_tokens_pkey PRIMARY KEY (jti)
+);
+
+CREATE TABLE public.user_token_revocations (
+user_id int4 NOT NULL,
+revoked_before timestamptz NOT NULL,
+expires_at timestamptz NOT NULL,
+CONSTRAINT user_token_revocations_pkey PRIMARY KEY (user_id)
+);
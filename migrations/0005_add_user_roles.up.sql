@@ -0,0 +1,2 @@
+ALTER TABLE public.users ADD COLUMN role varchar(20) DEFAULT 'member' NOT NULL;
+ALTER TABLE public.users ADD CONSTRAINT users_role_check CHECK (role IN ('admin', 'editor', 'member'));
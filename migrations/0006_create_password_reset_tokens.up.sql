@@ -0,0 +1,11 @@
+CREATE TABLE public.password_reset_tokens (
+id serial4 NOT NULL,
+user_id int4 NOT NULL,
+token_hash varchar(64) NOT NULL,
+expires_at timestamptz NOT NULL,
+created_at timestamptz DEFAULT CURRENT_TIMESTAMP NOT NULL,
+used_at timestamptz NULL,
+CONSTRAINT password_reset_tokens_pkey PRIMARY KEY (id),
+CONSTRAINT password_reset_tokens_token_hash_key UNIQUE (token_hash),
+CONSTRAINT password_reset_tokens_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,4 @@
+ALTER TABLE public.users ADD COLUMN email_verified_at timestamptz NULL;
+
+-- Accounts created before verification existed are treated as verified.
+UPDATE public.users SET email_verified_at = created_at;
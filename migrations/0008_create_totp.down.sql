@@ -0,0 +1,3 @@
+ALTER TABLE public.refresh_tokens DROP COLUMN IF EXISTS mfa;
+DROP TABLE IF EXISTS public.recovery_codes;
+DROP TABLE IF EXISTS public.user_totp;
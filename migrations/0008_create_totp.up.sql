@@ -0,0 +1,24 @@
+CREATE TABLE public.user_totp (
+user_id int4 NOT NULL,
+secret varchar(64) NOT NULL,
+enabled_at timestamptz NULL,
+last_step int8 DEFAULT 0 NOT NULL,
+created_at timestamptz DEFAULT CURRENT_TIMESTAMP NOT NULL,
+CONSTRAINT user_totp_pkey PRIMARY KEY (user_id),
+CONSTRAINT user_totp_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE public.recovery_codes (
+id serial4 NOT NULL,
+user_id int4 NOT NULL,
+code_hash varchar(64) NOT NULL,
+created_at timestamptz DEFAULT CURRENT_TIMESTAMP NOT NULL,
+used_at timestamptz NULL,
+CONSTRAINT recovery_codes_pkey PRIMARY KEY (id),
+CONSTRAINT recovery_codes_user_id_code_hash_key UNIQUE (user_id, code_hash),
+CONSTRAINT recovery_codes_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE
+);
+
+-- Refresh tokens remember whether the session passed a second factor so
+-- rotated access tokens keep the same strength.
+ALTER TABLE public.refresh_tokens ADD COLUMN mfa bool DEFAULT false NOT NULL;
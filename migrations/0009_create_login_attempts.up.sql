@@ -0,0 +1,8 @@
+CREATE TABLE public.login_attempts (
+key varchar(320) NOT NULL,
+failures int4 NOT NULL,
+last_failed_at timestamptz DEFAULT CURRENT_TIMESTAMP NOT NULL,
+CONSTRAINT login_attempts_pkey PRIMARY KEY (key)
+);
+
+CREATE INDEX login_attempts_last_failed_at_idx ON public.login_attempts (last_failed_at);
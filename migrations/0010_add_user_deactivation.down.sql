@@ -0,0 +1,2 @@
+ALTER TABLE public.users DROP COLUMN IF EXISTS deactivation_reason;
+ALTER TABLE public.users DROP COLUMN IF EXISTS deactivated_at;
@@ -0,0 +1,4 @@
+ALTER TABLE public.users ADD COLUMN deactivated_at timestamptz NULL;
+ALTER TABLE public.users ADD COLUMN deactivation_reason text NULL;
+
+UPDATE public.users SET deactivated_at = updated_at WHERE NOT is_active;
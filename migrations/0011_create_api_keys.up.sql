@@ -0,0 +1,17 @@
+CREATE TABLE public.api_keys (
+id serial4 NOT NULL,
+user_id int4 NOT NULL,
+name varchar(100) NOT NULL,
+prefix varchar(16) NOT NULL,
+key_hash varchar(64) NOT NULL,
+scopes text[] DEFAULT '{}' NOT NULL,
+expires_at timestamptz NOT NULL,
+last_used_at timestamptz NULL,
+created_at timestamptz DEFAULT CURRENT_TIMESTAMP NOT NULL,
+revoked_at timestamptz NULL,
+CONSTRAINT api_keys_pkey PRIMARY KEY (id),
+CONSTRAINT api_keys_prefix_key UNIQUE (prefix),
+CONSTRAINT api_keys_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX api_keys_user_id_idx ON public.api_keys (user_id);
@@ -0,0 +1,15 @@
+CREATE TABLE public.sessions (
+id serial4 NOT NULL,
+user_id int4 NOT NULL,
+token_hash varchar(64) NOT NULL,
+csrf_token_hash varchar(64) NOT NULL,
+mfa bool DEFAULT false NOT NULL,
+expires_at timestamptz NOT NULL,
+created_at timestamptz DEFAULT CURRENT_TIMESTAMP NOT NULL,
+CONSTRAINT sessions_pkey PRIMARY KEY (id),
+CONSTRAINT sessions_token_hash_key UNIQUE (token_hash),
+CONSTRAINT sessions_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX sessions_user_id_idx ON public.sessions (user_id);
+CREATE INDEX sessions_expires_at_idx ON public.sessions (expires_at);
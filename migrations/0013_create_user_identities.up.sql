@@ -0,0 +1,13 @@
+CREATE TABLE public.user_identities (
+id serial4 NOT NULL,
+user_id int4 NOT NULL,
+issuer varchar(255) NOT NULL,
+subject varchar(255) NOT NULL,
+email varchar(255) NOT NULL,
+created_at timestamptz DEFAULT CURRENT_TIMESTAMP NOT NULL,
+CONSTRAINT user_identities_pkey PRIMARY KEY (id),
+CONSTRAINT user_identities_issuer_subject_key UNIQUE (issuer, subject),
+CONSTRAINT user_identities_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX user_identities_user_id_idx ON public.user_identities (user_id);
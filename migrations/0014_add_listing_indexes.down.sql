@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS public.users_created_at_id_idx;
+DROP INDEX IF EXISTS public.posts_user_id_id_idx;
+DROP INDEX IF EXISTS public.posts_title_id_idx;
+DROP INDEX IF EXISTS public.posts_created_at_id_idx;
+
+ALTER TABLE public.users ALTER COLUMN created_at DROP NOT NULL;
+ALTER TABLE public.users ALTER COLUMN created_at TYPE timestamp, ALTER COLUMN updated_at TYPE timestamp;
+ALTER TABLE public.posts DROP COLUMN IF EXISTS created_at;
@@ -0,0 +1,12 @@
+ALTER TABLE public.posts ADD COLUMN created_at timestamptz DEFAULT CURRENT_TIMESTAMP NOT NULL;
+
+-- Times filtered and paged against must keep their offset. Existing values
+-- were written in the session time zone, which the cast assumes.
+ALTER TABLE public.users ALTER COLUMN created_at TYPE timestamptz, ALTER COLUMN updated_at TYPE timestamptz;
+UPDATE public.users SET created_at = CURRENT_TIMESTAMP WHERE created_at IS NULL;
+ALTER TABLE public.users ALTER COLUMN created_at SET NOT NULL;
+
+CREATE INDEX posts_created_at_id_idx ON public.posts (created_at, id);
+CREATE INDEX posts_title_id_idx ON public.posts (title, id);
+CREATE INDEX posts_user_id_id_idx ON public.posts (user_id, id);
+CREATE INDEX users_created_at_id_idx ON public.users (created_at, id);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS public.posts_search_idx;
+ALTER TABLE public.posts DROP COLUMN IF EXISTS search;
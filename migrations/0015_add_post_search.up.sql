@@ -0,0 +1,5 @@
+ALTER TABLE public.posts ADD COLUMN search tsvector GENERATED ALWAYS AS (
+setweight(to_tsvector('english', title), 'A') || setweight(to_tsvector('english', body), 'B')
+) STORED;
+
+CREATE INDEX posts_search_idx ON public.posts USING GIN (search);
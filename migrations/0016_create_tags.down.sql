@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS public.post_tags;
+DROP TABLE IF EXISTS public.tags;
+
+DROP INDEX IF EXISTS public.posts_category_id_idx;
+ALTER TABLE public.posts DROP COLUMN IF EXISTS category;
@@ -0,0 +1,19 @@
+ALTER TABLE public.posts ADD COLUMN category varchar(50) NULL;
+CREATE INDEX posts_category_id_idx ON public.posts (category, id);
+
+CREATE TABLE public.tags (
+id serial4 NOT NULL,
+slug varchar(50) NOT NULL,
+CONSTRAINT tags_pkey PRIMARY KEY (id),
+CONSTRAINT tags_slug_key UNIQUE (slug)
+);
+
+CREATE TABLE public.post_tags (
+post_id int4 NOT NULL,
+tag_id int4 NOT NULL,
+CONSTRAINT post_tags_pkey PRIMARY KEY (post_id, tag_id),
+CONSTRAINT post_tags_post_id_fkey FOREIGN KEY (post_id) REFERENCES public.posts(id) ON DELETE CASCADE,
+CONSTRAINT post_tags_tag_id_fkey FOREIGN KEY (tag_id) REFERENCES public.tags(id) ON DELETE CASCADE
+);
+
+CREATE INDEX post_tags_tag_id_idx ON public.post_tags (tag_id);
@@ -0,0 +1,2 @@
+ALTER TABLE public.posts DROP CONSTRAINT IF EXISTS posts_user_id_fkey;
+DROP TABLE IF EXISTS public.comments;
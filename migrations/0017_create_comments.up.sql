@@ -0,0 +1,23 @@
+CREATE TABLE public.comments (
+id serial4 NOT NULL,
+post_id int4 NOT NULL,
+parent_id int4 NULL,
+user_id int4 NOT NULL,
+body text NOT NULL,
+depth int4 DEFAULT 0 NOT NULL,
+created_at timestamptz DEFAULT CURRENT_TIMESTAMP NOT NULL,
+edited_at timestamptz NULL,
+deleted_at timestamptz NULL,
+CONSTRAINT comments_pkey PRIMARY KEY (id),
+CONSTRAINT comments_post_id_fkey FOREIGN KEY (post_id) REFERENCES public.posts(id) ON DELETE CASCADE,
+CONSTRAINT comments_parent_id_fkey FOREIGN KEY (parent_id) REFERENCES public.comments(id) ON DELETE CASCADE,
+CONSTRAINT comments_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX comments_post_id_parent_id_created_at_idx ON public.comments (post_id, parent_id, created_at, id);
+CREATE INDEX comments_parent_id_idx ON public.comments (parent_id);
+
+-- Posts never had a foreign key to their author. NOT VALID enforces it for
+-- new rows without failing on posts whose author is already gone; delete
+-- those and run VALIDATE CONSTRAINT to check the rest.
+ALTER TABLE public.posts ADD CONSTRAINT posts_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE NOT VALID;
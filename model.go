@@ -11,6 +11,60 @@ type Post struct {
 	Title  string `json:"title" validate:"required,min=3"`
 	Body   string `json:"body"`
 	UserId int    `json:"userId"`
+	// Tags and Category are slugs; an empty Category means uncategorized.
+	Tags      []string  `json:"tags"`
+	Category  string    `json:"category"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// Comment is a comment on a post, or a reply to another comment. Deleted
+// comments keep their place in the thread with an empty body.
+type Comment struct {
+	ID       int    `json:"id"`
+	PostID   int    `json:"postId"`
+	ParentID *int   `json:"parentId"`
+	UserID   int    `json:"userId"`
+	Body     string `json:"body"`
+	// Depth is 0 for comments on the post and one more for each reply level.
+	Depth      int `json:"depth"`
+	ReplyCount int `json:"replyCount"`
+	// Replies is only filled in by thread listings, down to the requested
+	// depth.
+	Replies   []Comment  `json:"replies,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+	EditedAt  *time.Time `json:"editedAt"`
+	DeletedAt *time.Time `json:"deletedAt"`
+}
+
+type CreateCommentRequest struct {
+	Body     string `json:"body" validate:"required,max=10000"`
+	ParentID *int   `json:"parentId"`
+}
+
+type UpdateCommentRequest struct {
+	Body string `json:"body" validate:"required,max=10000"`
+}
+
+// Tag is a tag slug with the number of posts using it.
+type Tag struct {
+	Slug  string `json:"slug"`
+	Count int    `json:"count"`
+}
+
+// SearchResult is a post matching a search, with a snippet of its body.
+type SearchResult struct {
+	Post
+	Rank float32 `json:"rank"`
+	// Headline is HTML with the matching words wrapped in <mark>.
+	Headline string `json:"headline"`
+}
+
+// Page is one page of a listing. The cursors are opaque and only set when
+// there are more results in that direction.
+type Page[T any] struct {
+	Data       []T    `json:"data"`
+	NextCursor string `json:"nextCursor,omitempty"`
+	PrevCursor string `json:"prevCursor,omitempty"`
 }
 
 type Success struct {
@@ -19,18 +73,116 @@ type Success struct {
 }
 
 type User struct {
-	ID        int       `json:"id"`
-	Username  string    `json:"username" validate:"required,min=3"`
-	Email     string    `json:"email" validate:"required,email"`
-	Password  string    `json:"password" validate:"required,min=6"`
-	IsActive  bool      `json:"isActive" validate:"required"`
-	CreatedAt time.Time `json:"createdAt"`
-	UpdatedAt time.Time `json:"updatedAt"`
+	ID       int    `json:"id"`
+	Username string `json:"username" validate:"required,min=3"`
+	Email    string `json:"email" validate:"required,email"`
+	Password string `json:"password" validate:"required"`
+	IsActive bool   `json:"isActive" validate:"required"`
+	Role     Role   `json:"role" validate:"omitempty,oneof=admin editor member"`
+	// EmailVerifiedAt is nil until the user follows the verification link.
+	EmailVerifiedAt *time.Time `json:"emailVerifiedAt"`
+	// DeactivatedAt and DeactivationReason are only set while IsActive is
+	// false.
+	DeactivatedAt      *time.Time `json:"deactivatedAt"`
+	DeactivationReason *string    `json:"deactivationReason"`
+	CreatedAt          time.Time  `json:"createdAt"`
+	UpdatedAt          time.Time  `json:"updatedAt"`
+}
+
+// UserPatch holds the fields of a user that PATCH /user may change.
+type UserPatch struct {
+	Username string `json:"username" validate:"required,min=3"`
+	Email    string `json:"email" validate:"required,email"`
+	IsActive bool   `json:"isActive"`
+	Role     Role   `json:"role" validate:"required,oneof=admin editor member"`
+}
+
+// userPatchFields maps UserPatch JSON names to struct field names for
+// partial validation.
+var userPatchFields = map[string]string{
+	"username": "Username",
+	"email":    "Email",
+	"isActive": "IsActive",
+	"role":     "Role",
+}
+
+type DeactivateRequest struct {
+	Reason string `json:"reason" validate:"required,max=500"`
+}
+
+type PasswordChange struct {
+	CurrentPassword string `json:"currentPassword" validate:"required"`
+	NewPassword     string `json:"newPassword" validate:"required"`
+}
+
+// ProfileUpdate is the body of PATCH /me. Nil fields are left unchanged.
+type ProfileUpdate struct {
+	Username *string `json:"username" validate:"omitempty,min=3"`
+	Email    *string `json:"email" validate:"omitempty,email"`
 }
 
 type UserClaims struct {
 	ID       int    `json:"id"`
 	Username string `json:"username"`
+	Role     Role   `json:"role"`
+	// MFA is set when the session passed a second factor at login.
+	MFA bool `json:"mfa,omitempty"`
+	// Scopes, when not nil, limits the role's permissions to those listed.
+	// Only API keys set it.
+	Scopes []Permission `json:"scopes,omitempty"`
+	// APIKeyID is the key the request authenticated with, or zero for a
+	// session token. It is never part of a JWT.
+	APIKeyID int `json:"-"`
+	// SessionID is the cookie session the request authenticated with, or
+	// zero for a bearer token. It is never part of a JWT.
+	SessionID int `json:"-"`
+
+	jwt.RegisteredClaims
+}
+
+// APIKey is a long-lived credential for service-to-service calls. Only its
+// hash is stored; Prefix identifies it for lookup and in listings.
+type APIKey struct {
+	ID         int          `json:"id"`
+	UserID     int          `json:"userId"`
+	Name       string       `json:"name"`
+	Prefix     string       `json:"prefix"`
+	KeyHash    string       `json:"-"`
+	Scopes     []Permission `json:"scopes"`
+	ExpiresAt  time.Time    `json:"expiresAt"`
+	LastUsedAt *time.Time   `json:"lastUsedAt"`
+	CreatedAt  time.Time    `json:"createdAt"`
+	RevokedAt  *time.Time   `json:"revokedAt"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name   string       `json:"name" validate:"required,max=100"`
+	Scopes []Permission `json:"scopes" validate:"required,min=1,dive,required"`
+	// ExpiresAt defaults to api_key_max_ttl from now.
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// CreatedAPIKey is the only response that includes the key itself.
+type CreatedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+type Signup struct {
+	Username string `json:"username" validate:"required,min=3"`
+	Email    string `json:"email" validate:"required,email"`
+	Password string `json:"password" validate:"required"`
+}
+
+type ResendVerificationRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+// EmailVerificationClaims are carried by the token in a verification link.
+// Their audience is not the access token audience, so AuthMiddleware never
+// accepts them as access tokens.
+type EmailVerificationClaims struct {
+	Email string `json:"email"`
 
 	jwt.RegisteredClaims
 }
@@ -38,4 +190,160 @@ type UserClaims struct {
 type UserLogin struct {
 	Username string `json:"username" validate:"required,min=3"`
 	Password string `json:"password" validate:"required,min=6"`
+	// Session asks for a session cookie instead of tokens.
+	Session bool `json:"session"`
+}
+
+type RefreshToken struct {
+	ID        int
+	UserID    int
+	FamilyID  string
+	TokenHash string
+	// MFA records that the session passed a second factor at login.
+	MFA       bool
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+}
+
+// TOTPSecret is a user's authenticator secret. It is pending until the user
+// confirms it with a valid code, which sets EnabledAt.
+type TOTPSecret struct {
+	UserID    int
+	Secret    string
+	EnabledAt *time.Time
+	// LastStep is the most recent time step accepted, so a code cannot be
+	// used twice.
+	LastStep  int64
+	CreatedAt time.Time
+}
+
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauthUri"`
+}
+
+type TOTPCode struct {
+	Code string `json:"code" validate:"required,len=6,numeric"`
+}
+
+type RecoveryCodes struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+type DisableTOTPRequest struct {
+	Password     string `json:"password" validate:"required"`
+	Code         string `json:"code" validate:"required_without=RecoveryCode,omitempty,len=6,numeric"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+// MFAChallenge is returned by /login instead of tokens when the user has
+// two-factor authentication enabled.
+type MFAChallenge struct {
+	MFARequired bool   `json:"mfaRequired"`
+	MFAToken    string `json:"mfaToken"`
+	ExpiresIn   int    `json:"expiresIn"`
+}
+
+type MFALogin struct {
+	MFAToken     string `json:"mfaToken" validate:"required"`
+	Code         string `json:"code" validate:"required_without=RecoveryCode,omitempty,len=6,numeric"`
+	RecoveryCode string `json:"recoveryCode"`
+	Session      bool   `json:"session"`
+}
+
+// MFAChallengeClaims prove the password step of a login. Their audience is
+// not the access token audience, so they are never accepted as access tokens.
+type MFAChallengeClaims struct {
+	jwt.RegisteredClaims
+}
+
+type LoginAttempts struct {
+	Key          string    `json:"-"`
+	Failures     int       `json:"failures"`
+	LastFailedAt time.Time `json:"lastFailedAt"`
+}
+
+// LoginLockout is the throttling state of one user reported to admins.
+type LoginLockout struct {
+	UserID       int        `json:"userId"`
+	Failures     int        `json:"failures"`
+	LastFailedAt *time.Time `json:"lastFailedAt"`
+	LockedUntil  *time.Time `json:"lockedUntil"`
+}
+
+// Identity links a user to an account at the OIDC provider.
+type Identity struct {
+	ID        int
+	UserID    int
+	Issuer    string
+	Subject   string
+	Email     string
+	CreatedAt time.Time
+}
+
+// OIDCFlowClaims carry the state, nonce and PKCE verifier of a login in
+// progress in a cookie. Like verification tokens they have their own
+// audience, so they are never accepted as access tokens.
+type OIDCFlowClaims struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	// Session asks for a session cookie instead of tokens at the end.
+	Session bool `json:"session,omitempty"`
+
+	jwt.RegisteredClaims
+}
+
+type PasswordResetToken struct {
+	ID        int
+	UserID    int
+	TokenHash string
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	UsedAt    *time.Time
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token" validate:"required"`
+	NewPassword string `json:"newPassword" validate:"required"`
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refreshToken" validate:"required"`
+}
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refreshToken"`
+}
+
+// Session is a server-side login session for browsers, identified by the
+// hash of its cookie value.
+type Session struct {
+	ID            int
+	UserID        int
+	TokenHash     string
+	CSRFTokenHash string
+	// MFA records that the session passed a second factor at login.
+	MFA       bool
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// SessionResponse is returned by /login instead of tokens when a session
+// cookie was requested. CSRFToken must be echoed in the X-CSRF-Token header.
+type SessionResponse struct {
+	CSRFToken string `json:"csrfToken"`
+	ExpiresIn int    `json:"expiresIn"`
+}
+
+type TokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refreshToken"`
+	ExpiresIn    int    `json:"expiresIn"`
 }
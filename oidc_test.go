@@ -0,0 +1,550 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testOIDCClientID     = "go-rest"
+	testOIDCClientSecret = "client secret"
+)
+
+// fakeIdP is a stand-in OpenID Connect provider. Its authorization endpoint
+// logs in the account set with logIn, without asking.
+type fakeIdP struct {
+	srv *httptest.Server
+
+	mu sync.Mutex
+	// keys are published in the JWKS; the last one signs ID tokens.
+	keys  []fakeIdPKey
+	codes map[string]fakeIdPCode
+	next  fakeIdPLogin
+}
+
+type fakeIdPKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	private crypto.Signer
+}
+
+type fakeIdPCode struct {
+	challenge   string
+	nonce       string
+	redirectURI string
+	login       fakeIdPLogin
+}
+
+// fakeIdPLogin is the account that logs in at the provider. Audience and
+// Nonce, when set, replace the right values in the ID token.
+type fakeIdPLogin struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Username      string
+	AMR           []string
+	Audience      []string
+	Nonce         string
+}
+
+func newFakeIdP(t *testing.T, key fakeIdPKey) *fakeIdP {
+	t.Helper()
+
+	idp := &fakeIdP{keys: []fakeIdPKey{key}, codes: make(map[string]fakeIdPCode)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		CustomJsonResponse(w, http.StatusOK, OIDCMetadata{
+			Issuer:                idp.srv.URL,
+			AuthorizationEndpoint: idp.srv.URL + "/authorize",
+			TokenEndpoint:         idp.srv.URL + "/token",
+			JWKSURI:               idp.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /authorize", idp.authorize)
+	mux.HandleFunc("POST /token", idp.token)
+	mux.HandleFunc("GET /jwks", idp.jwks)
+
+	idp.srv = httptest.NewServer(mux)
+	t.Cleanup(idp.srv.Close)
+	return idp
+}
+
+func newRSAKey(t *testing.T, kid string) fakeIdPKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fakeIdPKey{kid: kid, method: jwt.SigningMethodRS256, private: key}
+}
+
+func newECKey(t *testing.T, kid string) fakeIdPKey {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fakeIdPKey{kid: kid, method: jwt.SigningMethodES256, private: key}
+}
+
+func newEd25519Key(t *testing.T, kid string) fakeIdPKey {
+	t.Helper()
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fakeIdPKey{kid: kid, method: jwt.SigningMethodEdDSA, private: key}
+}
+
+// rotate publishes only key from now on and signs with it.
+func (idp *fakeIdP) rotate(key fakeIdPKey) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+
+	idp.keys = []fakeIdPKey{key}
+}
+
+// logIn sets the account the next login authenticates as.
+func (idp *fakeIdP) logIn(login fakeIdPLogin) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+
+	idp.next = login
+}
+
+func (idp *fakeIdP) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("response_type") != "code" || query.Get("client_id") != testOIDCClientID || query.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+
+	code, _, err := generateOpaqueToken()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	idp.mu.Lock()
+	idp.codes[code] = fakeIdPCode{
+		challenge:   query.Get("code_challenge"),
+		nonce:       query.Get("nonce"),
+		redirectURI: query.Get("redirect_uri"),
+		login:       idp.next,
+	}
+	idp.mu.Unlock()
+
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+	params := redirect.Query()
+	params.Set("code", code)
+	params.Set("state", query.Get("state"))
+	redirect.RawQuery = params.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (idp *fakeIdP) token(w http.ResponseWriter, r *http.Request) {
+	tokenError := func(code string) {
+		CustomJsonResponse(w, http.StatusBadRequest, map[string]string{"error": code})
+	}
+
+	clientID, secret, ok := r.BasicAuth()
+	if !ok || clientID != url.QueryEscape(testOIDCClientID) || secret != url.QueryEscape(testOIDCClientSecret) {
+		CustomJsonResponse(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	if r.PostFormValue("grant_type") != "authorization_code" {
+		tokenError("unsupported_grant_type")
+		return
+	}
+
+	idp.mu.Lock()
+	code, ok := idp.codes[r.PostFormValue("code")]
+	delete(idp.codes, r.PostFormValue("code"))
+	key := idp.keys[len(idp.keys)-1]
+	idp.mu.Unlock()
+
+	if !ok || code.redirectURI != r.PostFormValue("redirect_uri") || pkceChallenge(r.PostFormValue("code_verifier")) != code.challenge {
+		tokenError("invalid_grant")
+		return
+	}
+
+	login := code.login
+	audience := login.Audience
+	if audience == nil {
+		audience = []string{testOIDCClientID}
+	}
+	nonce := code.nonce
+	if login.Nonce != "" {
+		nonce = login.Nonce
+	}
+
+	now := time.Now()
+	idToken := jwt.NewWithClaims(key.method, OIDCIDTokenClaims{
+		Nonce:             nonce,
+		Email:             login.Email,
+		EmailVerified:     login.EmailVerified,
+		PreferredUsername: login.Username,
+		AMR:               login.AMR,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    idp.srv.URL,
+			Subject:   login.Subject,
+			Audience:  audience,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(5 * time.Minute)),
+		},
+	})
+	idToken.Header["kid"] = key.kid
+	signed, err := idToken.SignedString(key.private)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	CustomJsonResponse(w, http.StatusOK, map[string]string{"id_token": signed, "token_type": "Bearer"})
+}
+
+func (idp *fakeIdP) jwks(w http.ResponseWriter, r *http.Request) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+
+	encode := base64.RawURLEncoding.EncodeToString
+	set := JWKSet{Keys: make([]JWK, 0, len(idp.keys))}
+	for _, key := range idp.keys {
+		jwk := JWK{Use: "sig", Alg: key.method.Alg(), Kid: key.kid}
+		switch pub := key.private.Public().(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = encode(pub.N.Bytes())
+			jwk.E = encode(big.NewInt(int64(pub.E)).Bytes())
+		case *ecdsa.PublicKey:
+			size := (pub.Curve.Params().BitSize + 7) / 8
+			jwk.Kty = "EC"
+			jwk.Crv = pub.Curve.Params().Name
+			jwk.X = encode(pub.X.FillBytes(make([]byte, size)))
+			jwk.Y = encode(pub.Y.FillBytes(make([]byte, size)))
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = encode(pub)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	CustomJsonResponse(w, http.StatusOK, set)
+}
+
+// newOIDCTestServer returns a server that logs in through idp.
+func newOIDCTestServer(t *testing.T, idp *fakeIdP, configure func(cfg *Config)) *testServer {
+	t.Helper()
+
+	cfg := testConfig()
+	cfg.OIDCIssuer = idp.srv.URL
+	cfg.OIDCClientID = testOIDCClientID
+	cfg.OIDCClientSecret = testOIDCClientSecret
+	if configure != nil {
+		configure(cfg)
+	}
+	return newTestServerWithConfig(t, cfg)
+}
+
+// oidcLogin plays the browser through a login at idp and returns the
+// callback's response. tamper may change the callback query.
+func (ts *testServer) oidcLogin(tamper func(query url.Values)) *http.Response {
+	ts.t.Helper()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	get := func(rawURL string, cookies ...*http.Cookie) *http.Response {
+		ts.t.Helper()
+
+		req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+		if err != nil {
+			ts.t.Fatal(err)
+		}
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			ts.t.Fatal(err)
+		}
+		ts.t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	start := get(ts.srv.URL + "/login/oidc")
+	expectStatus(ts.t, start, http.StatusFound)
+	var flow *http.Cookie
+	for _, cookie := range start.Cookies() {
+		if cookie.Name == oidcFlowCookieName {
+			flow = cookie
+		}
+	}
+	if flow == nil {
+		ts.t.Fatal("login did not set the flow cookie")
+	}
+
+	authorize := get(start.Header.Get("Location"))
+	expectStatus(ts.t, authorize, http.StatusFound)
+	callback, err := url.Parse(authorize.Header.Get("Location"))
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+
+	query := callback.Query()
+	if tamper != nil {
+		tamper(query)
+	}
+	return get(ts.srv.URL+"/login/oidc/callback?"+query.Encode(), flow)
+}
+
+// me returns the user a token belongs to.
+func (ts *testServer) me(token string) User {
+	ts.t.Helper()
+	return decodeResponse[User](ts.t, ts.do(http.MethodGet, "/me", token, nil), http.StatusOK)
+}
+
+func TestOIDCLogin(t *testing.T) {
+	keys := map[string]func(t *testing.T, kid string) fakeIdPKey{
+		"RSA":     newRSAKey,
+		"EC":      newECKey,
+		"Ed25519": newEd25519Key,
+	}
+	for name, newKey := range keys {
+		t.Run(name, func(t *testing.T) {
+			idp := newFakeIdP(t, newKey(t, "key-1"))
+			ts := newOIDCTestServer(t, idp, nil)
+			idp.logIn(fakeIdPLogin{Subject: "sub-alice", Email: "alice@example.com", EmailVerified: true, Username: "alice"})
+
+			tokens := decodeResponse[TokenResponse](t, ts.oidcLogin(nil), http.StatusOK)
+			user := ts.me(tokens.Token)
+			if user.Username != "alice" || user.Email != "alice@example.com" || user.Role != RoleMember || user.EmailVerifiedAt == nil {
+				t.Errorf("provisioned user = %+v, want a verified member alice", user)
+			}
+
+			identity, err := ts.Identities.Get(context.Background(), idp.srv.URL, "sub-alice")
+			if err != nil || identity.UserID != user.ID {
+				t.Errorf("identity = %+v, %v, want a link to user %d", identity, err, user.ID)
+			}
+
+			// The next login finds the linked user, even with a new email.
+			idp.logIn(fakeIdPLogin{Subject: "sub-alice", Email: "alice@new.example.com", EmailVerified: true, Username: "alice"})
+			tokens = decodeResponse[TokenResponse](t, ts.oidcLogin(nil), http.StatusOK)
+			if again := ts.me(tokens.Token); again.ID != user.ID {
+				t.Errorf("second login got user %d, want %d", again.ID, user.ID)
+			}
+		})
+	}
+}
+
+func TestOIDCLoginRejectsInvalidResponses(t *testing.T) {
+	idp := newFakeIdP(t, newRSAKey(t, "key-1"))
+	ts := newOIDCTestServer(t, idp, nil)
+	alice := fakeIdPLogin{Subject: "sub-alice", Email: "alice@example.com", EmailVerified: true, Username: "alice"}
+
+	tests := []struct {
+		name   string
+		login  func(login *fakeIdPLogin)
+		tamper func(query url.Values)
+		want   int
+	}{
+		{name: "nonce mismatch", login: func(l *fakeIdPLogin) { l.Nonce = "another nonce" }, want: http.StatusUnauthorized},
+		{name: "wrong audience", login: func(l *fakeIdPLogin) { l.Audience = []string{"another-client"} }, want: http.StatusUnauthorized},
+		{name: "extra audience without azp", login: func(l *fakeIdPLogin) { l.Audience = []string{testOIDCClientID, "another-client"} }, want: http.StatusUnauthorized},
+		{name: "missing subject", login: func(l *fakeIdPLogin) { l.Subject = "" }, want: http.StatusUnauthorized},
+		{name: "state mismatch", tamper: func(q url.Values) { q.Set("state", "forged") }, want: http.StatusBadRequest},
+		{name: "unknown code", tamper: func(q url.Values) { q.Set("code", "forged") }, want: http.StatusUnauthorized},
+		{name: "provider error", tamper: func(q url.Values) { q.Set("error", "access_denied") }, want: http.StatusUnauthorized},
+		{name: "unverified provider email", login: func(l *fakeIdPLogin) { l.EmailVerified = false }, want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			login := alice
+			if tt.login != nil {
+				tt.login(&login)
+			}
+			idp.logIn(login)
+
+			expectStatus(t, ts.oidcLogin(tt.tamper), tt.want)
+		})
+	}
+
+	if _, err := ts.Users.GetByEmail(context.Background(), alice.Email); err == nil {
+		t.Error("a rejected login provisioned a user")
+	}
+}
+
+func TestOIDCKeyRotation(t *testing.T) {
+	idp := newFakeIdP(t, newRSAKey(t, "key-1"))
+	ts := newOIDCTestServer(t, idp, nil)
+	idp.logIn(fakeIdPLogin{Subject: "sub-alice", Email: "alice@example.com", EmailVerified: true, Username: "alice"})
+
+	expectStatus(t, ts.oidcLogin(nil), http.StatusOK)
+
+	// Right after a fetch an unknown kid does not fetch the keys again.
+	idp.rotate(newECKey(t, "key-2"))
+	expectStatus(t, ts.oidcLogin(nil), http.StatusUnauthorized)
+
+	ts.OIDC.mu.Lock()
+	ts.OIDC.keysFetchedAt = time.Now().Add(-oidcJWKSRefreshInterval)
+	ts.OIDC.mu.Unlock()
+	expectStatus(t, ts.oidcLogin(nil), http.StatusOK)
+}
+
+func TestOIDCAccountLinking(t *testing.T) {
+	idp := newFakeIdP(t, newRSAKey(t, "key-1"))
+	ts := newOIDCTestServer(t, idp, nil)
+
+	verified := ts.createUser("alice", RoleEditor)
+	idp.logIn(fakeIdPLogin{Subject: "sub-alice", Email: verified.Email, EmailVerified: true, Username: "alice-sso"})
+	tokens := decodeResponse[TokenResponse](t, ts.oidcLogin(nil), http.StatusOK)
+	if user := ts.me(tokens.Token); user.ID != verified.ID || user.Role != RoleEditor {
+		t.Errorf("login got user %+v, want the existing user %d", user, verified.ID)
+	}
+
+	// Linking to an address nobody proved would hand the account to
+	// whoever signed up with it first.
+	unverified := ts.createUser("bob", RoleMember)
+	if err := ts.Users.SetEmailVerified(context.Background(), unverified.ID, nil); err != nil {
+		t.Fatal(err)
+	}
+	idp.logIn(fakeIdPLogin{Subject: "sub-bob", Email: unverified.Email, EmailVerified: true, Username: "bob"})
+	expectStatus(t, ts.oidcLogin(nil), http.StatusConflict)
+	if _, err := ts.Identities.Get(context.Background(), idp.srv.URL, "sub-bob"); err == nil {
+		t.Error("identity was linked to an unverified user")
+	}
+
+	// A taken username gets a number.
+	idp.logIn(fakeIdPLogin{Subject: "sub-carol", Email: "carol@example.com", EmailVerified: true, Username: "alice"})
+	tokens = decodeResponse[TokenResponse](t, ts.oidcLogin(nil), http.StatusOK)
+	if user := ts.me(tokens.Token); user.Username != "alice2" {
+		t.Errorf("provisioned username = %q, want alice2", user.Username)
+	}
+}
+
+func TestOIDCEnforcedDomains(t *testing.T) {
+	idp := newFakeIdP(t, newRSAKey(t, "key-1"))
+	ts := newOIDCTestServer(t, idp, func(cfg *Config) {
+		cfg.OIDCEnforcedDomains = []string{"corp.example"}
+	})
+
+	hash, err := ts.Passwords.Hash(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	alice := User{Username: "alice", Email: "alice@CORP.example", Password: hash, IsActive: true, Role: RoleMember, EmailVerifiedAt: &now}
+	if err := ts.Users.Create(context.Background(), &alice); err != nil {
+		t.Fatal(err)
+	}
+	ts.createUser("bob", RoleMember)
+
+	login := func(username string) *http.Response {
+		return ts.do(http.MethodPost, "/login", "", UserLogin{Username: username, Password: testPassword})
+	}
+	expectStatus(t, login("alice"), http.StatusForbidden)
+	expectStatus(t, login("bob"), http.StatusOK)
+
+	signup := Signup{Username: "carol", Email: "carol@corp.example", Password: "a long password"}
+	expectStatus(t, ts.do(http.MethodPost, "/signup", "", signup), http.StatusForbidden)
+
+	idp.logIn(fakeIdPLogin{Subject: "sub-alice", Email: alice.Email, EmailVerified: true, Username: "alice"})
+	tokens := decodeResponse[TokenResponse](t, ts.oidcLogin(nil), http.StatusOK)
+	if user := ts.me(tokens.Token); user.ID != alice.ID {
+		t.Errorf("single sign-on got user %d, want %d", user.ID, alice.ID)
+	}
+}
+
+func TestJWKPublicKey(t *testing.T) {
+	tests := []struct {
+		name string
+		jwk  JWK
+	}{
+		{"unknown key type", JWK{Kty: "oct"}},
+		{"unknown curve", JWK{Kty: "EC", Crv: "P-192", X: "AA", Y: "AA"}},
+		{"point off the curve", JWK{Kty: "EC", Crv: "P-256", X: "AQ", Y: "AQ"}},
+		{"short Ed25519 key", JWK{Kty: "OKP", Crv: "Ed25519", X: "AQID"}},
+		{"empty RSA modulus", JWK{Kty: "RSA", E: "AQAB"}},
+		{"bad base64", JWK{Kty: "RSA", N: "!!", E: "AQAB"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if key, err := tt.jwk.PublicKey(); err == nil {
+				t.Errorf("PublicKey() = %v, want an error", key)
+			}
+		})
+	}
+}
+
+func TestOIDCDiscoveryIssuerMismatch(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(OIDCMetadata{
+			Issuer:                "https://idp.example.com",
+			AuthorizationEndpoint: "https://idp.example.com/authorize",
+			TokenEndpoint:         "https://idp.example.com/token",
+			JWKSURI:               "https://idp.example.com/jwks",
+		})
+	}))
+	t.Cleanup(srv.Close)
+
+	provider := &OIDCProvider{Issuer: srv.URL, ClientID: testOIDCClientID, HTTPClient: srv.Client()}
+	if _, err := provider.Metadata(context.Background()); err == nil {
+		t.Error("Metadata() accepted a document naming another issuer")
+	}
+}
+
+func TestOIDCLoginRequiresLocalTOTP(t *testing.T) {
+	idp := newFakeIdP(t, newRSAKey(t, "key-1"))
+	ts := newOIDCTestServer(t, idp, nil)
+	alice := ts.createUser("alice", RoleMember)
+	secret, _ := ts.enableTOTP(alice)
+
+	// Linking by email must not let the provider account skip alice's own
+	// second factor.
+	idp.logIn(fakeIdPLogin{Subject: "sub-alice", Email: alice.Email, EmailVerified: true, Username: "alice"})
+	challenge := decodeResponse[MFAChallenge](t, ts.oidcLogin(nil), http.StatusOK)
+	if !challenge.MFARequired || challenge.MFAToken == "" {
+		t.Fatalf("login without the provider's MFA returned %+v, want a challenge", challenge)
+	}
+
+	code, err := totpCode(secret, totpStep(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := ts.do(http.MethodPost, "/login/mfa", "", MFALogin{MFAToken: challenge.MFAToken, Code: code})
+	tokens := decodeResponse[TokenResponse](t, resp, http.StatusOK)
+	if user := ts.me(tokens.Token); user.ID != alice.ID {
+		t.Errorf("challenge logged in user %d, want %d", user.ID, alice.ID)
+	}
+
+	// The provider's own second factor stands in for ours.
+	idp.logIn(fakeIdPLogin{Subject: "sub-alice", Email: alice.Email, EmailVerified: true, Username: "alice", AMR: []string{"pwd", "mfa"}})
+	tokens = decodeResponse[TokenResponse](t, ts.oidcLogin(nil), http.StatusOK)
+	if tokens.Token == "" {
+		t.Errorf("login with the provider's MFA returned %+v, want tokens", tokens)
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrUnsupportedHash is returned by a hasher asked to verify a hash made by
+// another algorithm.
+var ErrUnsupportedHash = errors.New("unsupported password hash format")
+
+// PasswordHasher is one password hashing algorithm.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	// Verify reports whether password matches encoded, or returns
+	// ErrUnsupportedHash if encoded is not in this hasher's format.
+	Verify(password, encoded string) (bool, error)
+	// NeedsRehash reports whether encoded differs from what Hash produces
+	// now, in algorithm or in parameters.
+	NeedsRehash(encoded string) bool
+}
+
+// Argon2idHasher encodes hashes in the PHC string format,
+// $argon2id$v=19$m=<KiB>,t=<iterations>,p=<parallelism>$<salt>$<key>.
+type Argon2idHasher struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+func (h *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, argon2KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h *Argon2idHasher) Verify(password, encoded string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	other := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
+
+func (h *Argon2idHasher) NeedsRehash(encoded string) bool {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return *params != *h || len(salt) != argon2SaltLength || len(key) != argon2KeyLength
+}
+
+func decodeArgon2id(encoded string) (*Argon2idHasher, []byte, []byte, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return nil, nil, nil, ErrUnsupportedHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, nil, nil, fmt.Errorf("unsupported argon2 version %q", parts[2])
+	}
+
+	params := &Argon2idHasher{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid argon2 parameters %q", parts[3])
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid argon2 salt: %w", err)
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("invalid argon2 key: %w", err)
+	}
+
+	return params, salt, key, nil
+}
+
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h *BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (h *BcryptHasher) Verify(password, encoded string) (bool, error) {
+	if !strings.HasPrefix(encoded, "$2") {
+		return false, ErrUnsupportedHash
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (h *BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != h.Cost
+}
+
+// Passwords hashes new passwords with the configured algorithm and verifies
+// hashes made by any supported one, so stored hashes can be upgraded one
+// login at a time.
+type Passwords struct {
+	current PasswordHasher
+	all     []PasswordHasher
+	dummy   func() string
+}
+
+func NewPasswords(cfg *Config) *Passwords {
+	argon2id := &Argon2idHasher{
+		Memory:      uint32(cfg.Argon2Memory),
+		Iterations:  uint32(cfg.Argon2Iterations),
+		Parallelism: uint8(cfg.Argon2Parallelism),
+	}
+	bcryptHasher := &BcryptHasher{Cost: cfg.BcryptCost}
+
+	p := &Passwords{all: []PasswordHasher{argon2id, bcryptHasher}}
+	switch cfg.PasswordHasher {
+	case "bcrypt":
+		p.current = bcryptHasher
+	default:
+		p.current = argon2id
+	}
+
+	p.dummy = sync.OnceValue(func() string {
+		hash, _ := p.current.Hash("dummy password")
+		return hash
+	})
+
+	return p
+}
+
+func (p *Passwords) Hash(password string) (string, error) {
+	return p.current.Hash(password)
+}
+
+// Verify reports whether password matches encoded and, if it does, whether
+// encoded should be replaced by a fresh hash.
+func (p *Passwords) Verify(password, encoded string) (ok, rehash bool, err error) {
+	for _, hasher := range p.all {
+		ok, err := hasher.Verify(password, encoded)
+		if errors.Is(err, ErrUnsupportedHash) {
+			continue
+		}
+		if err != nil || !ok {
+			return false, false, err
+		}
+		return true, p.current.NeedsRehash(encoded), nil
+	}
+	return false, false, ErrUnsupportedHash
+}
+
+// VerifyDummy spends as long as a real check, for usernames that do not
+// exist.
+func (p *Passwords) VerifyDummy(password string) {
+	p.Verify(password, p.dummy())
+}
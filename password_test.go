@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestChangePasswordRevokesTokens(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.createUser("alice", RoleMember)
+	tokens := ts.login("alice")
+	earlier := ts.accessToken(alice, false, time.Now().Add(-time.Minute))
+	other := ts.accessToken(alice, false, time.Now().Add(-time.Minute))
+
+	wrong := PasswordChange{CurrentPassword: "wrong password", NewPassword: "a new password"}
+	expectStatus(t, ts.do(http.MethodPost, "/user/password", earlier, wrong), http.StatusUnauthorized)
+
+	change := PasswordChange{CurrentPassword: testPassword, NewPassword: "a new password"}
+	expectStatus(t, ts.do(http.MethodPost, "/user/password", earlier, change), http.StatusOK)
+
+	expectStatus(t, ts.do(http.MethodGet, "/me", earlier, nil), http.StatusUnauthorized)
+	expectStatus(t, ts.do(http.MethodGet, "/me", other, nil), http.StatusUnauthorized)
+	expectStatus(t, ts.do(http.MethodPost, "/token/refresh", "", RefreshRequest{RefreshToken: tokens.RefreshToken}), http.StatusUnauthorized)
+
+	expectStatus(t, ts.do(http.MethodPost, "/login", "", UserLogin{Username: "alice", Password: testPassword}), http.StatusUnauthorized)
+	resp := ts.do(http.MethodPost, "/login", "", UserLogin{Username: "alice", Password: "a new password"})
+	fresh := decodeResponse[TokenResponse](t, resp, http.StatusOK)
+	expectStatus(t, ts.do(http.MethodGet, "/me", fresh.Token, nil), http.StatusOK)
+}
+
+func TestForgotPassword(t *testing.T) {
+	tests := []struct {
+		name     string
+		resetURL string
+		// token extracts the reset token from the email body.
+		token func(t *testing.T, body string) string
+	}{
+		{
+			name:     "reset page",
+			resetURL: "https://app.example.com/reset?lang=en",
+			token: func(t *testing.T, body string) string {
+				match := regexp.MustCompile(`https://app\.example\.com/reset\?\S+`).FindString(body)
+				link, err := url.Parse(match)
+				if err != nil || link.Query().Get("lang") != "en" {
+					t.Fatalf("email has no link to the reset page: %q", body)
+				}
+				return link.Query().Get("token")
+			},
+		},
+		{
+			name: "no reset page",
+			token: func(t *testing.T, body string) string {
+				if strings.Contains(body, "http") {
+					t.Errorf("email links to a page that does not exist: %q", body)
+				}
+				// The token is on a line of its own.
+				for _, line := range strings.Split(body, "\n") {
+					if line != "" && !strings.Contains(line, " ") {
+						return line
+					}
+				}
+				return ""
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.Config.PasswordResetURL = tt.resetURL
+			alice := ts.createUser("alice", RoleMember)
+
+			resp := ts.do(http.MethodPost, "/password/forgot", "", ForgotPasswordRequest{Email: alice.Email})
+			expectStatus(t, resp, http.StatusAccepted)
+
+			token := tt.token(t, ts.mailer.last(t).Body)
+			if token == "" {
+				t.Fatal("email has no reset token")
+			}
+
+			reset := ResetPasswordRequest{Token: token, NewPassword: "a new password"}
+			expectStatus(t, ts.do(http.MethodPost, "/password/reset", "", reset), http.StatusOK)
+			expectStatus(t, ts.do(http.MethodPost, "/password/reset", "", reset), http.StatusBadRequest)
+			expectStatus(t, ts.do(http.MethodPost, "/login", "", UserLogin{Username: "alice", Password: "a new password"}), http.StatusOK)
+		})
+	}
+}
+
+func TestPasswordsVerify(t *testing.T) {
+	cfg := testConfig()
+	cfg.Argon2Memory, cfg.Argon2Iterations, cfg.Argon2Parallelism = 64, 1, 1
+	bcryptHash, err := NewPasswords(cfg).Hash(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.PasswordHasher = "argon2id"
+	passwords := NewPasswords(cfg)
+	argon2Hash, err := passwords.Hash(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(argon2Hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Fatalf("Hash() = %q, want an argon2id hash with the configured parameters", argon2Hash)
+	}
+
+	cfg.Argon2Iterations = 2
+	stronger := NewPasswords(cfg)
+
+	tests := []struct {
+		name      string
+		passwords *Passwords
+		password  string
+		hash      string
+		ok        bool
+		rehash    bool
+	}{
+		{"current algorithm", passwords, testPassword, argon2Hash, true, false},
+		{"older algorithm", passwords, testPassword, bcryptHash, true, true},
+		{"older parameters", stronger, testPassword, argon2Hash, true, true},
+		{"wrong password", passwords, "wrong password", argon2Hash, false, false},
+		{"wrong password for an older algorithm", passwords, "wrong password", bcryptHash, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, rehash, err := tt.passwords.Verify(tt.password, tt.hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.ok || rehash != tt.rehash {
+				t.Errorf("Verify() = %v, %v, want %v, %v", ok, rehash, tt.ok, tt.rehash)
+			}
+		})
+	}
+
+	if _, _, err := passwords.Verify(testPassword, "plain text"); !errors.Is(err, ErrUnsupportedHash) {
+		t.Errorf("Verify() of an unknown format: got %v, want ErrUnsupportedHash", err)
+	}
+}
+
+func TestLoginRehashesPassword(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.createUser("alice", RoleMember)
+	if !strings.HasPrefix(alice.Password, "$2") {
+		t.Fatalf("created user has hash %q, want bcrypt", alice.Password)
+	}
+
+	cfg := testConfig()
+	cfg.PasswordHasher = "argon2id"
+	cfg.Argon2Memory, cfg.Argon2Iterations, cfg.Argon2Parallelism = 64, 1, 1
+	ts.Passwords = NewPasswords(cfg)
+
+	stored := func() string {
+		t.Helper()
+		user, err := ts.Users.GetByUsername(context.Background(), "alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return user.Password
+	}
+
+	expectStatus(t, ts.do(http.MethodPost, "/login", "", UserLogin{Username: "alice", Password: "wrong password"}), http.StatusUnauthorized)
+	if got := stored(); got != alice.Password {
+		t.Errorf("a failed login changed the hash to %q", got)
+	}
+
+	ts.login("alice")
+	upgraded := stored()
+	if !strings.HasPrefix(upgraded, "$argon2id$") {
+		t.Fatalf("hash after login is %q, want argon2id", upgraded)
+	}
+
+	// The upgraded hash still logs in, and is not replaced again.
+	ts.login("alice")
+	if got := stored(); got != upgraded {
+		t.Errorf("hash changed again to %q", got)
+	}
+}
+
+func TestChangePasswordThrottled(t *testing.T) {
+	cfg := testConfig()
+	cfg.LoginBackoffBase = time.Minute
+	ts := newTestServerWithConfig(t, cfg)
+	ts.createUser("alice", RoleMember)
+	token := ts.login("alice").Token
+
+	wrong := PasswordChange{CurrentPassword: "wrong password", NewPassword: "a new password"}
+	for range loginFreeAttempts {
+		expectStatus(t, ts.do(http.MethodPost, "/user/password", token, wrong), http.StatusUnauthorized)
+	}
+	resp := ts.do(http.MethodPost, "/user/password", token, PasswordChange{CurrentPassword: testPassword, NewPassword: "a new password"})
+	expectStatus(t, resp, http.StatusTooManyRequests)
+	if resp.Header.Get("Retry-After") == "" {
+		t.Error("throttled response has no Retry-After")
+	}
+
+	// The guesses count against logins too.
+	expectStatus(t, ts.do(http.MethodPost, "/login", "", UserLogin{Username: "alice", Password: testPassword}), http.StatusTooManyRequests)
+}
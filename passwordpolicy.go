@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// passwordClasses are the character classes password_character_classes may
+// require, with the wording used in error messages.
+var passwordClasses = map[string]string{
+	"lower":  "a lowercase letter",
+	"upper":  "an uppercase letter",
+	"digit":  "a digit",
+	"symbol": "a symbol",
+}
+
+// minSimilarityLength keeps very short usernames from rejecting most
+// passwords that happen to contain them.
+const minSimilarityLength = 3
+
+// PasswordPolicy decides which new passwords are acceptable. Existing
+// passwords are never re-checked, so tightening it does not lock anyone out.
+type PasswordPolicy struct {
+	MinLength     int
+	MaxLength     int
+	Classes       []string
+	RejectSimilar bool
+	Breached      *BreachedPasswords
+}
+
+func LoadPasswordPolicy(cfg *Config) (*PasswordPolicy, error) {
+	policy := &PasswordPolicy{
+		MinLength:     cfg.PasswordMinLength,
+		MaxLength:     cfg.PasswordMaxLength,
+		Classes:       cfg.PasswordCharacterClasses,
+		RejectSimilar: cfg.PasswordRejectSimilar,
+	}
+
+	if cfg.PasswordBreachedFile != "" {
+		breached, err := LoadBreachedPasswords(cfg.PasswordBreachedFile)
+		if err != nil {
+			return nil, err
+		}
+		policy.Breached = breached
+	}
+
+	return policy, nil
+}
+
+// PasswordPolicyError lists every rule a password broke.
+type PasswordPolicyError struct {
+	Violations []string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return strings.Join(e.Violations, "; ")
+}
+
+// Check validates password for the user with the given username and email.
+// field names the request field in the messages. It returns a
+// *PasswordPolicyError when any rule fails.
+func (p *PasswordPolicy) Check(field, password, username, email string) error {
+	var violations []string
+
+	if utf8.RuneCountInString(password) < p.MinLength {
+		violations = append(violations, fmt.Sprintf("%s must be at least %d characters long", field, p.MinLength))
+	}
+	// The maximum is in bytes because that is what bcrypt truncates at.
+	if len(password) > p.MaxLength {
+		violations = append(violations, fmt.Sprintf("%s must be at most %d bytes long", field, p.MaxLength))
+	}
+
+	for _, class := range p.Classes {
+		if !slices.ContainsFunc([]rune(password), passwordClassFunc(class)) {
+			violations = append(violations, fmt.Sprintf("%s must contain %s", field, passwordClasses[class]))
+		}
+	}
+
+	if p.RejectSimilar {
+		lower := strings.ToLower(password)
+		localPart, _, _ := strings.Cut(email, "@")
+
+		for _, part := range []string{username, localPart} {
+			if len(part) >= minSimilarityLength && strings.Contains(lower, strings.ToLower(part)) {
+				violations = append(violations, fmt.Sprintf("%s must not contain your username or email", field))
+				break
+			}
+		}
+	}
+
+	if p.Breached != nil && p.Breached.Contains(password) {
+		violations = append(violations, fmt.Sprintf("%s appears in a list of breached passwords", field))
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+func passwordClassFunc(class string) func(rune) bool {
+	switch class {
+	case "lower":
+		return unicode.IsLower
+	case "upper":
+		return unicode.IsUpper
+	case "digit":
+		return unicode.IsDigit
+	default:
+		return func(r rune) bool {
+			return unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r)
+		}
+	}
+}
+
+// BreachedPasswords holds SHA-1 hashes of known breached passwords, grouped
+// by their first five hex characters the same way k-anonymity range queries
+// are, so a remote range source can replace the file later.
+type BreachedPasswords struct {
+	ranges map[string]map[string]struct{}
+	count  int
+}
+
+// LoadBreachedPasswords reads a file of upper- or lowercase hex SHA-1 hashes,
+// one per line, optionally followed by ":<count>" as in the Have I Been Pwned
+// downloads. Blank lines and lines starting with # are skipped.
+func LoadBreachedPasswords(path string) (*BreachedPasswords, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	breached := &BreachedPasswords{ranges: make(map[string]map[string]struct{})}
+
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		hash, _, _ := strings.Cut(line, ":")
+		hash = strings.ToUpper(hash)
+		if _, err := hex.DecodeString(hash); err != nil || len(hash) != 2*sha1.Size {
+			return nil, fmt.Errorf("%s:%d: expected a hex SHA-1 hash", path, lineNo)
+		}
+
+		breached.add(hash)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return breached, nil
+}
+
+func (b *BreachedPasswords) add(hash string) {
+	prefix, suffix := hash[:5], hash[5:]
+
+	suffixes, ok := b.ranges[prefix]
+	if !ok {
+		suffixes = make(map[string]struct{})
+		b.ranges[prefix] = suffixes
+	}
+	if _, ok := suffixes[suffix]; !ok {
+		suffixes[suffix] = struct{}{}
+		b.count++
+	}
+}
+
+func (b *BreachedPasswords) Contains(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	_, ok := b.ranges[hash[:5]][hash[5:]]
+	return ok
+}
+
+func (b *BreachedPasswords) Len() int {
+	return b.count
+}
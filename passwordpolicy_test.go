@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeBreachedFile(t *testing.T, contents string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadBreachedPasswords(t *testing.T) {
+	// SHA-1 of "password" and "123456", the second in lower case with a
+	// count as in the Have I Been Pwned downloads.
+	path := writeBreachedFile(t, `# breached passwords
+
+5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8
+7c4a8d09ca3762af61e59520943dc26494f8941b:37359195
+5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:3
+`)
+	breached, err := LoadBreachedPasswords(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if breached.Len() != 2 {
+		t.Errorf("Len() = %d, want 2", breached.Len())
+	}
+	for password, want := range map[string]bool{"password": true, "123456": true, "Password": false, "correct horse": false} {
+		if got := breached.Contains(password); got != want {
+			t.Errorf("Contains(%q) = %v, want %v", password, got, want)
+		}
+	}
+
+	if _, err := LoadBreachedPasswords(writeBreachedFile(t, "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8\nnot a hash\n")); err == nil {
+		t.Error("LoadBreachedPasswords accepted a line that is not a hash")
+	}
+	if _, err := LoadBreachedPasswords(writeBreachedFile(t, "5BAA61E4C9B93F3F\n")); err == nil {
+		t.Error("LoadBreachedPasswords accepted a truncated hash")
+	}
+}
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	breached, err := LoadBreachedPasswords(writeBreachedFile(t, "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := &PasswordPolicy{MinLength: 8, MaxLength: 72, RejectSimilar: true, Breached: breached}
+
+	tests := []struct {
+		name     string
+		password string
+		username string
+		email    string
+		want     []string
+	}{
+		{"acceptable", "correct horse", "alice", "alice@example.com", nil},
+		{"breached", "password", "alice", "alice@example.com", []string{"password appears in a list of breached passwords"}},
+		{"contains the username", "ALICE rules ok", "alice", "someone@example.com", []string{"password must not contain your username or email"}},
+		{"contains the email local part", "i am wonderland", "alice", "wonderland@example.com", []string{"password must not contain your username or email"}},
+		{"email domain is allowed", "example horse", "alice", "alice@example.com", nil},
+		{"short usernames are ignored", "al is my friend", "al", "al@example.com", nil},
+		{"every rule is reported", "alice", "alice", "alice@example.com", []string{
+			"password must be at least 8 characters long",
+			"password must not contain your username or email",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Check("password", tt.password, tt.username, tt.email)
+			if tt.want == nil {
+				if err != nil {
+					t.Errorf("Check(%q) = %v, want nil", tt.password, err)
+				}
+				return
+			}
+			var policyErr *PasswordPolicyError
+			if !errors.As(err, &policyErr) {
+				t.Fatalf("Check(%q) = %v, want a *PasswordPolicyError", tt.password, err)
+			}
+			if !reflect.DeepEqual(policyErr.Violations, tt.want) {
+				t.Errorf("Check(%q) violations = %q, want %q", tt.password, policyErr.Violations, tt.want)
+			}
+		})
+	}
+
+	policy.RejectSimilar = false
+	if err := policy.Check("password", "ALICE rules ok", "alice", "alice@example.com"); err != nil {
+		t.Errorf("Check with password_reject_similar off = %v, want nil", err)
+	}
+}
@@ -0,0 +1,889 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// pgError maps driver errors onto the store errors handlers understand.
+func pgError(err error) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrConflict
+	}
+
+	return err
+}
+
+func NewPgStores(db *pgxpool.Pool) Stores {
+	return Stores{
+		Users:         NewPgUserStore(db),
+		Posts:         NewPgPostStore(db),
+		RefreshTokens: NewPgRefreshTokenStore(db),
+		Denylist:      NewPgTokenDenylist(db),
+		ResetTokens:   NewPgPasswordResetStore(db),
+		MFA:           NewPgMFAStore(db),
+		LoginAttempts: NewPgLoginAttemptStore(db),
+		APIKeys:       NewPgAPIKeyStore(db),
+		Sessions:      NewPgSessionStore(db),
+		Identities:    NewPgIdentityStore(db),
+		Comments:      NewPgCommentStore(db),
+	}
+}
+
+// userColumns lists the user columns every read returns, in the order
+// userFields scans them. The password hash is selected separately.
+const userColumns = `id, username, email, is_active, role, email_verified_at, deactivated_at, deactivation_reason, created_at, updated_at`
+
+func userFields(user *User) []any {
+	return []any{&user.ID, &user.Username, &user.Email, &user.IsActive, &user.Role, &user.EmailVerifiedAt, &user.DeactivatedAt, &user.DeactivationReason, &user.CreatedAt, &user.UpdatedAt}
+}
+
+type PgUserStore struct {
+	db *pgxpool.Pool
+}
+
+func NewPgUserStore(db *pgxpool.Pool) *PgUserStore {
+	return &PgUserStore{db: db}
+}
+
+func (s *PgUserStore) Create(ctx context.Context, user *User) error {
+	query := `INSERT INTO users (username, email, password, is_active, role, email_verified_at) VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at, updated_at`
+	err := s.db.QueryRow(ctx, query, user.Username, user.Email, user.Password, user.IsActive, user.Role, user.EmailVerifiedAt).Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt)
+	return pgError(err)
+}
+
+func (s *PgUserStore) Get(ctx context.Context, id int) (User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE id = $1`
+
+	var user User
+	err := s.db.QueryRow(ctx, query, id).Scan(userFields(&user)...)
+	return user, pgError(err)
+}
+
+func (s *PgUserStore) GetByUsername(ctx context.Context, username string) (User, error) {
+	query := `SELECT password, ` + userColumns + ` FROM users WHERE username = $1`
+
+	var user User
+	err := s.db.QueryRow(ctx, query, username).Scan(append([]any{&user.Password}, userFields(&user)...)...)
+	return user, pgError(err)
+}
+
+func (s *PgUserStore) GetByEmail(ctx context.Context, email string) (User, error) {
+	query := `SELECT ` + userColumns + ` FROM users WHERE email = $1`
+
+	var user User
+	err := s.db.QueryRow(ctx, query, email).Scan(userFields(&user)...)
+	return user, pgError(err)
+}
+
+func (s *PgUserStore) List(ctx context.Context, filter UserFilter, q ListQuery) ([]User, error) {
+	var conds sqlConditions
+	if filter.IsActive != nil {
+		conds.add("is_active = $%d", *filter.IsActive)
+	}
+	if filter.Role != "" {
+		conds.add("role = $%d", filter.Role)
+	}
+	if filter.CreatedAfter != nil {
+		conds.add("created_at > $%d", *filter.CreatedAfter)
+	}
+	order := conds.keyset(userSortFields[q.Sort].Column, q)
+
+	query := `SELECT ` + userColumns + ` FROM users` + conds.where() + order
+	rows, err := s.db.Query(ctx, query, conds.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := make([]User, 0)
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(userFields(&user)...); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
+
+func (s *PgUserStore) Update(ctx context.Context, user *User) error {
+	user.UpdatedAt = time.Now()
+
+	query := `UPDATE users SET username = $1, email = $2, password = COALESCE(NULLIF($3, ''), password), role = $4, updated_at = $5
+		WHERE id = $6
+		RETURNING is_active, deactivated_at, deactivation_reason`
+	err := s.db.QueryRow(ctx, query, user.Username, user.Email, user.Password, user.Role, user.UpdatedAt, user.ID).Scan(&user.IsActive, &user.DeactivatedAt, &user.DeactivationReason)
+	return pgError(err)
+}
+
+func (s *PgUserStore) UpdatePassword(ctx context.Context, id int, passwordHash string) error {
+	result, err := s.db.Exec(ctx, `UPDATE users SET password = $1, updated_at = $2 WHERE id = $3`, passwordHash, time.Now(), id)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PgUserStore) SetEmailVerified(ctx context.Context, id int, verifiedAt *time.Time) error {
+	result, err := s.db.Exec(ctx, `UPDATE users SET email_verified_at = $1 WHERE id = $2`, verifiedAt, id)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PgUserStore) SetActive(ctx context.Context, id int, active bool, reason string) error {
+	query := `UPDATE users SET is_active = $1, updated_at = now(),
+			deactivated_at = CASE WHEN $1 THEN NULL ELSE now() END,
+			deactivation_reason = CASE WHEN $1 THEN NULL ELSE $2 END
+		WHERE id = $3`
+
+	result, err := s.db.Exec(ctx, query, active, reason, id)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PgUserStore) Delete(ctx context.Context, id int) error {
+	result, err := s.db.Exec(ctx, `DELETE FROM users WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PgUserStore) ExistsByUsernameOrEmail(ctx context.Context, username, email string, excludeID int) (bool, error) {
+	var count int
+
+	args := []any{username, email}
+
+	query := `SELECT COUNT(*) FROM users WHERE (username = $1 OR email = $2)`
+	if excludeID != 0 {
+		query += ` AND id != $3`
+		args = append(args, excludeID)
+	}
+	if err := s.db.QueryRow(ctx, query, args...).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+type PgPostStore struct {
+	db *pgxpool.Pool
+}
+
+func NewPgPostStore(db *pgxpool.Pool) *PgPostStore {
+	return &PgPostStore{db: db}
+}
+
+// postColumns lists the post columns every read returns, in the order
+// postFields scans them. They expect the posts table, or a subquery over it,
+// to be named posts.
+const postColumns = `posts.id, posts.title, posts.body, posts.user_id, COALESCE(posts.category, ''), posts.created_at,
+	ARRAY(SELECT t.slug FROM post_tags pt JOIN tags t ON t.id = pt.tag_id WHERE pt.post_id = posts.id ORDER BY t.slug)`
+
+func postFields(post *Post) []any {
+	return []any{&post.ID, &post.Title, &post.Body, &post.UserId, &post.Category, &post.CreatedAt, &post.Tags}
+}
+
+func (s *PgPostStore) Create(ctx context.Context, post *Post) error {
+	return pgx.BeginFunc(ctx, s.db, func(tx pgx.Tx) error {
+		query := `INSERT INTO posts (title, body, user_id, category) VALUES ($1, $2, $3, NULLIF($4, '')) RETURNING id, created_at`
+		if err := tx.QueryRow(ctx, query, post.Title, post.Body, post.UserId, post.Category).Scan(&post.ID, &post.CreatedAt); err != nil {
+			return err
+		}
+		return replacePostTags(ctx, tx, post.ID, post.Tags)
+	})
+}
+
+func (s *PgPostStore) Get(ctx context.Context, id int) (Post, error) {
+	query := `SELECT ` + postColumns + ` FROM posts WHERE id = $1`
+
+	var post Post
+	err := s.db.QueryRow(ctx, query, id).Scan(postFields(&post)...)
+	return post, pgError(err)
+}
+
+func addPostFilter(conds *sqlConditions, filter PostFilter) {
+	if filter.UserID != 0 {
+		conds.add("user_id = $%d", filter.UserID)
+	}
+	if filter.CreatedAfter != nil {
+		conds.add("created_at > $%d", *filter.CreatedAfter)
+	}
+	if filter.Category != "" {
+		conds.add("category = $%d", filter.Category)
+	}
+	if len(filter.Tags) > 0 {
+		tagged := "id IN (SELECT pt.post_id FROM post_tags pt JOIN tags t ON t.id = pt.tag_id WHERE t.slug = ANY($%d)"
+		if !filter.AnyTag {
+			tagged += " GROUP BY pt.post_id HAVING count(*) = " + strconv.Itoa(len(filter.Tags))
+		}
+		conds.add(tagged+")", filter.Tags)
+	}
+}
+
+func (s *PgPostStore) List(ctx context.Context, filter PostFilter, q ListQuery) ([]Post, error) {
+	var conds sqlConditions
+	addPostFilter(&conds, filter)
+	order := conds.keyset(postSortFields[q.Sort].Column, q)
+
+	query := `SELECT ` + postColumns + ` FROM posts` + conds.where() + order
+	rows, err := s.db.Query(ctx, query, conds.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := make([]Post, 0)
+	for rows.Next() {
+		var post Post
+		if err := rows.Scan(postFields(&post)...); err != nil {
+			return nil, err
+		}
+		posts = append(posts, post)
+	}
+
+	return posts, rows.Err()
+}
+
+// Search matches the generated posts.search column, which weights titles
+// above bodies. Headlines are built from the HTML-escaped body.
+func (s *PgPostStore) Search(ctx context.Context, terms []SearchTerm, filter PostFilter, q ListQuery) ([]SearchResult, error) {
+	var conds sqlConditions
+	tsquery := pgTSQuery(&conds, terms)
+	addPostFilter(&conds, filter)
+	order := conds.keyset(searchSortFields[q.Sort].Column, q)
+
+	// ts_headline and the tags are only evaluated for the rows the LIMIT
+	// keeps.
+	query := `SELECT ` + postColumns + `, rank,
+			ts_headline('` + searchConfig + `', replace(replace(replace(body, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), query, '` + searchHeadlineOptions + `')
+		FROM (
+			SELECT id, title, body, user_id, category, created_at, ts_rank(search, query) AS rank, query
+			FROM posts CROSS JOIN (SELECT ` + tsquery + ` AS query) AS q
+			WHERE search @@ query
+		) AS posts` + conds.where() + order
+	rows, err := s.db.Query(ctx, query, conds.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := make([]SearchResult, 0)
+	for rows.Next() {
+		var result SearchResult
+		if err := rows.Scan(append(postFields(&result.Post), &result.Rank, &result.Headline)...); err != nil {
+			return nil, err
+		}
+		results = append(results, result)
+	}
+
+	return results, rows.Err()
+}
+
+func (s *PgPostStore) Update(ctx context.Context, post *Post) error {
+	return pgx.BeginFunc(ctx, s.db, func(tx pgx.Tx) error {
+		query := `UPDATE posts set title=$1, user_id=$2, body=$3, category=NULLIF($4, '') WHERE id=$5 RETURNING created_at`
+		if err := tx.QueryRow(ctx, query, post.Title, post.UserId, post.Body, post.Category, post.ID).Scan(&post.CreatedAt); err != nil {
+			return pgError(err)
+		}
+		return replacePostTags(ctx, tx, post.ID, post.Tags)
+	})
+}
+
+// replacePostTags links the post to exactly the given tag slugs, creating
+// tags that do not exist yet.
+func replacePostTags(ctx context.Context, tx pgx.Tx, postID int, tags []string) error {
+	if _, err := tx.Exec(ctx, `DELETE FROM post_tags WHERE post_id = $1`, postID); err != nil {
+		return err
+	}
+	if len(tags) == 0 {
+		return nil
+	}
+
+	if _, err := tx.Exec(ctx, `INSERT INTO tags (slug) SELECT unnest($1::text[]) ON CONFLICT (slug) DO NOTHING`, tags); err != nil {
+		return err
+	}
+	_, err := tx.Exec(ctx, `INSERT INTO post_tags (post_id, tag_id) SELECT $1, id FROM tags WHERE slug = ANY($2)`, postID, tags)
+	return err
+}
+
+func (s *PgPostStore) Tags(ctx context.Context) ([]Tag, error) {
+	query := `SELECT t.slug, count(*) FROM tags t JOIN post_tags pt ON pt.tag_id = t.id GROUP BY t.slug ORDER BY count(*) DESC, t.slug`
+	rows, err := s.db.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := make([]Tag, 0)
+	for rows.Next() {
+		var tag Tag
+		if err := rows.Scan(&tag.Slug, &tag.Count); err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+
+	return tags, rows.Err()
+}
+
+func (s *PgPostStore) Delete(ctx context.Context, id int) error {
+	result, err := s.db.Exec(ctx, `DELETE FROM posts WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type PgRefreshTokenStore struct {
+	db *pgxpool.Pool
+}
+
+func NewPgRefreshTokenStore(db *pgxpool.Pool) *PgRefreshTokenStore {
+	return &PgRefreshTokenStore{db: db}
+}
+
+func (s *PgRefreshTokenStore) Create(ctx context.Context, token *RefreshToken) error {
+	query := `INSERT INTO refresh_tokens (user_id, family_id, token_hash, mfa, expires_at) VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`
+	err := s.db.QueryRow(ctx, query, token.UserID, token.FamilyID, token.TokenHash, token.MFA, token.ExpiresAt).Scan(&token.ID, &token.CreatedAt)
+	return pgError(err)
+}
+
+func (s *PgRefreshTokenStore) GetByHash(ctx context.Context, tokenHash string) (RefreshToken, error) {
+	query := `SELECT id, user_id, family_id, token_hash, mfa, expires_at, created_at, used_at, revoked_at FROM refresh_tokens WHERE token_hash = $1`
+
+	var token RefreshToken
+	err := s.db.QueryRow(ctx, query, tokenHash).Scan(&token.ID, &token.UserID, &token.FamilyID, &token.TokenHash, &token.MFA, &token.ExpiresAt, &token.CreatedAt, &token.UsedAt, &token.RevokedAt)
+	return token, pgError(err)
+}
+
+func (s *PgRefreshTokenStore) MarkUsed(ctx context.Context, id int) (bool, error) {
+	result, err := s.db.Exec(ctx, `UPDATE refresh_tokens SET used_at = now() WHERE id = $1 AND used_at IS NULL`, id)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() == 1, nil
+}
+
+func (s *PgRefreshTokenStore) RevokeFamily(ctx context.Context, familyID string) error {
+	_, err := s.db.Exec(ctx, `UPDATE refresh_tokens SET revoked_at = now() WHERE family_id = $1 AND revoked_at IS NULL`, familyID)
+	return err
+}
+
+func (s *PgRefreshTokenStore) RevokeUser(ctx context.Context, userID int) error {
+	_, err := s.db.Exec(ctx, `UPDATE refresh_tokens SET revoked_at = now() WHERE user_id = $1 AND revoked_at IS NULL`, userID)
+	return err
+}
+
+type PgTokenDenylist struct {
+	db *pgxpool.Pool
+}
+
+func NewPgTokenDenylist(db *pgxpool.Pool) *PgTokenDenylist {
+	return &PgTokenDenylist{db: db}
+}
+
+func (s *PgTokenDenylist) Revoke(ctx context.Context, jti string, expiresAt time.Time) error {
+	if _, err := s.db.Exec(ctx, `DELETE FROM revoked_tokens WHERE expires_at < now()`); err != nil {
+		return err
+	}
+
+	query := `INSERT INTO revoked_tokens (jti, expires_at) VALUES ($1, $2) ON CONFLICT (jti) DO NOTHING`
+	_, err := s.db.Exec(ctx, query, jti, expiresAt)
+	return err
+}
+
+func (s *PgTokenDenylist) RevokeUser(ctx context.Context, userID int, revokedBefore, expiresAt time.Time) error {
+	if _, err := s.db.Exec(ctx, `DELETE FROM user_token_revocations WHERE expires_at < now()`); err != nil {
+		return err
+	}
+
+	query := `INSERT INTO user_token_revocations (user_id, revoked_before, expires_at) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET revoked_before = EXCLUDED.revoked_before, expires_at = EXCLUDED.expires_at`
+	_, err := s.db.Exec(ctx, query, userID, revokedBefore.Truncate(time.Second), expiresAt)
+	return err
+}
+
+func (s *PgTokenDenylist) IsRevoked(ctx context.Context, claims *UserClaims) (bool, error) {
+	var issuedAt time.Time
+	if claims.IssuedAt != nil {
+		issuedAt = claims.IssuedAt.Time
+	}
+
+	query := `SELECT
+		EXISTS (SELECT 1 FROM revoked_tokens WHERE jti = $1)
+		OR EXISTS (SELECT 1 FROM user_token_revocations WHERE user_id = $2 AND revoked_before > $3)`
+
+	var revoked bool
+	err := s.db.QueryRow(ctx, query, claims.RegisteredClaims.ID, claims.ID, issuedAt).Scan(&revoked)
+	return revoked, err
+}
+
+type PgPasswordResetStore struct {
+	db *pgxpool.Pool
+}
+
+func NewPgPasswordResetStore(db *pgxpool.Pool) *PgPasswordResetStore {
+	return &PgPasswordResetStore{db: db}
+}
+
+func (s *PgPasswordResetStore) Create(ctx context.Context, token *PasswordResetToken) error {
+	query := `INSERT INTO password_reset_tokens (user_id, token_hash, expires_at) VALUES ($1, $2, $3) RETURNING id, created_at`
+	err := s.db.QueryRow(ctx, query, token.UserID, token.TokenHash, token.ExpiresAt).Scan(&token.ID, &token.CreatedAt)
+	return pgError(err)
+}
+
+func (s *PgPasswordResetStore) Lookup(ctx context.Context, tokenHash string) (PasswordResetToken, error) {
+	query := `SELECT id, user_id, token_hash, expires_at, created_at, used_at FROM password_reset_tokens
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > now()`
+
+	var token PasswordResetToken
+	err := s.db.QueryRow(ctx, query, tokenHash).Scan(&token.ID, &token.UserID, &token.TokenHash, &token.ExpiresAt, &token.CreatedAt, &token.UsedAt)
+	return token, pgError(err)
+}
+
+func (s *PgPasswordResetStore) Consume(ctx context.Context, tokenHash string) (PasswordResetToken, error) {
+	query := `UPDATE password_reset_tokens SET used_at = now()
+		WHERE token_hash = $1 AND used_at IS NULL AND expires_at > now()
+		RETURNING id, user_id, token_hash, expires_at, created_at, used_at`
+
+	var token PasswordResetToken
+	err := s.db.QueryRow(ctx, query, tokenHash).Scan(&token.ID, &token.UserID, &token.TokenHash, &token.ExpiresAt, &token.CreatedAt, &token.UsedAt)
+	return token, pgError(err)
+}
+
+func (s *PgPasswordResetStore) InvalidateUser(ctx context.Context, userID int) error {
+	_, err := s.db.Exec(ctx, `UPDATE password_reset_tokens SET used_at = now() WHERE user_id = $1 AND used_at IS NULL`, userID)
+	return err
+}
+
+type PgMFAStore struct {
+	db *pgxpool.Pool
+}
+
+func NewPgMFAStore(db *pgxpool.Pool) *PgMFAStore {
+	return &PgMFAStore{db: db}
+}
+
+func (s *PgMFAStore) GetTOTP(ctx context.Context, userID int) (TOTPSecret, error) {
+	query := `SELECT user_id, secret, enabled_at, last_step, created_at FROM user_totp WHERE user_id = $1`
+
+	var totp TOTPSecret
+	err := s.db.QueryRow(ctx, query, userID).Scan(&totp.UserID, &totp.Secret, &totp.EnabledAt, &totp.LastStep, &totp.CreatedAt)
+	return totp, pgError(err)
+}
+
+func (s *PgMFAStore) SetPendingTOTP(ctx context.Context, userID int, secret string) error {
+	query := `INSERT INTO user_totp (user_id, secret) VALUES ($1, $2)
+		ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, enabled_at = NULL, last_step = 0, created_at = now()
+		WHERE user_totp.enabled_at IS NULL`
+
+	result, err := s.db.Exec(ctx, query, userID, secret)
+	if err != nil {
+		return pgError(err)
+	}
+	if result.RowsAffected() == 0 {
+		return ErrConflict
+	}
+	return nil
+}
+
+func (s *PgMFAStore) EnableTOTP(ctx context.Context, userID int, recoveryCodeHashes []string) error {
+	return pgx.BeginFunc(ctx, s.db, func(tx pgx.Tx) error {
+		result, err := tx.Exec(ctx, `UPDATE user_totp SET enabled_at = now() WHERE user_id = $1 AND enabled_at IS NULL`, userID)
+		if err != nil {
+			return err
+		}
+		if result.RowsAffected() == 0 {
+			return ErrNotFound
+		}
+		return replaceRecoveryCodes(ctx, tx, userID, recoveryCodeHashes)
+	})
+}
+
+func (s *PgMFAStore) DisableTOTP(ctx context.Context, userID int) error {
+	return pgx.BeginFunc(ctx, s.db, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, `DELETE FROM user_totp WHERE user_id = $1`, userID)
+		return err
+	})
+}
+
+func (s *PgMFAStore) UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error) {
+	result, err := s.db.Exec(ctx, `UPDATE user_totp SET last_step = $2 WHERE user_id = $1 AND last_step < $2`, userID, step)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() == 1, nil
+}
+
+func (s *PgMFAStore) ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string) error {
+	return pgx.BeginFunc(ctx, s.db, func(tx pgx.Tx) error {
+		return replaceRecoveryCodes(ctx, tx, userID, codeHashes)
+	})
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, userID int, codeHashes []string) error {
+	if _, err := tx.Exec(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	for _, hash := range codeHashes {
+		if _, err := tx.Exec(ctx, `INSERT INTO recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userID, hash); err != nil {
+			return pgError(err)
+		}
+	}
+	return nil
+}
+
+func (s *PgMFAStore) UseRecoveryCode(ctx context.Context, userID int, codeHash string) (bool, error) {
+	query := `UPDATE recovery_codes SET used_at = now() WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`
+
+	result, err := s.db.Exec(ctx, query, userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+	return result.RowsAffected() == 1, nil
+}
+
+type PgLoginAttemptStore struct {
+	db *pgxpool.Pool
+}
+
+func NewPgLoginAttemptStore(db *pgxpool.Pool) *PgLoginAttemptStore {
+	return &PgLoginAttemptStore{db: db}
+}
+
+func (s *PgLoginAttemptStore) Get(ctx context.Context, key string) (LoginAttempts, error) {
+	query := `SELECT key, failures, last_failed_at FROM login_attempts WHERE key = $1`
+
+	var attempts LoginAttempts
+	err := s.db.QueryRow(ctx, query, key).Scan(&attempts.Key, &attempts.Failures, &attempts.LastFailedAt)
+	return attempts, pgError(err)
+}
+
+func (s *PgLoginAttemptStore) Reserve(ctx context.Context, key string, window time.Duration, wait func(LoginAttempts) time.Duration) (LoginAttempts, time.Duration, error) {
+	cutoff := time.Now().Add(-window)
+
+	if _, err := s.db.Exec(ctx, `DELETE FROM login_attempts WHERE last_failed_at < $1`, cutoff); err != nil {
+		return LoginAttempts{}, 0, err
+	}
+
+	previous := LoginAttempts{Key: key}
+	var d time.Duration
+	err := pgx.BeginFunc(ctx, s.db, func(tx pgx.Tx) error {
+		// The row lock makes parallel attempts on the key wait for this one
+		// to be counted.
+		query := `SELECT key, failures, last_failed_at FROM login_attempts WHERE key = $1 FOR UPDATE`
+		err := tx.QueryRow(ctx, query, key).Scan(&previous.Key, &previous.Failures, &previous.LastFailedAt)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return err
+		}
+		if previous.LastFailedAt.Before(cutoff) {
+			previous = LoginAttempts{Key: key}
+		}
+
+		if d = wait(previous); d > 0 {
+			return nil
+		}
+
+		query = `INSERT INTO login_attempts (key, failures, last_failed_at) VALUES ($1, 1, now())
+			ON CONFLICT (key) DO UPDATE SET
+				failures = CASE WHEN login_attempts.last_failed_at < $2 THEN 1 ELSE login_attempts.failures + 1 END,
+				last_failed_at = now()`
+		_, err = tx.Exec(ctx, query, key, cutoff)
+		return err
+	})
+	if err != nil {
+		return LoginAttempts{}, 0, err
+	}
+	return previous, d, nil
+}
+
+func (s *PgLoginAttemptStore) Release(ctx context.Context, previous LoginAttempts) error {
+	return pgx.BeginFunc(ctx, s.db, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `DELETE FROM login_attempts WHERE key = $1 AND failures <= 1`, previous.Key); err != nil {
+			return err
+		}
+
+		// Restore the last failure only if nothing else was counted since.
+		query := `UPDATE login_attempts SET
+				failures = failures - 1,
+				last_failed_at = CASE WHEN failures - 1 = $2 THEN $3 ELSE last_failed_at END
+			WHERE key = $1`
+		_, err := tx.Exec(ctx, query, previous.Key, previous.Failures, previous.LastFailedAt)
+		return err
+	})
+}
+
+func (s *PgLoginAttemptStore) Reset(ctx context.Context, key string) error {
+	_, err := s.db.Exec(ctx, `DELETE FROM login_attempts WHERE key = $1`, key)
+	return err
+}
+
+type PgAPIKeyStore struct {
+	db *pgxpool.Pool
+}
+
+func NewPgAPIKeyStore(db *pgxpool.Pool) *PgAPIKeyStore {
+	return &PgAPIKeyStore{db: db}
+}
+
+const apiKeyColumns = `id, user_id, name, prefix, key_hash, scopes, expires_at, last_used_at, created_at, revoked_at`
+
+func scanAPIKey(row pgx.Row) (APIKey, error) {
+	var key APIKey
+	var scopes []string
+
+	err := row.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, &key.KeyHash, &scopes, &key.ExpiresAt, &key.LastUsedAt, &key.CreatedAt, &key.RevokedAt)
+	if err != nil {
+		return APIKey{}, err
+	}
+
+	key.Scopes = make([]Permission, len(scopes))
+	for i, scope := range scopes {
+		key.Scopes[i] = Permission(scope)
+	}
+	return key, nil
+}
+
+func (s *PgAPIKeyStore) Create(ctx context.Context, key *APIKey) error {
+	scopes := make([]string, len(key.Scopes))
+	for i, scope := range key.Scopes {
+		scopes[i] = string(scope)
+	}
+
+	query := `INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id, created_at`
+	err := s.db.QueryRow(ctx, query, key.UserID, key.Name, key.Prefix, key.KeyHash, scopes, key.ExpiresAt).Scan(&key.ID, &key.CreatedAt)
+	return pgError(err)
+}
+
+func (s *PgAPIKeyStore) Get(ctx context.Context, id int) (APIKey, error) {
+	key, err := scanAPIKey(s.db.QueryRow(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE id = $1`, id))
+	return key, pgError(err)
+}
+
+func (s *PgAPIKeyStore) GetByPrefix(ctx context.Context, prefix string) (APIKey, error) {
+	key, err := scanAPIKey(s.db.QueryRow(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE prefix = $1`, prefix))
+	return key, pgError(err)
+}
+
+func (s *PgAPIKeyStore) ListByUser(ctx context.Context, userID int) ([]APIKey, error) {
+	rows, err := s.db.Query(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE user_id = $1 ORDER BY id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+func (s *PgAPIKeyStore) Revoke(ctx context.Context, id int) error {
+	result, err := s.db.Exec(ctx, `UPDATE api_keys SET revoked_at = COALESCE(revoked_at, now()) WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *PgAPIKeyStore) Touch(ctx context.Context, id int, usedAt time.Time) error {
+	_, err := s.db.Exec(ctx, `UPDATE api_keys SET last_used_at = $2 WHERE id = $1`, id, usedAt)
+	return err
+}
+
+type PgSessionStore struct {
+	db *pgxpool.Pool
+}
+
+func NewPgSessionStore(db *pgxpool.Pool) *PgSessionStore {
+	return &PgSessionStore{db: db}
+}
+
+// Create also drops expired sessions, so the table does not grow forever.
+func (s *PgSessionStore) Create(ctx context.Context, session *Session) error {
+	if _, err := s.db.Exec(ctx, `DELETE FROM sessions WHERE expires_at <= now()`); err != nil {
+		return err
+	}
+
+	query := `INSERT INTO sessions (user_id, token_hash, csrf_token_hash, mfa, expires_at)
+		VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`
+	err := s.db.QueryRow(ctx, query, session.UserID, session.TokenHash, session.CSRFTokenHash, session.MFA, session.ExpiresAt).Scan(&session.ID, &session.CreatedAt)
+	return pgError(err)
+}
+
+func (s *PgSessionStore) Get(ctx context.Context, tokenHash string) (Session, error) {
+	query := `SELECT id, user_id, token_hash, csrf_token_hash, mfa, expires_at, created_at FROM sessions
+		WHERE token_hash = $1 AND expires_at > now()`
+
+	var session Session
+	err := s.db.QueryRow(ctx, query, tokenHash).Scan(&session.ID, &session.UserID, &session.TokenHash, &session.CSRFTokenHash, &session.MFA, &session.ExpiresAt, &session.CreatedAt)
+	return session, pgError(err)
+}
+
+func (s *PgSessionStore) Delete(ctx context.Context, id int) error {
+	_, err := s.db.Exec(ctx, `DELETE FROM sessions WHERE id = $1`, id)
+	return err
+}
+
+func (s *PgSessionStore) DeleteUser(ctx context.Context, userID, keepID int) error {
+	_, err := s.db.Exec(ctx, `DELETE FROM sessions WHERE user_id = $1 AND id <> $2`, userID, keepID)
+	return err
+}
+
+type PgIdentityStore struct {
+	db *pgxpool.Pool
+}
+
+func NewPgIdentityStore(db *pgxpool.Pool) *PgIdentityStore {
+	return &PgIdentityStore{db: db}
+}
+
+func (s *PgIdentityStore) Get(ctx context.Context, issuer, subject string) (Identity, error) {
+	query := `SELECT id, user_id, issuer, subject, email, created_at FROM user_identities WHERE issuer = $1 AND subject = $2`
+
+	var identity Identity
+	err := s.db.QueryRow(ctx, query, issuer, subject).Scan(&identity.ID, &identity.UserID, &identity.Issuer, &identity.Subject, &identity.Email, &identity.CreatedAt)
+	return identity, pgError(err)
+}
+
+func (s *PgIdentityStore) Create(ctx context.Context, identity *Identity) error {
+	query := `INSERT INTO user_identities (user_id, issuer, subject, email) VALUES ($1, $2, $3, $4) RETURNING id, created_at`
+	err := s.db.QueryRow(ctx, query, identity.UserID, identity.Issuer, identity.Subject, identity.Email).Scan(&identity.ID, &identity.CreatedAt)
+	return pgError(err)
+}
+
+type PgCommentStore struct {
+	db *pgxpool.Pool
+}
+
+func NewPgCommentStore(db *pgxpool.Pool) *PgCommentStore {
+	return &PgCommentStore{db: db}
+}
+
+// commentColumns lists the comment columns every read returns, in the order
+// commentFields scans them.
+const commentColumns = `id, post_id, parent_id, user_id, body, depth, created_at, edited_at, deleted_at,
+	(SELECT count(*) FROM comments replies WHERE replies.parent_id = comments.id)`
+
+func commentFields(comment *Comment) []any {
+	return []any{&comment.ID, &comment.PostID, &comment.ParentID, &comment.UserID, &comment.Body, &comment.Depth, &comment.CreatedAt, &comment.EditedAt, &comment.DeletedAt, &comment.ReplyCount}
+}
+
+func (s *PgCommentStore) Create(ctx context.Context, comment *Comment) error {
+	query := `INSERT INTO comments (post_id, parent_id, user_id, body, depth) VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`
+	err := s.db.QueryRow(ctx, query, comment.PostID, comment.ParentID, comment.UserID, comment.Body, comment.Depth).Scan(&comment.ID, &comment.CreatedAt)
+	return pgError(err)
+}
+
+func (s *PgCommentStore) Get(ctx context.Context, id int) (Comment, error) {
+	query := `SELECT ` + commentColumns + ` FROM comments WHERE id = $1`
+
+	var comment Comment
+	err := s.db.QueryRow(ctx, query, id).Scan(commentFields(&comment)...)
+	return comment, pgError(err)
+}
+
+func (s *PgCommentStore) List(ctx context.Context, postID, parentID int, q ListQuery) ([]Comment, error) {
+	var conds sqlConditions
+	conds.add("post_id = $%d", postID)
+	if parentID == 0 {
+		conds.conds = append(conds.conds, "parent_id IS NULL")
+	} else {
+		conds.add("parent_id = $%d", parentID)
+	}
+	order := conds.keyset(commentSortFields[q.Sort].Column, q)
+
+	return s.query(ctx, `SELECT `+commentColumns+` FROM comments`+conds.where()+order, conds.args...)
+}
+
+func (s *PgCommentStore) Descendants(ctx context.Context, ids []int, depth int) ([]Comment, error) {
+	query := `WITH RECURSIVE thread (id, level) AS (
+			SELECT id, 1 FROM comments WHERE parent_id = ANY($1)
+			UNION ALL
+			SELECT c.id, thread.level + 1 FROM comments c JOIN thread ON c.parent_id = thread.id WHERE thread.level < $2
+		)
+		SELECT ` + commentColumns + ` FROM comments WHERE id IN (SELECT id FROM thread) ORDER BY created_at, id`
+	return s.query(ctx, query, ids, depth)
+}
+
+func (s *PgCommentStore) query(ctx context.Context, query string, args ...any) ([]Comment, error) {
+	rows, err := s.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := make([]Comment, 0)
+	for rows.Next() {
+		var comment Comment
+		if err := rows.Scan(commentFields(&comment)...); err != nil {
+			return nil, err
+		}
+		comments = append(comments, comment)
+	}
+
+	return comments, rows.Err()
+}
+
+func (s *PgCommentStore) Update(ctx context.Context, comment *Comment) error {
+	query := `UPDATE comments SET body = $1, edited_at = now() WHERE id = $2 AND deleted_at IS NULL RETURNING ` + commentColumns
+	err := s.db.QueryRow(ctx, query, comment.Body, comment.ID).Scan(commentFields(comment)...)
+	return pgError(err)
+}
+
+func (s *PgCommentStore) Delete(ctx context.Context, id int) error {
+	result, err := s.db.Exec(ctx, `UPDATE comments SET body = '', deleted_at = COALESCE(deleted_at, now()) WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// IntervalLimiter allows one event per key every interval. Expired keys are
+// dropped as new events arrive.
+type IntervalLimiter struct {
+	mu       sync.Mutex
+	interval time.Duration
+	last     map[string]time.Time
+}
+
+func NewIntervalLimiter(interval time.Duration) *IntervalLimiter {
+	return &IntervalLimiter{interval: interval, last: make(map[string]time.Time)}
+}
+
+func (l *IntervalLimiter) Allow(key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+
+	for k, at := range l.last {
+		if now.Sub(at) >= l.interval {
+			delete(l.last, k)
+		}
+	}
+
+	if _, ok := l.last[key]; ok {
+		return false
+	}
+	l.last[key] = now
+	return true
+}
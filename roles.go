@@ -0,0 +1,90 @@
+package main
+
+import (
+	"slices"
+)
+
+type Role string
+
+const (
+	RoleAdmin  Role = "admin"
+	RoleEditor Role = "editor"
+	RoleMember Role = "member"
+)
+
+type Permission string
+
+const (
+	PermUsersRead      Permission = "users:read"
+	PermUsersWrite     Permission = "users:write"
+	PermSessionsRevoke Permission = "sessions:revoke"
+	PermPostsWrite     Permission = "posts:write"
+	// PermPostsModerate allows editing and deleting posts written by others,
+	// and deleting their comments.
+	PermPostsModerate Permission = "posts:moderate"
+	PermCommentsWrite Permission = "comments:write"
+)
+
+var permissions = []Permission{
+	PermUsersRead,
+	PermUsersWrite,
+	PermSessionsRevoke,
+	PermPostsWrite,
+	PermPostsModerate,
+	PermCommentsWrite,
+}
+
+func (p Permission) Valid() bool {
+	return slices.Contains(permissions, p)
+}
+
+var rolePermissions = map[Role][]Permission{
+	RoleAdmin: {
+		PermUsersRead,
+		PermUsersWrite,
+		PermSessionsRevoke,
+		PermPostsWrite,
+		PermPostsModerate,
+		PermCommentsWrite,
+	},
+	RoleEditor: {
+		PermUsersRead,
+		PermPostsWrite,
+		PermPostsModerate,
+		PermCommentsWrite,
+	},
+	RoleMember: {
+		PermPostsWrite,
+		PermCommentsWrite,
+	},
+}
+
+func (r Role) Can(perm Permission) bool {
+	return slices.Contains(rolePermissions[r], perm)
+}
+
+// mfaRequiredRoles may only use their permissions from sessions that passed
+// two-factor authentication. Without it they can still sign in and enroll.
+var mfaRequiredRoles = []Role{RoleAdmin}
+
+func (r Role) RequiresMFA() bool {
+	return slices.Contains(mfaRequiredRoles, r)
+}
+
+// Can reports whether the token grants perm, taking the role's MFA
+// requirement and any API key scopes into account.
+func (c *UserClaims) Can(perm Permission) bool {
+	if c.Role.RequiresMFA() && !c.MFA {
+		return false
+	}
+	if c.Scopes != nil && !slices.Contains(c.Scopes, perm) {
+		return false
+	}
+	return c.Role.Can(perm)
+}
+
+// MissingMFA reports whether perm is refused only because the session did
+// not pass two-factor authentication.
+func (c *UserClaims) MissingMFA(perm Permission) bool {
+	return c.Role.RequiresMFA() && !c.MFA && c.Role.Can(perm)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestUserRBAC(t *testing.T) {
+	ts := newTestServer(t)
+	admin := ts.createUser("admin", RoleAdmin)
+	ts.createUser("editor", RoleEditor)
+	ts.createUser("member", RoleMember)
+
+	// Admins must pass two-factor authentication before using their role.
+	adminMFA, err := ts.generateJWTWithClaims(admin, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := map[string]string{
+		"anonymous":       "",
+		"member":          ts.login("member").Token,
+		"editor":          ts.login("editor").Token,
+		"admin":           ts.login("admin").Token,
+		"admin with mfa":  adminMFA,
+		"malformed token": "not-a-jwt",
+	}
+
+	newUser := map[string]any{
+		"username": "newuser",
+		"email":    "newuser@example.com",
+		"password": "a long password",
+		"isActive": true,
+		"role":     "member",
+	}
+
+	tests := []struct {
+		caller string
+		method string
+		body   any
+		want   int
+	}{
+		{"anonymous", http.MethodGet, nil, http.StatusUnauthorized},
+		{"malformed token", http.MethodGet, nil, http.StatusUnauthorized},
+		{"member", http.MethodGet, nil, http.StatusForbidden},
+		{"editor", http.MethodGet, nil, http.StatusOK},
+		{"admin", http.MethodGet, nil, http.StatusForbidden},
+		{"admin with mfa", http.MethodGet, nil, http.StatusOK},
+		{"member", http.MethodPost, newUser, http.StatusForbidden},
+		{"editor", http.MethodPost, newUser, http.StatusForbidden},
+		{"admin", http.MethodPost, newUser, http.StatusForbidden},
+		{"admin with mfa", http.MethodPost, newUser, http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.caller+" "+tt.method, func(t *testing.T) {
+			expectStatus(t, ts.do(tt.method, "/user", tokens[tt.caller], tt.body), tt.want)
+		})
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"html"
+	"regexp"
+	"strings"
+)
+
+const (
+	maxSearchQueryLength = 256
+	// searchConfig is the Postgres text search configuration. The posts.search
+	// column is generated with the same one.
+	searchConfig = "english"
+	// searchHeadlineOptions shape the ts_headline snippets.
+	searchHeadlineOptions = "StartSel=<mark>, StopSel=</mark>, MaxWords=35, MinWords=15, MaxFragments=2"
+	// memoryHeadlineWords is how many words the in-memory snippets show.
+	memoryHeadlineWords = 35
+)
+
+// SearchTerm is one part of a search query. Every term must match.
+type SearchTerm struct {
+	// Words are lowercase letters and digits only, so they are safe to pass
+	// to to_tsquery.
+	Words []string
+	// Phrase requires the words next to each other, in order.
+	Phrase bool
+	// Prefix matches the last word as a prefix.
+	Prefix bool
+}
+
+var (
+	searchTokenPattern = regexp.MustCompile(`"([^"]*)"?|(\S+)`)
+	searchWordPattern  = regexp.MustCompile(`[\p{L}\p{N}]+`)
+)
+
+// parseSearchQuery splits q into terms: "quoted phrases", words ending in *
+// for prefixes, and plain words.
+func parseSearchQuery(q string) []SearchTerm {
+	var terms []SearchTerm
+	for _, match := range searchTokenPattern.FindAllStringSubmatch(q, -1) {
+		term := SearchTerm{Phrase: strings.HasPrefix(match[0], `"`)}
+		text := match[1]
+		if !term.Phrase {
+			text = match[2]
+			term.Prefix = strings.HasSuffix(text, "*")
+		}
+
+		term.Words = searchWords(text)
+		if len(term.Words) > 0 {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+func searchWords(text string) []string {
+	return searchWordPattern.FindAllString(strings.ToLower(text), -1)
+}
+
+// pgTSQuery returns the tsquery expression for terms, adding its arguments to
+// conds.
+func pgTSQuery(conds *sqlConditions, terms []SearchTerm) string {
+	parts := make([]string, 0, len(terms))
+	for _, term := range terms {
+		text := strings.Join(term.Words, " ")
+		switch {
+		case term.Phrase:
+			parts = append(parts, "phraseto_tsquery('"+searchConfig+"', "+conds.arg(text)+")")
+		case term.Prefix:
+			text = strings.Join(term.Words, " & ") + ":*"
+			parts = append(parts, "to_tsquery('"+searchConfig+"', "+conds.arg(text)+")")
+		default:
+			parts = append(parts, "plainto_tsquery('"+searchConfig+"', "+conds.arg(text)+")")
+		}
+	}
+	return strings.Join(parts, " && ")
+}
+
+// Weights ts_rank gives title (A) and body (B) matches by default.
+const (
+	titleMatchWeight = 1.0
+	bodyMatchWeight  = 0.4
+)
+
+// matchPost is the in-memory stand-in for the Postgres search. It compares
+// words exactly, without stemming or stop words. It reports whether post
+// matches every term, and its rank if so.
+func matchPost(post Post, terms []SearchTerm) (float32, bool) {
+	title, body := searchWords(post.Title), searchWords(post.Body)
+
+	var rank float32
+	for _, term := range terms {
+		hits := titleMatchWeight*float32(countMatches(title, term)) + bodyMatchWeight*float32(countMatches(body, term))
+		if hits == 0 {
+			return 0, false
+		}
+		rank += hits
+	}
+	return rank / float32(len(title)+len(body)+1), true
+}
+
+// countMatches counts where term matches in words.
+func countMatches(words []string, term SearchTerm) int {
+	if !term.Phrase {
+		// Plain words may match anywhere, so count the rarest one.
+		count := -1
+		for i, word := range term.Words {
+			n := 0
+			for _, candidate := range words {
+				if candidate == word || term.Prefix && i == len(term.Words)-1 && strings.HasPrefix(candidate, word) {
+					n++
+				}
+			}
+			if count < 0 || n < count {
+				count = n
+			}
+		}
+		return count
+	}
+
+	count := 0
+	for start := 0; start+len(term.Words) <= len(words); start++ {
+		if wordsMatch(words[start:start+len(term.Words)], term.Words) {
+			count++
+		}
+	}
+	return count
+}
+
+func wordsMatch(words, want []string) bool {
+	for i := range want {
+		if words[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// memoryHeadline is the in-memory stand-in for ts_headline: an HTML-escaped
+// excerpt of text around the first match, with matching words in <mark>.
+func memoryHeadline(text string, terms []SearchTerm) string {
+	spans := searchWordPattern.FindAllStringIndex(text, -1)
+	if len(spans) == 0 {
+		return html.EscapeString(text)
+	}
+
+	matched := make([]bool, len(spans))
+	first := -1
+	for i, span := range spans {
+		word := strings.ToLower(text[span[0]:span[1]])
+		for _, term := range terms {
+			for j, want := range term.Words {
+				if word == want || term.Prefix && j == len(term.Words)-1 && strings.HasPrefix(word, want) {
+					matched[i] = true
+				}
+			}
+		}
+		if matched[i] && first < 0 {
+			first = i
+		}
+	}
+
+	start := max(0, first-memoryHeadlineWords/3)
+	end := min(len(spans), start+memoryHeadlineWords)
+
+	var b strings.Builder
+	pos := spans[start][0]
+	for i := start; i < end; i++ {
+		b.WriteString(html.EscapeString(text[pos:spans[i][0]]))
+		word := html.EscapeString(text[spans[i][0]:spans[i][1]])
+		if matched[i] {
+			word = "<mark>" + word + "</mark>"
+		}
+		b.WriteString(word)
+		pos = spans[i][1]
+	}
+	return b.String()
+}
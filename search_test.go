@@ -0,0 +1,198 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	tests := []struct {
+		q    string
+		want []SearchTerm
+	}{
+		{"", nil},
+		{"  ", nil},
+		{"Go channels", []SearchTerm{{Words: []string{"go"}}, {Words: []string{"channels"}}}},
+		{`"REST api" go`, []SearchTerm{{Words: []string{"rest", "api"}, Phrase: true}, {Words: []string{"go"}}}},
+		{`"unterminated phrase`, []SearchTerm{{Words: []string{"unterminated", "phrase"}, Phrase: true}}},
+		{`"" go`, []SearchTerm{{Words: []string{"go"}}}},
+		{"concurr*", []SearchTerm{{Words: []string{"concurr"}, Prefix: true}}},
+		{"*", nil},
+		{"foo-bar", []SearchTerm{{Words: []string{"foo", "bar"}}}},
+		{"C++ & go!", []SearchTerm{{Words: []string{"c"}}, {Words: []string{"go"}}}},
+		{"'; DROP TABLE posts; --", []SearchTerm{{Words: []string{"drop"}}, {Words: []string{"table"}}, {Words: []string{"posts"}}}},
+		{"Straße 42", []SearchTerm{{Words: []string{"straße"}}, {Words: []string{"42"}}}},
+	}
+	for _, tt := range tests {
+		if got := parseSearchQuery(tt.q); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseSearchQuery(%q) = %+v, want %+v", tt.q, got, tt.want)
+		}
+	}
+}
+
+func TestMatchPost(t *testing.T) {
+	tests := []struct {
+		name  string
+		post  Post
+		q     string
+		match bool
+	}{
+		{"word in title", Post{Title: "Go channels"}, "go", true},
+		{"word in body", Post{Title: "Notes", Body: "About Go."}, "go", true},
+		{"missing word", Post{Title: "Go channels"}, "rust", false},
+		{"every term must match", Post{Title: "Go channels"}, "go rust", false},
+		{"words match whole words", Post{Title: "Gopher"}, "go", false},
+		{"phrase in order", Post{Body: "a quick brown fox"}, `"quick brown"`, true},
+		{"phrase out of order", Post{Body: "a brown quick fox"}, `"quick brown"`, false},
+		{"phrase split across title and body", Post{Title: "quick", Body: "brown"}, `"quick brown"`, false},
+		{"prefix", Post{Title: "Concurrency in Go"}, "concurr*", true},
+		{"prefix on the last word only", Post{Title: "Concurrency in Go"}, "concurr go*", false},
+		{"no prefix without *", Post{Title: "Concurrency in Go"}, "concurr", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rank, ok := matchPost(tt.post, parseSearchQuery(tt.q))
+			if ok != tt.match {
+				t.Fatalf("matchPost(%q) matched = %v, want %v", tt.q, ok, tt.match)
+			}
+			if ok && rank <= 0 {
+				t.Errorf("matchPost(%q) rank = %v, want > 0", tt.q, rank)
+			}
+		})
+	}
+
+	// Title matches outrank body matches, and more matches outrank fewer.
+	terms := parseSearchQuery("go")
+	ranked := []Post{
+		{Title: "go go", Body: "other words"},
+		{Title: "go", Body: "other words"},
+		{Title: "other", Body: "go words"},
+		{Title: "other", Body: "go words and a lot more besides"},
+	}
+	prev := float32(0)
+	for i, post := range ranked {
+		rank, ok := matchPost(post, terms)
+		if !ok {
+			t.Fatalf("matchPost(%+v) did not match", post)
+		}
+		if i > 0 && rank >= prev {
+			t.Errorf("matchPost(%+v) rank = %v, want less than %v", post, rank, prev)
+		}
+		prev = rank
+	}
+}
+
+func TestMemoryHeadline(t *testing.T) {
+	long := make([]string, 100)
+	for i := range long {
+		long[i] = "w"
+	}
+	long[60] = "go"
+
+	tests := []struct {
+		name string
+		text string
+		q    string
+		want string
+	}{
+		{"marks matches", "Go has channels, and go has goroutines.", "go", "<mark>Go</mark> has channels, and <mark>go</mark> has goroutines"},
+		{"marks prefixes", "Concurrency is not parallelism", "concurr*", "<mark>Concurrency</mark> is not parallelism"},
+		{"marks phrase words", "a quick brown fox", `"quick brown"`, "a <mark>quick</mark> <mark>brown</mark> fox"},
+		{"escapes HTML", `x <script>alert("go")</script> & y`, "go", "x &lt;script&gt;alert(&#34;<mark>go</mark>&#34;)&lt;/script&gt; &amp; y"},
+		{"escapes text without words", "<&>", "go", "&lt;&amp;&gt;"},
+		{"starts before the first match", strings.Join(long, " "), "go", strings.Repeat("w ", 11) + "<mark>go</mark>" + strings.Repeat(" w", 23)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := memoryHeadline(tt.text, parseSearchQuery(tt.q)); got != tt.want {
+				t.Errorf("memoryHeadline(%q, %q) = %q, want %q", tt.text, tt.q, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSearchPosts(t *testing.T) {
+	ts := newTestServer(t)
+	author := ts.createUser("alice", RoleMember)
+
+	posts := []Post{
+		{Title: "Go go go", Body: "go <b>fast</b> & safe"},
+		{Title: "Go basics"},
+		{Title: "Learning go"},
+		{Title: "Other", Body: "go is fun"},
+		{Title: "Another post", Body: "written in go with care"},
+		{Title: "Rust", Body: "nothing here"},
+	}
+	for i := range posts {
+		posts[i].UserId = author.ID
+		if err := ts.Posts.Create(context.Background(), &posts[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	search := func(params url.Values) Page[SearchResult] {
+		t.Helper()
+		return decodeResponse[Page[SearchResult]](t, ts.do(http.MethodGet, "/post/search?"+params.Encode(), "", nil), http.StatusOK)
+	}
+
+	all := search(url.Values{"q": {"go"}})
+	if len(all.Data) != 5 || all.NextCursor != "" {
+		t.Fatalf("search returned %d results and cursor %q, want 5 and none", len(all.Data), all.NextCursor)
+	}
+	if all.Data[0].ID != posts[0].ID {
+		t.Errorf("best match is post %d, want %d", all.Data[0].ID, posts[0].ID)
+	}
+	for i := 1; i < len(all.Data); i++ {
+		if all.Data[i].Rank > all.Data[i-1].Rank {
+			t.Errorf("result %d has rank %v, above the previous %v", i, all.Data[i].Rank, all.Data[i-1].Rank)
+		}
+	}
+	if want := "<mark>go</mark> &lt;b&gt;fast&lt;/b&gt; &amp; safe"; all.Data[0].Headline != want {
+		t.Errorf("headline = %q, want %q", all.Data[0].Headline, want)
+	}
+
+	// Paging two at a time splits the tied ranks of posts 2 and 3 across
+	// pages, and still returns every result once, in the same order.
+	var paged []SearchResult
+	params := url.Values{"q": {"go"}, "limit": {"2"}}
+	for range len(all.Data) {
+		page := search(params)
+		paged = append(paged, page.Data...)
+		if page.NextCursor == "" {
+			break
+		}
+		params.Set("after", page.NextCursor)
+	}
+	if len(paged) != len(all.Data) {
+		t.Fatalf("paging returned %d results, want %d", len(paged), len(all.Data))
+	}
+	for i := range paged {
+		if paged[i].ID != all.Data[i].ID {
+			t.Errorf("paged result %d is post %d, want %d", i, paged[i].ID, all.Data[i].ID)
+		}
+	}
+
+	// Paging back returns the previous page.
+	second := search(url.Values{"q": {"go"}, "limit": {"2"}, "after": {search(url.Values{"q": {"go"}, "limit": {"2"}}).NextCursor}})
+	first := search(url.Values{"q": {"go"}, "limit": {"2"}, "before": {second.PrevCursor}})
+	if len(first.Data) != 2 || first.Data[0].ID != all.Data[0].ID || first.Data[1].ID != all.Data[1].ID {
+		t.Errorf("paging back returned %+v, want the first two results", first.Data)
+	}
+
+	phrase := search(url.Values{"q": {`"in go"`}})
+	if len(phrase.Data) != 1 || phrase.Data[0].ID != posts[4].ID {
+		t.Errorf("phrase search returned %+v, want post %d only", phrase.Data, posts[4].ID)
+	}
+	prefix := search(url.Values{"q": {"learn*"}})
+	if len(prefix.Data) != 1 || prefix.Data[0].ID != posts[2].ID {
+		t.Errorf("prefix search returned %+v, want post %d only", prefix.Data, posts[2].ID)
+	}
+
+	expectStatus(t, ts.do(http.MethodGet, "/post/search", "", nil), http.StatusBadRequest)
+	expectStatus(t, ts.do(http.MethodGet, "/post/search?q=%22%22", "", nil), http.StatusBadRequest)
+	expectStatus(t, ts.do(http.MethodGet, "/post/search?q="+strings.Repeat("a", maxSearchQueryLength+1), "", nil), http.StatusBadRequest)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"net/netip"
+	"sync/atomic"
+)
+
+// Server holds the dependencies shared by the HTTP handlers.
+type Server struct {
+	Config    *Config
+	Keys      *KeyManager
+	Mailer    Mailer
+	Passwords *Passwords
+	// PasswordPolicy applies to every new password, not to existing ones.
+	PasswordPolicy *PasswordPolicy
+	// OIDC is nil unless single sign-on is configured.
+	OIDC *OIDCProvider
+	Stores
+
+	resendLimiter  *IntervalLimiter
+	userStatus     *UserStatusCache
+	trustedProxies []netip.Prefix
+
+	ready atomic.Bool
+}
+
+func NewServer(cfg *Config, stores Stores, keys *KeyManager, mailer Mailer, policy *PasswordPolicy) *Server {
+	// The config was validated when it was loaded.
+	trustedProxies, _ := parseTrustedProxies(cfg.TrustedProxies)
+
+	return &Server{
+		Config:         cfg,
+		Keys:           keys,
+		Mailer:         mailer,
+		Passwords:      NewPasswords(cfg),
+		PasswordPolicy: policy,
+		OIDC:           NewOIDCProvider(cfg),
+		Stores:         stores,
+
+		resendLimiter:  NewIntervalLimiter(cfg.VerificationResendInterval),
+		userStatus:     NewUserStatusCache(stores.Users, cfg.UserStatusCacheTTL),
+		trustedProxies: trustedProxies,
+	}
+}
+
+func (s *Server) Routes() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/", IndexHandler)
+	mux.HandleFunc("/time", GetTimeHandler)
+	mux.HandleFunc("/ip", GetIPHandler)
+	mux.HandleFunc("/healthz", HealthHandler)
+	mux.HandleFunc("/readyz", s.ReadyHandler)
+	mux.HandleFunc("/dummyPost", DummyPostHandler)
+	mux.Handle("/post", s.Authorize(Policy{
+		http.MethodPost:   PermPostsWrite,
+		http.MethodPut:    PermPostsWrite,
+		http.MethodDelete: PermPostsWrite,
+	}, http.HandlerFunc(s.PostHandler)))
+	mux.HandleFunc("/post/search", s.PostSearchHandler)
+	mux.Handle("/post/{id}/comments", s.Authorize(Policy{
+		http.MethodPost: PermCommentsWrite,
+	}, http.HandlerFunc(s.CommentsHandler)))
+	mux.Handle("/post/{id}/comments/{commentId}", s.Authorize(Policy{
+		http.MethodPut:    PermCommentsWrite,
+		http.MethodDelete: PermCommentsWrite,
+	}, http.HandlerFunc(s.CommentHandler)))
+	mux.HandleFunc("/tags", s.TagsHandler)
+	mux.Handle("/user", s.Authorize(Policy{
+		http.MethodGet:    PermUsersRead,
+		http.MethodPost:   PermUsersWrite,
+		http.MethodPut:    PermUsersWrite,
+		http.MethodPatch:  PermUsersWrite,
+		http.MethodDelete: PermUsersWrite,
+	}, http.HandlerFunc(s.UserHandler)))
+	mux.Handle("/user/password", s.AuthMiddleware(RequireSession(http.HandlerFunc(s.ChangePasswordHandler))))
+	mux.HandleFunc("/password/forgot", s.ForgotPasswordHandler)
+	mux.HandleFunc("/password/reset", s.ResetPasswordHandler)
+	mux.HandleFunc("/signup", s.SignupHandler)
+	mux.HandleFunc("/verify-email", s.VerifyEmailHandler)
+	mux.HandleFunc("/verify-email/resend", s.ResendVerificationHandler)
+	mux.HandleFunc("/login", s.LoginHandler)
+	mux.HandleFunc("/login/mfa", s.LoginMFAHandler)
+	mux.HandleFunc("/login/oidc", s.OIDCLoginHandler)
+	mux.HandleFunc("/login/oidc/callback", s.OIDCCallbackHandler)
+	mux.Handle("/me", s.AuthMiddleware(RequireSession(http.HandlerFunc(s.MeHandler))))
+	mux.Handle("/me/mfa/totp", s.AuthMiddleware(RequireSession(http.HandlerFunc(s.TOTPHandler))))
+	mux.Handle("/me/mfa/totp/confirm", s.AuthMiddleware(RequireSession(http.HandlerFunc(s.ConfirmTOTPHandler))))
+	mux.Handle("/me/mfa/recovery-codes", s.AuthMiddleware(RequireSession(http.HandlerFunc(s.RecoveryCodesHandler))))
+	mux.Handle("/me/api-keys", s.AuthMiddleware(RequireSession(http.HandlerFunc(s.APIKeysHandler))))
+	mux.HandleFunc("/token/refresh", s.RefreshTokenHandler)
+	mux.HandleFunc("/.well-known/jwks.json", s.JWKSHandler)
+	mux.Handle("/logout", s.AuthMiddleware(RequireSession(http.HandlerFunc(s.LogoutHandler))))
+	mux.Handle("/user/sessions/revoke", s.AuthMiddleware(RequirePermission(PermSessionsRevoke)(http.HandlerFunc(s.RevokeSessionsHandler))))
+	mux.Handle("/user/mfa", s.AuthMiddleware(RequirePermission(PermUsersWrite)(http.HandlerFunc(s.ResetUserMFAHandler))))
+	mux.Handle("/user/deactivate", s.AuthMiddleware(RequirePermission(PermUsersWrite)(http.HandlerFunc(s.DeactivateUserHandler))))
+	mux.Handle("/user/reactivate", s.AuthMiddleware(RequirePermission(PermUsersWrite)(http.HandlerFunc(s.ReactivateUserHandler))))
+	mux.Handle("/user/lockout", s.Authorize(Policy{
+		http.MethodGet:    PermUsersRead,
+		http.MethodDelete: PermUsersWrite,
+	}, http.HandlerFunc(s.LockoutHandler)))
+	mux.Handle("/user/api-keys", s.Authorize(Policy{
+		http.MethodGet:    PermUsersRead,
+		http.MethodDelete: PermUsersWrite,
+	}, http.HandlerFunc(s.UserAPIKeysHandler)))
+
+	return LogMiddleware(mux)
+}
+
+func (s *Server) SetReady(ready bool) {
+	s.ready.Store(ready)
+}
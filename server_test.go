@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testPassword is the password of every user made by createUser.
+const testPassword = "correct horse"
+
+// testServer serves the routes on the memory stores.
+type testServer struct {
+	*Server
+	t      *testing.T
+	srv    *httptest.Server
+	mailer *testMailer
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	return newTestServerWithConfig(t, testConfig())
+}
+
+// testConfig is the default config with cheap password hashing.
+func testConfig() *Config {
+	cfg := DefaultConfig()
+	cfg.Store = "memory"
+	cfg.PasswordHasher = "bcrypt"
+	cfg.BcryptCost = bcrypt.MinCost
+	cfg.SessionCookieSecure = false
+	return cfg
+}
+
+func newTestServerWithConfig(t *testing.T, cfg *Config) *testServer {
+	t.Helper()
+
+	mailer := &testMailer{}
+	policy := &PasswordPolicy{MinLength: 8, MaxLength: 72}
+	s := NewServer(cfg, NewMemoryStores(), NewHMACKeyManager("test-secret"), mailer, policy)
+
+	srv := httptest.NewServer(s.Routes())
+	t.Cleanup(srv.Close)
+
+	return &testServer{Server: s, t: t, srv: srv, mailer: mailer}
+}
+
+// createUser adds an active user with a verified email and testPassword.
+func (ts *testServer) createUser(username string, role Role) User {
+	ts.t.Helper()
+
+	hash, err := ts.Passwords.Hash(testPassword)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+
+	now := time.Now()
+	user := User{
+		Username:        username,
+		Email:           username + "@example.com",
+		Password:        hash,
+		IsActive:        true,
+		Role:            role,
+		EmailVerifiedAt: &now,
+	}
+	if err := ts.Users.Create(context.Background(), &user); err != nil {
+		ts.t.Fatal(err)
+	}
+	return user
+}
+
+// enableTOTP turns on two-factor authentication for user and returns the
+// secret and recovery codes.
+func (ts *testServer) enableTOTP(user User) (string, []string) {
+	ts.t.Helper()
+
+	secret, err := generateTOTPSecret()
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if err := ts.MFA.SetPendingTOTP(ctx, user.ID, secret); err != nil {
+		ts.t.Fatal(err)
+	}
+	if err := ts.MFA.EnableTOTP(ctx, user.ID, hashes); err != nil {
+		ts.t.Fatal(err)
+	}
+	return secret, codes
+}
+
+// login logs username in with testPassword and returns its tokens.
+func (ts *testServer) login(username string) TokenResponse {
+	ts.t.Helper()
+
+	resp := ts.do(http.MethodPost, "/login", "", map[string]string{"username": username, "password": testPassword})
+	return decodeResponse[TokenResponse](ts.t, resp, http.StatusOK)
+}
+
+// accessToken signs an access token for user as if it had been issued at
+// issuedAt.
+func (ts *testServer) accessToken(user User, mfa bool, issuedAt time.Time) string {
+	ts.t.Helper()
+
+	token, err := ts.Keys.Sign(UserClaims{
+		ID:       user.ID,
+		Username: user.Username,
+		Role:     user.Role,
+		MFA:      mfa,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        fmt.Sprintf("test-%d-%d", user.ID, issuedAt.UnixNano()),
+			Issuer:    ts.tokenIssuer(),
+			Subject:   strconv.Itoa(user.ID),
+			Audience:  jwt.ClaimStrings{accessTokenAudience},
+			IssuedAt:  jwt.NewNumericDate(issuedAt),
+			ExpiresAt: jwt.NewNumericDate(issuedAt.Add(ts.Config.AccessTokenTTL)),
+		},
+	})
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	return token
+}
+
+// do sends body as JSON, or as is when it is a string, with token as the
+// bearer token unless it is empty.
+func (ts *testServer) do(method, path, token string, body any) *http.Response {
+	ts.t.Helper()
+
+	var reader io.Reader
+	switch body := body.(type) {
+	case nil:
+	case string:
+		reader = strings.NewReader(body)
+	default:
+		data, err := json.Marshal(body)
+		if err != nil {
+			ts.t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, ts.srv.URL+path, reader)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return ts.send(req)
+}
+
+func (ts *testServer) send(req *http.Request) *http.Response {
+	ts.t.Helper()
+
+	resp, err := ts.srv.Client().Do(req)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	ts.t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// expectStatus fails the test unless resp has the wanted status.
+func expectStatus(t *testing.T, resp *http.Response, want int) {
+	t.Helper()
+
+	if resp.StatusCode != want {
+		body, _ := io.ReadAll(resp.Body)
+		t.Fatalf("%s %s: status %d, want %d: %s", resp.Request.Method, resp.Request.URL.Path, resp.StatusCode, want, bytes.TrimSpace(body))
+	}
+}
+
+// decodeResponse checks the status and decodes the JSON body.
+func decodeResponse[T any](t *testing.T, resp *http.Response, want int) T {
+	t.Helper()
+
+	expectStatus(t, resp, want)
+	var v T
+	if err := json.NewDecoder(resp.Body).Decode(&v); err != nil {
+		t.Fatalf("%s %s: decoding response: %v", resp.Request.Method, resp.Request.URL.Path, err)
+	}
+	return v
+}
+
+// testMailer keeps sent messages instead of delivering them.
+type testMailer struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (m *testMailer) Send(_ context.Context, msg Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// last waits briefly for a message, since some are sent in the background,
+// and returns the latest one.
+func (m *testMailer) last(t *testing.T) Message {
+	t.Helper()
+
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		m.mu.Lock()
+		n := len(m.messages)
+		m.mu.Unlock()
+		if n > 0 {
+			break
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.messages) == 0 {
+		t.Fatal("no message was sent")
+	}
+	return m.messages[len(m.messages)-1]
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"time"
+)
+
+// Cookie sessions keep the session token in an HttpOnly cookie. Unsafe
+// requests must also echo the readable CSRF cookie in csrfHeaderName, which a
+// cross-site form or fetch cannot do.
+const (
+	sessionCookieName = "session"
+	csrfCookieName    = "csrf_token"
+	csrfHeaderName    = "X-CSRF-Token"
+)
+
+// startSession creates a session for user and sets its cookies on w.
+func (s *Server) startSession(ctx context.Context, w http.ResponseWriter, user User, mfa bool) (SessionResponse, error) {
+	token, tokenHash, err := generateOpaqueToken()
+	if err != nil {
+		return SessionResponse{}, err
+	}
+
+	csrfToken, csrfTokenHash, err := generateOpaqueToken()
+	if err != nil {
+		return SessionResponse{}, err
+	}
+
+	session := Session{
+		UserID:        user.ID,
+		TokenHash:     tokenHash,
+		CSRFTokenHash: csrfTokenHash,
+		MFA:           mfa,
+		ExpiresAt:     time.Now().Add(s.Config.SessionTTL),
+	}
+	if err := s.Sessions.Create(ctx, &session); err != nil {
+		return SessionResponse{}, err
+	}
+
+	http.SetCookie(w, s.sessionCookie(sessionCookieName, token, true, session.ExpiresAt))
+	http.SetCookie(w, s.sessionCookie(csrfCookieName, csrfToken, false, session.ExpiresAt))
+
+	return SessionResponse{
+		CSRFToken: csrfToken,
+		ExpiresIn: int(s.Config.SessionTTL.Seconds()),
+	}, nil
+}
+
+// clearSessionCookies tells the browser to drop both session cookies.
+func (s *Server) clearSessionCookies(w http.ResponseWriter) {
+	for _, name := range []string{sessionCookieName, csrfCookieName} {
+		cookie := s.sessionCookie(name, "", name == sessionCookieName, time.Unix(0, 0))
+		cookie.MaxAge = -1
+		http.SetCookie(w, cookie)
+	}
+}
+
+func (s *Server) sessionCookie(name, value string, httpOnly bool, expires time.Time) *http.Cookie {
+	sameSite := http.SameSiteLaxMode
+	switch s.Config.SessionCookieSameSite {
+	case "strict":
+		sameSite = http.SameSiteStrictMode
+	case "none":
+		sameSite = http.SameSiteNoneMode
+	}
+
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		Domain:   s.Config.SessionCookieDomain,
+		Expires:  expires,
+		Secure:   s.Config.SessionCookieSecure,
+		HttpOnly: httpOnly,
+		SameSite: sameSite,
+	}
+}
+
+// validCSRF checks the double-submitted token: the header must match the
+// cookie, and both must be the token issued with session, so a cookie planted
+// by a sibling subdomain is not enough.
+func validCSRF(r *http.Request, session Session) bool {
+	header := r.Header.Get(csrfHeaderName)
+	cookie, err := r.Cookie(csrfCookieName)
+	if header == "" || err != nil {
+		return false
+	}
+
+	return subtle.ConstantTimeCompare([]byte(header), []byte(cookie.Value)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(hashToken(header)), []byte(session.CSRFTokenHash)) == 1
+}
+
+// isSafeMethod reports whether method is read-only by HTTP semantics.
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestValidCSRF(t *testing.T) {
+	session := Session{CSRFTokenHash: hashToken("token-a")}
+
+	tests := []struct {
+		name   string
+		header string
+		cookie string
+		want   bool
+	}{
+		{"matching token", "token-a", "token-a", true},
+		{"missing header", "", "token-a", false},
+		{"missing cookie", "token-a", "", false},
+		{"header differs from cookie", "token-a", "token-b", false},
+		{"token from another session", "token-b", "token-b", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/logout", nil)
+			if tt.header != "" {
+				r.Header.Set(csrfHeaderName, tt.header)
+			}
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: csrfCookieName, Value: tt.cookie})
+			}
+			if got := validCSRF(r, session); got != tt.want {
+				t.Errorf("validCSRF() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSessionRequiresCSRF(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser("alice", RoleMember)
+	ts.createUser("bob", RoleMember)
+
+	startSession := func(username string) (session, csrf string) {
+		t.Helper()
+		resp := ts.do(http.MethodPost, "/login", "", map[string]any{"username": username, "password": testPassword, "session": true})
+		body := decodeResponse[SessionResponse](t, resp, http.StatusOK)
+		for _, cookie := range resp.Cookies() {
+			if cookie.Name == sessionCookieName {
+				session = cookie.Value
+			}
+		}
+		if session == "" || body.CSRFToken == "" {
+			t.Fatalf("session login set no session cookie or CSRF token")
+		}
+		return session, body.CSRFToken
+	}
+	aliceSession, aliceCSRF := startSession("alice")
+	_, bobCSRF := startSession("bob")
+
+	request := func(method, header, cookie string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, ts.srv.URL+"/logout", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.AddCookie(&http.Cookie{Name: sessionCookieName, Value: aliceSession})
+		if cookie != "" {
+			req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: cookie})
+		}
+		if header != "" {
+			req.Header.Set(csrfHeaderName, header)
+		}
+		return ts.send(req)
+	}
+
+	expectStatus(t, request(http.MethodPost, "", aliceCSRF), http.StatusForbidden)
+	expectStatus(t, request(http.MethodPost, aliceCSRF, bobCSRF), http.StatusForbidden)
+	expectStatus(t, request(http.MethodPost, bobCSRF, bobCSRF), http.StatusForbidden)
+	// Safe methods need no token; logout itself only accepts POST.
+	expectStatus(t, request(http.MethodGet, "", ""), http.StatusMethodNotAllowed)
+	expectStatus(t, request(http.MethodPost, aliceCSRF, aliceCSRF), http.StatusOK)
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrNotFound = errors.New("record not found")
+	ErrConflict = errors.New("record already exists")
+)
+
+// Stores groups every store the server depends on.
+type Stores struct {
+	Users         UserStore
+	Posts         PostStore
+	RefreshTokens RefreshTokenStore
+	Denylist      TokenDenylist
+	ResetTokens   PasswordResetStore
+	MFA           MFAStore
+	LoginAttempts LoginAttemptStore
+	APIKeys       APIKeyStore
+	Sessions      SessionStore
+	Identities    IdentityStore
+	Comments      CommentStore
+}
+
+// UserStore persists users. Get and List never return password hashes;
+// GetByUsername does, for credential checks.
+type UserStore interface {
+	Create(ctx context.Context, user *User) error
+	Get(ctx context.Context, id int) (User, error)
+	GetByUsername(ctx context.Context, username string) (User, error)
+	GetByEmail(ctx context.Context, email string) (User, error)
+	// List returns up to q.Limit+1 users matching filter, read from q's
+	// cursor in q's direction. The extra row tells the caller another page
+	// follows.
+	List(ctx context.Context, filter UserFilter, q ListQuery) ([]User, error)
+	// Update leaves the stored password hash unchanged when user.Password
+	// is empty. It never changes IsActive or the deactivation fields, which
+	// only SetActive does, and fills them in from the stored user.
+	Update(ctx context.Context, user *User) error
+	UpdatePassword(ctx context.Context, id int, passwordHash string) error
+	// SetEmailVerified records when the email was verified; nil marks it
+	// unverified again.
+	SetEmailVerified(ctx context.Context, id int, verifiedAt *time.Time) error
+	// SetActive activates or deactivates the user. The reason is kept only
+	// while the user is inactive.
+	SetActive(ctx context.Context, id int, active bool, reason string) error
+	Delete(ctx context.Context, id int) error
+	// ExistsByUsernameOrEmail reports whether another user, other than
+	// excludeID, already uses the username or email.
+	ExistsByUsernameOrEmail(ctx context.Context, username, email string, excludeID int) (bool, error)
+}
+
+// UserFilter narrows a user listing. Zero fields match every user.
+type UserFilter struct {
+	IsActive     *bool
+	Role         Role
+	CreatedAfter *time.Time
+}
+
+var userSortFields = map[string]SortField[User]{
+	"id":        {Column: "id", Value: func(u User) any { return u.ID }, ID: userID},
+	"username":  {Column: "username", Value: func(u User) any { return u.Username }, ID: userID},
+	"email":     {Column: "email", Value: func(u User) any { return u.Email }, ID: userID},
+	"createdAt": {Column: "created_at", Value: func(u User) any { return u.CreatedAt }, ID: userID},
+}
+
+func userID(u User) int { return u.ID }
+
+type PostStore interface {
+	Create(ctx context.Context, post *Post) error
+	Get(ctx context.Context, id int) (Post, error)
+	// List returns up to q.Limit+1 posts matching filter, like
+	// UserStore.List.
+	List(ctx context.Context, filter PostFilter, q ListQuery) ([]Post, error)
+	// Search returns up to q.Limit+1 posts matching every term and filter,
+	// like List.
+	Search(ctx context.Context, terms []SearchTerm, filter PostFilter, q ListQuery) ([]SearchResult, error)
+	// Update replaces the post's tags with post.Tags.
+	Update(ctx context.Context, post *Post) error
+	Delete(ctx context.Context, id int) error
+	// Tags lists the tags in use, most used first.
+	Tags(ctx context.Context) ([]Tag, error)
+}
+
+// PostFilter narrows a post listing. Zero fields match every post.
+type PostFilter struct {
+	UserID       int
+	CreatedAfter *time.Time
+	Category     string
+	// Tags must all be on a post, or with AnyTag at least one of them.
+	Tags   []string
+	AnyTag bool
+}
+
+var postSortFields = map[string]SortField[Post]{
+	"id":        {Column: "id", Value: func(p Post) any { return p.ID }, ID: postID},
+	"title":     {Column: "title", Value: func(p Post) any { return p.Title }, ID: postID},
+	"createdAt": {Column: "created_at", Value: func(p Post) any { return p.CreatedAt }, ID: postID},
+}
+
+func postID(p Post) int { return p.ID }
+
+var searchSortFields = map[string]SortField[SearchResult]{
+	"rank":      {Column: "rank", Value: func(r SearchResult) any { return r.Rank }, ID: searchResultID},
+	"id":        {Column: "id", Value: func(r SearchResult) any { return r.ID }, ID: searchResultID},
+	"createdAt": {Column: "created_at", Value: func(r SearchResult) any { return r.CreatedAt }, ID: searchResultID},
+}
+
+func searchResultID(r SearchResult) int { return r.ID }
+
+// CommentStore persists comments. Reads fill in ReplyCount but not Replies.
+type CommentStore interface {
+	Create(ctx context.Context, comment *Comment) error
+	Get(ctx context.Context, id int) (Comment, error)
+	// List returns up to q.Limit+1 comments on the post that reply to
+	// parentID, or top-level comments when parentID is 0, like
+	// PostStore.List.
+	List(ctx context.Context, postID, parentID int, q ListQuery) ([]Comment, error)
+	// Descendants returns the replies to the given comments, down to depth
+	// levels below them, oldest first.
+	Descendants(ctx context.Context, ids []int, depth int) ([]Comment, error)
+	// Update saves a new body and sets EditedAt. It returns ErrNotFound for
+	// deleted comments, so an edit cannot race a delete and restore the body.
+	Update(ctx context.Context, comment *Comment) error
+	// Delete clears the body and sets DeletedAt, leaving replies in place.
+	Delete(ctx context.Context, id int) error
+}
+
+var commentSortFields = map[string]SortField[Comment]{
+	"createdAt": {Column: "created_at", Value: func(c Comment) any { return c.CreatedAt }, ID: commentID},
+}
+
+func commentID(c Comment) int { return c.ID }
+
+// parentOf returns the comment's parent ID, or 0 for top-level comments.
+func parentOf(comment Comment) int {
+	if comment.ParentID == nil {
+		return 0
+	}
+	return *comment.ParentID
+}
+
+// RefreshTokenStore persists hashed refresh tokens. Tokens issued by rotating
+// one another share a FamilyID.
+type RefreshTokenStore interface {
+	Create(ctx context.Context, token *RefreshToken) error
+	GetByHash(ctx context.Context, tokenHash string) (RefreshToken, error)
+	// MarkUsed flags the token as rotated. It reports false if the token
+	// had already been used, which means it is being replayed.
+	MarkUsed(ctx context.Context, id int) (bool, error)
+	RevokeFamily(ctx context.Context, familyID string) error
+	RevokeUser(ctx context.Context, userID int) error
+}
+
+// TokenDenylist records access tokens that must be rejected before they
+// expire. Entries only need to outlive the tokens they block, so both
+// methods take the time after which the entry may be evicted.
+type TokenDenylist interface {
+	// Revoke blocks the single token with the given JWT ID.
+	Revoke(ctx context.Context, jti string, expiresAt time.Time) error
+	// RevokeUser blocks every token for userID issued before revokedBefore.
+	// Token iat claims have whole seconds, so revokedBefore is truncated to
+	// the second; otherwise a token issued right after the revocation, in
+	// the same second, would be blocked too.
+	RevokeUser(ctx context.Context, userID int, revokedBefore, expiresAt time.Time) error
+	IsRevoked(ctx context.Context, claims *UserClaims) (bool, error)
+}
+
+type PasswordResetStore interface {
+	Create(ctx context.Context, token *PasswordResetToken) error
+	// Lookup returns the token if it is unused and unexpired, without
+	// consuming it.
+	Lookup(ctx context.Context, tokenHash string) (PasswordResetToken, error)
+	// Consume marks the unused, unexpired token with the given hash as used
+	// and returns it, or returns ErrNotFound.
+	Consume(ctx context.Context, tokenHash string) (PasswordResetToken, error)
+	// InvalidateUser marks every outstanding token of the user as used.
+	InvalidateUser(ctx context.Context, userID int) error
+}
+
+// MFAStore keeps each user's TOTP secret and hashed recovery codes.
+type MFAStore interface {
+	GetTOTP(ctx context.Context, userID int) (TOTPSecret, error)
+	// SetPendingTOTP stores a new unconfirmed secret, replacing any earlier
+	// pending one.
+	SetPendingTOTP(ctx context.Context, userID int, secret string) error
+	// EnableTOTP confirms the pending secret and replaces the user's
+	// recovery codes.
+	EnableTOTP(ctx context.Context, userID int, recoveryCodeHashes []string) error
+	// DisableTOTP removes the secret and every recovery code.
+	DisableTOTP(ctx context.Context, userID int) error
+	// UseTOTPStep records that a code for step was accepted. It reports false
+	// if that step or a later one was already used.
+	UseTOTPStep(ctx context.Context, userID int, step int64) (bool, error)
+	ReplaceRecoveryCodes(ctx context.Context, userID int, codeHashes []string) error
+	// UseRecoveryCode marks an unused code as used and reports whether one
+	// matched.
+	UseRecoveryCode(ctx context.Context, userID int, codeHash string) (bool, error)
+}
+
+// LoginAttemptStore counts recent failed logins per key, which is either a
+// username or a client IP.
+type LoginAttemptStore interface {
+	Get(ctx context.Context, key string) (LoginAttempts, error)
+	// Reserve counts an attempt as a failure before its outcome is known, so
+	// parallel attempts cannot all get in under the limit. It first passes
+	// the attempts so far to wait, and if that returns more than zero it
+	// counts nothing and returns the wait. A count whose last failure is
+	// older than window starts again from zero. It returns the attempts
+	// before this one.
+	Reserve(ctx context.Context, key string, window time.Duration, wait func(LoginAttempts) time.Duration) (LoginAttempts, time.Duration, error)
+	// Release takes back a reserved attempt that did not fail, given the
+	// attempts Reserve returned for it.
+	Release(ctx context.Context, previous LoginAttempts) error
+	Reset(ctx context.Context, key string) error
+}
+
+// APIKeyStore persists hashed API keys. Revoked and expired keys are kept so
+// they still show up in listings.
+type APIKeyStore interface {
+	// Create fails with ErrConflict if the prefix is already taken.
+	Create(ctx context.Context, key *APIKey) error
+	Get(ctx context.Context, id int) (APIKey, error)
+	GetByPrefix(ctx context.Context, prefix string) (APIKey, error)
+	ListByUser(ctx context.Context, userID int) ([]APIKey, error)
+	// Revoke marks the key revoked. Revoking it again is not an error.
+	Revoke(ctx context.Context, id int) error
+	Touch(ctx context.Context, id int, usedAt time.Time) error
+}
+
+// SessionStore persists cookie sessions.
+type SessionStore interface {
+	Create(ctx context.Context, session *Session) error
+	// Get returns the unexpired session with the given token hash.
+	Get(ctx context.Context, tokenHash string) (Session, error)
+	Delete(ctx context.Context, id int) error
+	// DeleteUser ends every session of the user except keepID, which may be
+	// zero.
+	DeleteUser(ctx context.Context, userID, keepID int) error
+}
+
+// IdentityStore links users to accounts at the OIDC provider. An issuer and
+// subject pair belongs to at most one user.
+type IdentityStore interface {
+	Get(ctx context.Context, issuer, subject string) (Identity, error)
+	// Create fails with ErrConflict if the pair is already linked.
+	Create(ctx context.Context, identity *Identity) error
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxPostTags = 10
+	// maxSlugLength matches the tags.slug and posts.category columns.
+	maxSlugLength = 50
+)
+
+var slugSeparator = regexp.MustCompile(`[^\p{L}\p{N}]+`)
+
+// slugify lowercases s and joins its words with hyphens, so "Go Lang",
+// "go-lang" and "GO_LANG" are the same tag.
+func slugify(s string) string {
+	return strings.Trim(slugSeparator.ReplaceAllString(strings.ToLower(s), "-"), "-")
+}
+
+// validSlug reports whether slug is a non-empty slug that fits the columns.
+func validSlug(slug string) bool {
+	return slug != "" && utf8.RuneCountInString(slug) <= maxSlugLength
+}
+
+// normalizeTags slugifies tags and returns them sorted without duplicates.
+func normalizeTags(tags []string) ([]string, error) {
+	slugs := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		slug := slugify(tag)
+		if !validSlug(slug) {
+			return nil, fmt.Errorf("%q is not a valid tag", tag)
+		}
+		slugs = append(slugs, slug)
+	}
+	slices.Sort(slugs)
+	slugs = slices.Compact(slugs)
+
+	if len(slugs) > maxPostTags {
+		return nil, fmt.Errorf("at most %d tags are allowed", maxPostTags)
+	}
+	return slugs, nil
+}
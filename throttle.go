@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// loginFreeAttempts is how many failures a username gets before each new
+// attempt has to wait.
+const loginFreeAttempts = 3
+
+// loginThrottle is one counter consulted on login. Failures beyond free are
+// delayed exponentially, and reaching max locks the key out.
+type loginThrottle struct {
+	key  string
+	free int
+	max  int
+}
+
+// loginThrottles returns the per-username and per-IP counters for a login.
+// The IP counter tolerates more failures since many users may share one
+// address.
+func (s *Server) loginThrottles(username string, r *http.Request) []loginThrottle {
+	return []loginThrottle{
+		{key: "user:" + strings.ToLower(username), free: loginFreeAttempts, max: s.Config.LoginMaxAttempts},
+		{key: "ip:" + s.clientIP(r), free: s.Config.LoginMaxAttempts, max: s.Config.LoginIPMaxAttempts},
+	}
+}
+
+// clientIP returns the connection address, unless it is a trusted proxy.
+// Then it walks X-Forwarded-For from the right, past the trusted proxies,
+// to the address the nearest of them saw. Anything further left was sent by
+// the client and cannot be trusted.
+func (s *Server) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	addr, err := netip.ParseAddr(host)
+	if err != nil || !s.trustedProxy(addr) {
+		return host
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			// A proxy would not have added this, so the client did.
+			break
+		}
+		addr = hop.Unmap()
+		if !s.trustedProxy(addr) {
+			break
+		}
+	}
+	return addr.String()
+}
+
+func (s *Server) trustedProxy(addr netip.Addr) bool {
+	for _, prefix := range s.trustedProxies {
+		if prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTrustedProxies parses trusted_proxies entries, each an IP address or
+// a CIDR range.
+func parseTrustedProxies(entries []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(entries))
+	for _, entry := range entries {
+		if addr, err := netip.ParseAddr(entry); err == nil {
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an IP address or CIDR range", entry)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// delay returns how long after the last failure the next attempt is allowed.
+func (t loginThrottle) delay(failures int, base, lockout time.Duration) time.Duration {
+	if failures < t.free {
+		return 0
+	}
+	if failures >= t.max {
+		return lockout
+	}
+
+	delay := base
+	for i := t.free; i < failures && delay < lockout; i++ {
+		delay *= 2
+	}
+	return min(delay, lockout)
+}
+
+// lockedUntil returns when attempts may resume, or the zero time if they
+// are allowed now.
+func (s *Server) lockedUntil(t loginThrottle, attempts LoginAttempts) time.Time {
+	until := attempts.LastFailedAt.Add(t.delay(attempts.Failures, s.Config.LoginBackoffBase, s.Config.LoginLockoutDuration))
+	if !until.After(time.Now()) {
+		return time.Time{}
+	}
+	return until
+}
+
+// loginAttempt is a login attempt counted against its throttles while the
+// credentials are checked.
+type loginAttempt struct {
+	throttles []loginThrottle
+	// previous holds the attempts before this one, for each throttle.
+	previous []LoginAttempts
+}
+
+// startLoginAttempt counts the attempt as a failure against every throttle
+// before the credentials are checked, so parallel requests cannot all get in
+// under the limit. If a throttle makes it wait, it writes a 429 with
+// Retry-After and returns false.
+func (s *Server) startLoginAttempt(w http.ResponseWriter, r *http.Request, throttles []loginThrottle) (*loginAttempt, bool) {
+	attempt := &loginAttempt{throttles: throttles}
+
+	for _, t := range throttles {
+		previous, wait, err := s.LoginAttempts.Reserve(r.Context(), t.key, s.Config.LoginLockoutDuration, func(attempts LoginAttempts) time.Duration {
+			if until := s.lockedUntil(t, attempts); !until.IsZero() {
+				return time.Until(until)
+			}
+			return 0
+		})
+		if err != nil {
+			s.releaseLoginAttempt(r.Context(), attempt)
+			http.Error(w, "Failed to check login attempts", http.StatusInternalServerError)
+			return nil, false
+		}
+		if wait > 0 {
+			s.releaseLoginAttempt(r.Context(), attempt)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "Too many failed login attempts, try again later", http.StatusTooManyRequests)
+			return nil, false
+		}
+		attempt.previous = append(attempt.previous, previous)
+	}
+
+	return attempt, true
+}
+
+// releaseLoginAttempt takes back the attempt once it is known not to have
+// failed.
+func (s *Server) releaseLoginAttempt(ctx context.Context, attempt *loginAttempt) {
+	for _, previous := range attempt.previous {
+		if err := s.LoginAttempts.Release(ctx, previous); err != nil {
+			log.Println(err)
+		}
+	}
+	attempt.previous = nil
+}
+
+// recordLoginFailure keeps the attempt counted as a failure and logs the
+// throttles it locked out.
+func (s *Server) recordLoginFailure(attempt *loginAttempt) {
+	for i, previous := range attempt.previous {
+		if failures := previous.Failures + 1; failures == attempt.throttles[i].max {
+			log.Printf("login locked out for %s after %d failed attempts", previous.Key, failures)
+		}
+	}
+}
+
+// resetLoginFailures clears the username counter after a complete login.
+// The IP counter is left alone so one valid account cannot be used to wipe
+// the failures of guesses against others.
+func (s *Server) resetLoginFailures(ctx context.Context, attempt *loginAttempt) {
+	if err := s.LoginAttempts.Reset(ctx, attempt.throttles[0].key); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name       string
+		trusted    []string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"no proxies", nil, "203.0.113.7:4711", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"untrusted peer", []string{"10.0.0.0/8"}, "203.0.113.7:4711", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"trusted peer", []string{"10.0.0.0/8"}, "10.0.0.2:4711", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"spoofed entries", []string{"10.0.0.0/8"}, "10.0.0.2:4711", []string{"1.2.3.4, 198.51.100.1"}, "198.51.100.1"},
+		{"proxy chain", []string{"10.0.0.0/8", "192.0.2.1"}, "10.0.0.2:4711", []string{"1.2.3.4, 198.51.100.1, 192.0.2.1", "10.1.1.1"}, "198.51.100.1"},
+		{"all hops trusted", []string{"10.0.0.0/8"}, "10.0.0.2:4711", []string{"10.0.0.3"}, "10.0.0.3"},
+		{"no header", []string{"10.0.0.0/8"}, "10.0.0.2:4711", nil, "10.0.0.2"},
+		{"garbage hop", []string{"10.0.0.0/8"}, "10.0.0.2:4711", []string{"198.51.100.1, nonsense"}, "10.0.0.2"},
+		{"ipv6", []string{"2001:db8::/32"}, "[2001:db8::1]:4711", []string{"2001:db9::2, 2001:db8::3"}, "2001:db9::2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trusted, err := parseTrustedProxies(tt.trusted)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := &Server{trustedProxies: trusted}
+
+			r := httptest.NewRequest(http.MethodPost, "/login", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+
+			if got := s.clientIP(r); got != tt.want {
+				t.Errorf("clientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	if _, err := parseTrustedProxies([]string{"10.0.0.1", "10.0.0.0/8", "::1", "fd00::/8"}); err != nil {
+		t.Errorf("parseTrustedProxies() error = %v", err)
+	}
+	for _, entry := range []string{"10.0.0", "10.0.0.0/33", "proxy.internal"} {
+		if _, err := parseTrustedProxies([]string{entry}); err == nil {
+			t.Errorf("parseTrustedProxies(%q) succeeded, want an error", entry)
+		}
+	}
+}
+
+func TestLoginThrottleParallel(t *testing.T) {
+	cfg := testConfig()
+	cfg.LoginBackoffBase = time.Minute
+	ts := newTestServerWithConfig(t, cfg)
+	ts.createUser("alice", RoleMember)
+
+	// Guesses sent all at once must not all get past the counter before the
+	// first failure is recorded.
+	const guesses = 20
+	statuses := make(chan int, guesses)
+	var wg sync.WaitGroup
+	for range guesses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := ts.srv.Client().Post(ts.srv.URL+"/login", "application/json", strings.NewReader(`{"username": "alice", "password": "wrong password"}`))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+			statuses <- resp.StatusCode
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	counts := make(map[int]int)
+	for status := range statuses {
+		counts[status]++
+	}
+	if counts[http.StatusUnauthorized] != loginFreeAttempts || counts[http.StatusTooManyRequests] != guesses-loginFreeAttempts {
+		t.Errorf("parallel guesses got statuses %v, want %d checked and the rest throttled", counts, loginFreeAttempts)
+	}
+
+	attempts, err := ts.LoginAttempts.Get(context.Background(), "user:alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts.Failures != loginFreeAttempts {
+		t.Errorf("recorded %d failures, want %d", attempts.Failures, loginFreeAttempts)
+	}
+}
+
+func TestLoginThrottleReleasesSuccessfulAttempts(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser("alice", RoleMember)
+
+	for range loginFreeAttempts + 1 {
+		ts.login("alice")
+	}
+
+	// Successful logins do not count against the address either.
+	for _, key := range []string{"user:alice", "ip:127.0.0.1"} {
+		if _, err := ts.LoginAttempts.Get(context.Background(), key); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s after successful logins: got %v, want ErrNotFound", key, err)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRefreshTokenRotation(t *testing.T) {
+	ts := newTestServer(t)
+	ts.createUser("alice", RoleMember)
+	first := ts.login("alice")
+
+	refresh := func(token string) *http.Response {
+		return ts.do(http.MethodPost, "/token/refresh", "", RefreshRequest{RefreshToken: token})
+	}
+
+	second := decodeResponse[TokenResponse](t, refresh(first.RefreshToken), http.StatusOK)
+	if second.RefreshToken == first.RefreshToken {
+		t.Fatal("refresh did not rotate the refresh token")
+	}
+	expectStatus(t, ts.do(http.MethodGet, "/me", second.Token, nil), http.StatusOK)
+
+	// Replaying a rotated token revokes its whole family, including the
+	// token that replaced it.
+	expectStatus(t, refresh(first.RefreshToken), http.StatusUnauthorized)
+	expectStatus(t, refresh(second.RefreshToken), http.StatusUnauthorized)
+
+	expectStatus(t, refresh("not-a-token"), http.StatusUnauthorized)
+	expectStatus(t, ts.do(http.MethodPost, "/token/refresh", "", "{}"), http.StatusBadRequest)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfc6238Secret is the SHA-1 seed from RFC 6238 Appendix B.
+var rfc6238Secret = totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCode(t *testing.T) {
+	// The RFC lists 8 digit codes; ours are their last 6 digits.
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got, err := totpCode(rfc6238Secret, totpStep(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("totpCode at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+
+	if got, err := totpCode(strings.ToLower(rfc6238Secret), totpStep(time.Unix(59, 0))); err != nil || got != "287082" {
+		t.Errorf("totpCode with a lower case secret = %s, %v, want 287082", got, err)
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := totpStep(now)
+
+	tests := []struct {
+		name   string
+		offset int64
+		ok     bool
+	}{
+		{"current step", 0, true},
+		{"previous step", -1, true},
+		{"next step", 1, true},
+		{"two steps behind", -2, false},
+		{"two steps ahead", 2, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := totpCode(rfc6238Secret, current+tt.offset)
+			if err != nil {
+				t.Fatal(err)
+			}
+			step, ok := verifyTOTP(rfc6238Secret, code, now)
+			if ok != tt.ok {
+				t.Fatalf("verifyTOTP(%s) ok = %v, want %v", code, ok, tt.ok)
+			}
+			if ok && step != current+tt.offset {
+				t.Errorf("verifyTOTP(%s) step = %d, want %d", code, step, current+tt.offset)
+			}
+		})
+	}
+
+	if _, ok := verifyTOTP(rfc6238Secret, "050 471", now); !ok {
+		t.Error("verifyTOTP rejected a code with a space")
+	}
+	if _, ok := verifyTOTP(rfc6238Secret, "50471", now); ok {
+		t.Error("verifyTOTP accepted a 5 digit code")
+	}
+}
+
+func TestLoginMFASingleUse(t *testing.T) {
+	ts := newTestServer(t)
+	alice := ts.createUser("alice", RoleMember)
+	secret, codes := ts.enableTOTP(alice)
+
+	challenge := func() string {
+		t.Helper()
+		resp := ts.do(http.MethodPost, "/login", "", map[string]string{"username": "alice", "password": testPassword})
+		challenge := decodeResponse[MFAChallenge](t, resp, http.StatusOK)
+		if !challenge.MFARequired || challenge.MFAToken == "" {
+			t.Fatalf("login returned %+v, want an MFA challenge", challenge)
+		}
+		return challenge.MFAToken
+	}
+
+	code, err := totpCode(secret, totpStep(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectStatus(t, ts.do(http.MethodPost, "/login/mfa", "", MFALogin{MFAToken: challenge(), Code: code}), http.StatusOK)
+	expectStatus(t, ts.do(http.MethodPost, "/login/mfa", "", MFALogin{MFAToken: challenge(), Code: code}), http.StatusUnauthorized)
+
+	expectStatus(t, ts.do(http.MethodPost, "/login/mfa", "", MFALogin{MFAToken: challenge(), RecoveryCode: codes[0]}), http.StatusOK)
+	expectStatus(t, ts.do(http.MethodPost, "/login/mfa", "", MFALogin{MFAToken: challenge(), RecoveryCode: codes[0]}), http.StatusUnauthorized)
+
+	// Recovery codes can be typed back without the dash or in upper case.
+	typed := strings.ToUpper(strings.ReplaceAll(codes[1], "-", " "))
+	expectStatus(t, ts.do(http.MethodPost, "/login/mfa", "", MFALogin{MFAToken: challenge(), RecoveryCode: typed}), http.StatusOK)
+}
+
+func TestDisableTOTPThrottled(t *testing.T) {
+	cfg := testConfig()
+	cfg.LoginBackoffBase = time.Minute
+	ts := newTestServerWithConfig(t, cfg)
+	alice := ts.createUser("alice", RoleMember)
+	secret, _ := ts.enableTOTP(alice)
+	token := ts.accessToken(alice, true, time.Now())
+
+	code, err := totpCode(secret, totpStep(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range loginFreeAttempts {
+		expectStatus(t, ts.do(http.MethodDelete, "/me/mfa/totp", token, DisableTOTPRequest{Password: "wrong password", Code: code}), http.StatusUnauthorized)
+	}
+	resp := ts.do(http.MethodDelete, "/me/mfa/totp", token, DisableTOTPRequest{Password: testPassword, Code: code})
+	expectStatus(t, resp, http.StatusTooManyRequests)
+	if resp.Header.Get("Retry-After") == "" {
+		t.Error("throttled response has no Retry-After")
+	}
+	expectStatus(t, ts.do(http.MethodPost, "/login", "", UserLogin{Username: "alice", Password: testPassword}), http.StatusTooManyRequests)
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPatchUser(t *testing.T) {
+	ts := newTestServer(t)
+	admin := ts.createUser("admin", RoleAdmin)
+	target := ts.createUser("target", RoleMember)
+	token := ts.accessToken(admin, true, time.Now())
+
+	patch := func(contentType, body string) *http.Response {
+		req, err := http.NewRequest(http.MethodPatch, ts.srv.URL+"/user?id="+strconv.Itoa(target.ID), strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", contentType)
+		return ts.send(req)
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        int
+	}{
+		{"merge patch", mergePatchContentType, `{"email":"new@example.com"}`, http.StatusOK},
+		{"merge patch invalid email", mergePatchContentType, `{"email":"not-an-email"}`, http.StatusBadRequest},
+		{"json patch", jsonPatchContentType, `[{"op":"replace","path":"/role","value":"editor"}]`, http.StatusOK},
+		{"json patch invalid role", jsonPatchContentType, `[{"op":"replace","path":"/role","value":"superuser"}]`, http.StatusBadRequest},
+		{"json patch password", jsonPatchContentType, `[{"op":"add","path":"/password","value":"a long password"}]`, http.StatusBadRequest},
+		{"add root with invalid fields", jsonPatchContentType, `[{"op":"add","path":"","value":{"username":"x","email":"not-an-email","isActive":true,"role":"superuser"}}]`, http.StatusBadRequest},
+		{"replace root with invalid fields", jsonPatchContentType, `[{"op":"replace","path":"","value":{"username":"target","email":"target@example.com","isActive":true,"role":"superuser"}}]`, http.StatusBadRequest},
+		{"replace root", jsonPatchContentType, `[{"op":"replace","path":"","value":{"username":"renamed","email":"renamed@example.com","isActive":true,"role":"member"}}]`, http.StatusOK},
+		{"merge patch status", mergePatchContentType, `{"isActive":false}`, http.StatusBadRequest},
+		{"unsupported content type", "application/json", `{}`, http.StatusUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expectStatus(t, patch(tt.contentType, tt.body), tt.want)
+		})
+	}
+
+	user, err := ts.Users.Get(context.Background(), target.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Username != "renamed" || user.Email != "renamed@example.com" || user.Role != RoleMember {
+		t.Errorf("stored user = %+v, want the replaced document", user)
+	}
+}
+
+func TestUpdateUserKeepsStatus(t *testing.T) {
+	ts := newTestServer(t)
+	admin := ts.createUser("admin", RoleAdmin)
+	target := ts.createUser("target", RoleMember)
+	token := ts.accessToken(admin, true, time.Now())
+
+	if err := ts.Users.SetActive(context.Background(), target.ID, false, "spam"); err != nil {
+		t.Fatal(err)
+	}
+
+	body := map[string]any{
+		"id":       target.ID,
+		"username": "target",
+		"email":    "changed@example.com",
+		"isActive": true,
+		"role":     "member",
+	}
+	expectStatus(t, ts.do(http.MethodPut, "/user", token, body), http.StatusOK)
+
+	user, err := ts.Users.Get(context.Background(), target.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != "changed@example.com" {
+		t.Errorf("email = %q, want the new one", user.Email)
+	}
+	if user.IsActive || user.DeactivatedAt == nil || user.DeactivationReason == nil || *user.DeactivationReason != "spam" {
+		t.Errorf("edited user = %+v, want it still deactivated for spam", user)
+	}
+}
+
+func TestUpdateUserKeepsPassword(t *testing.T) {
+	ts := newTestServer(t)
+	admin := ts.createUser("admin", RoleAdmin)
+	target := ts.createUser("target", RoleMember)
+	token := ts.accessToken(admin, true, time.Now())
+
+	body := map[string]any{
+		"id":       target.ID,
+		"username": "target",
+		"email":    "target@example.com",
+		"isActive": true,
+		"role":     "editor",
+	}
+	expectStatus(t, ts.do(http.MethodPut, "/user", token, body), http.StatusOK)
+
+	body["password"] = "a new long password"
+	expectStatus(t, ts.do(http.MethodPut, "/user", token, body), http.StatusBadRequest)
+
+	// The role changed, and the password did not.
+	if user := ts.me(ts.login("target").Token); user.Role != RoleEditor {
+		t.Errorf("role = %q, want editor", user.Role)
+	}
+}
+
+func TestListUsersByCreatedAt(t *testing.T) {
+	ts := newTestServer(t)
+	admin := ts.createUser("admin", RoleAdmin)
+	token := ts.accessToken(admin, true, time.Now())
+
+	// Users created in the same instant share a sort key, so pages must
+	// break ties on id.
+	base := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	created := []time.Duration{0, 0, time.Second, time.Second, time.Second, 2 * time.Second}
+	store := ts.Users.(*MemoryUserStore)
+	var want []int
+	for i, offset := range created {
+		user := ts.createUser("user"+strconv.Itoa(i), RoleMember)
+		store.mu.Lock()
+		user.CreatedAt = base.Add(offset)
+		store.users[user.ID] = user
+		store.mu.Unlock()
+		want = append(want, user.ID)
+	}
+	store.mu.Lock()
+	adminUser := store.users[admin.ID]
+	adminUser.CreatedAt = base.Add(-time.Second)
+	store.users[admin.ID] = adminUser
+	store.mu.Unlock()
+	want = append([]int{admin.ID}, want...)
+
+	list := func(params url.Values) Page[User] {
+		t.Helper()
+		return decodeResponse[Page[User]](t, ts.do(http.MethodGet, "/user?"+params.Encode(), token, nil), http.StatusOK)
+	}
+
+	var got []int
+	params := url.Values{"sort": {"createdAt"}, "limit": {"2"}}
+	for range want {
+		page := list(params)
+		for _, user := range page.Data {
+			got = append(got, user.ID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		params.Set("after", page.NextCursor)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("paging by createdAt returned %v, want %v", got, want)
+	}
+
+	// An offset in createdAfter names the same instant as its UTC time.
+	after := base.Add(time.Second).In(time.FixedZone("IST", 5*3600+1800)).Format(time.RFC3339)
+	page := list(url.Values{"sort": {"createdAt"}, "createdAfter": {after}})
+	if len(page.Data) != 1 || page.Data[0].ID != want[len(want)-1] {
+		t.Errorf("createdAfter=%s returned %+v, want user %d only", after, page.Data, want[len(want)-1])
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+type userStatusEntry struct {
+	active    bool
+	expiresAt time.Time
+}
+
+// UserStatusCache remembers for a short while whether users are active, so
+// AuthMiddleware does not load the user on every request. A user that no
+// longer exists counts as inactive.
+type UserStatusCache struct {
+	mu      sync.Mutex
+	users   UserStore
+	ttl     time.Duration
+	entries map[int]userStatusEntry
+}
+
+func NewUserStatusCache(users UserStore, ttl time.Duration) *UserStatusCache {
+	return &UserStatusCache{users: users, ttl: ttl, entries: make(map[int]userStatusEntry)}
+}
+
+func (c *UserStatusCache) IsActive(ctx context.Context, userID int) (bool, error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	entry, ok := c.entries[userID]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expiresAt) {
+		return entry.active, nil
+	}
+
+	user, err := c.users.Get(ctx, userID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return false, err
+	}
+	active := err == nil && user.IsActive
+
+	if c.ttl > 0 {
+		c.mu.Lock()
+		for id, entry := range c.entries {
+			if !now.Before(entry.expiresAt) {
+				delete(c.entries, id)
+			}
+		}
+		c.entries[userID] = userStatusEntry{active: active, expiresAt: now.Add(c.ttl)}
+		c.mu.Unlock()
+	}
+
+	return active, nil
+}
+
+// Invalidate drops the cached status so the next request sees a change made
+// by this instance straight away.
+func (c *UserStatusCache) Invalidate(userID int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, userID)
+}
@@ -2,12 +2,17 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/crypto/bcrypt"
 )
 
 func CustomJsonResponse(w http.ResponseWriter, status int, data interface{}) {
@@ -19,33 +24,66 @@ func CustomJsonResponse(w http.ResponseWriter, status int, data interface{}) {
 	}
 }
 
-func HashPassword(password string) (string, error) {
-	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+// accessTokenAudience tells access tokens apart from the other JWTs signed
+// with the same keys, such as MFA challenges and email verification tokens.
+const accessTokenAudience = "access"
+
+// tokenIssuer names this server in the iss claim of its access tokens.
+func (s *Server) tokenIssuer() string {
+	return strings.TrimRight(s.Config.PublicURL, "/")
+}
+
+func (s *Server) generateJWTWithClaims(user User, mfa bool) (string, error) {
+	jti, err := randomID()
 	if err != nil {
 		return "", err
 	}
-	return string(hash), nil
-}
 
-func CompareHash(password, hash string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-}
-
-func generateJWTWithClaims(user User) (string, error) {
+	now := time.Now()
 
 	claims := UserClaims{
+		ID:       user.ID,
 		Username: user.Username,
+		Role:     user.Role,
+		MFA:      mfa,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			ID:        jti,
+			Issuer:    s.tokenIssuer(),
+			Subject:   strconv.Itoa(user.ID),
+			Audience:  jwt.ClaimStrings{accessTokenAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.Config.AccessTokenTTL)),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	return token.SignedString([]byte(JWT_SECRET))
+	return s.Keys.Sign(claims)
 }
 
 func GetUserFromContext(ctx context.Context) (*UserClaims, bool) {
 	user, ok := ctx.Value("username").(*UserClaims)
 	return user, ok
 }
+
+// generateOpaqueToken returns a random URL-safe token and the hash to store
+// in its place.
+func generateOpaqueToken() (token, tokenHash string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(buf)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}